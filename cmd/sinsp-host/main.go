@@ -0,0 +1,247 @@
+// Command sinsp-host loads a plugin shared object and runs it through the
+// whole lifecycle, as libsinsp would: it resolves the exported symbols,
+// reporting the missing ones, initializes the plugin, reads events from
+// source plugins, extracts all the fields from them, synchronously or with
+// the async extraction protocol, and finally destroys the plugin.
+//
+// Usage:
+//
+//     sinsp-host [flags] <plugin.so>
+//
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"math"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/ldegio/libsinsp-plugin-sdk-go/pkg/sinsp"
+	"github.com/ldegio/libsinsp-plugin-sdk-go/pkg/sinsp/host"
+)
+
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *stringList) Set(s string) error {
+	*l = append(*l, s)
+	return nil
+}
+
+var (
+	config      = flag.String("config", "", "configuration passed to plugin_init")
+	params      = flag.String("params", "", "parameters passed to plugin_open")
+	numEvents   = flag.Int("n", 10, "number of events to read from source plugins")
+	maxTimeouts = flag.Int("timeouts", 1000, "consecutive timeouts, 1ms apart, after which reading stops")
+	useBatch    = flag.Bool("batch", false, "read events with plugin_next_batch")
+	useAsync    = flag.Bool("async", false, "extract fields with the async extraction protocol")
+	inputs      stringList
+	args        = make(map[string]string)
+)
+
+type argMap map[string]string
+
+func (m argMap) String() string {
+	var l []string
+	for name, arg := range m {
+		l = append(l, name+"="+arg)
+	}
+	return strings.Join(l, ",")
+}
+
+func (m argMap) Set(s string) error {
+	i := strings.Index(s, "=")
+	if i <= 0 {
+		return fmt.Errorf("expected <field>=<argument>, got %q", s)
+	}
+	m[s[:i]] = s[i+1:]
+	return nil
+}
+
+func main() {
+	flag.Var(&inputs, "input", "event data used by extractor plugins (repeatable)")
+	flag.Var(argMap(args), "arg", "argument of a field, as <field>=<argument> (repeatable)")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [flags] <plugin.so>\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	if err := run(flag.Arg(0)); err != nil {
+		fmt.Fprintf(os.Stderr, "error: %s\n", err.Error())
+		os.Exit(1)
+	}
+}
+
+func run(path string) error {
+	p, err := host.Load(path)
+	if err != nil {
+		return err
+	}
+
+	for _, name := range p.MissingOptional() {
+		fmt.Printf("optional symbol not exported: %s\n", name)
+	}
+	if missing := p.Missing(); len(missing) > 0 {
+		return fmt.Errorf("required symbols not exported: %s", strings.Join(missing, ", "))
+	}
+	for _, name := range p.UnavailableFields() {
+		fmt.Printf("field not extractable without plugin_extract_buf: %s\n", name)
+	}
+
+	fmt.Printf("type:                 %d\n", p.Type())
+	fmt.Printf("id:                   %d\n", p.ID())
+	fmt.Printf("name:                 %s\n", p.Name())
+	fmt.Printf("description:          %s\n", p.Description())
+	fmt.Printf("required api version: %s\n", p.RequiredAPIVersion())
+
+	fields, err := p.Fields()
+	if err != nil {
+		return err
+	}
+	for i, f := range fields {
+		name := f.Name
+		if f.Arg != nil {
+			name += "[arg]"
+		}
+		fmt.Printf("field %d: %s (%s) %s\n", i, name, f.Type, f.Desc)
+	}
+
+	err = p.Init(*config)
+	defer p.Destroy()
+	if err != nil {
+		return err
+	}
+	if *useAsync {
+		if err := p.EnableAsync(); err != nil {
+			return err
+		}
+	}
+
+	if p.Type() == sinsp.TypeExtractorPlugin {
+		for i, in := range inputs {
+			if err := printEvent(p, fields, uint64(i+1), &host.Event{Data: []byte(in)}); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return readEvents(p, fields)
+}
+
+func readEvents(p *host.Plugin, fields []sinsp.FieldEntry) error {
+	c, err := p.Open(*params)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	var evtnum uint64
+	timeouts := 0
+	for int(evtnum) < *numEvents && timeouts < *maxTimeouts {
+		var evts []host.Event
+		if *useBatch {
+			evts, err = c.NextBatch()
+		} else {
+			var evt *host.Event
+			if evt, err = c.Next(); err == nil {
+				evts = append(evts, *evt)
+			}
+		}
+
+		switch {
+		case errors.Is(err, sinsp.ErrTimeout):
+			timeouts++
+			time.Sleep(time.Millisecond)
+			continue
+		case errors.Is(err, sinsp.ErrEOF):
+			fmt.Println("eof")
+			return nil
+		case err != nil:
+			return err
+		}
+
+		timeouts = 0
+		for i := range evts {
+			if int(evtnum) >= *numEvents {
+				break
+			}
+			evtnum++
+			if err := printEvent(p, fields, evtnum, &evts[i]); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func printEvent(p *host.Plugin, fields []sinsp.FieldEntry, evtnum uint64, evt *host.Event) error {
+	s := fmt.Sprintf("%q", evt.Data)
+	if p.Has("plugin_event_to_string") {
+		var err error
+		if s, err = p.EventToString(evt.Data); err != nil {
+			return err
+		}
+	}
+	fmt.Printf("event %d ts=%d len=%d: %s\n", evtnum, evt.Ts, len(evt.Data), s)
+
+	for i, f := range fields {
+		name := f.Name
+		var arg *string
+		if a, ok := args[f.Name]; ok {
+			name += "[" + a + "]"
+			arg = &a
+		} else if f.Arg != nil && f.Arg.Required {
+			// Cannot be extracted without an argument
+			continue
+		}
+
+		v, err := p.Extract(evtnum, uint32(i), f.Type, arg, evt.Data)
+		if sinsp.ErrorCode(err) == sinsp.ScapNotSupported {
+			fmt.Printf("  %s = <unavailable>\n", name)
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		fmt.Printf("  %s = %s\n", name, formatValue(f.Type, v))
+	}
+	return nil
+}
+
+func formatValue(fieldType sinsp.FieldType, v host.Value) string {
+	switch {
+	case !v.Present:
+		return "<not present>"
+	case fieldType == sinsp.FieldTypeString:
+		return fmt.Sprintf("%q", v.Str)
+	case v.Buf != nil:
+		return fmt.Sprintf("%x", v.Buf)
+	}
+
+	// The other types are encoded into 64 bits as described in sinsp.FieldRegistry.ExtractU64()
+	switch fieldType {
+	case sinsp.FieldTypeInt64:
+		return fmt.Sprintf("%d", int64(v.U64))
+	case sinsp.FieldTypeBool:
+		return fmt.Sprintf("%t", v.U64 != 0)
+	case sinsp.FieldTypeDouble:
+		return fmt.Sprintf("%g", math.Float64frombits(v.U64))
+	case sinsp.FieldTypeAbsTime:
+		return time.Unix(0, int64(v.U64)).UTC().Format(time.RFC3339Nano)
+	case sinsp.FieldTypeRelTime:
+		return time.Duration(v.U64).String()
+	default:
+		return fmt.Sprintf("%d", v.U64)
+	}
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/ldegio/libsinsp-plugin-sdk-go/pkg/sinsp"
+	"github.com/ldegio/libsinsp-plugin-sdk-go/pkg/sinsp/host"
+)
+
+type eventInfo struct {
+	Num    uint64 `json:"num"`
+	Ts     uint64 `json:"ts"`
+	Len    int    `json:"len"`
+	String string `json:"string"`
+}
+
+func runEvents(args []string) error {
+	fs := newFlagSet("events")
+	jsonOut := fs.Bool("json", false, "print JSON lines instead of text")
+	config := fs.String("config", "", "configuration passed to plugin_init")
+	params := fs.String("params", "", "parameters passed to plugin_open")
+	n := fs.Int("n", 10, "number of events to dump")
+	useBatch := fs.Bool("batch", false, "read events with plugin_next_batch")
+	timeout := fs.Duration("timeout", 5*time.Second, "time without events after which reading stops")
+	path := pluginArg(fs, args)
+
+	p, err := host.Load(path)
+	if err != nil {
+		return err
+	}
+	if p.Type() != sinsp.TypeSourcePlugin {
+		return fmt.Errorf("%s is not a source plugin", path)
+	}
+
+	err = p.Init(*config)
+	defer p.Destroy()
+	if err != nil {
+		return err
+	}
+	c, err := p.Open(*params)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	enc := json.NewEncoder(os.Stdout)
+	var num uint64
+	last := time.Now()
+	for int(num) < *n {
+		var evts []host.Event
+		if *useBatch {
+			evts, err = c.NextBatch()
+		} else {
+			var evt *host.Event
+			if evt, err = c.Next(); err == nil {
+				evts = append(evts, *evt)
+			}
+		}
+
+		switch {
+		case errors.Is(err, sinsp.ErrTimeout):
+			if time.Since(last) >= *timeout {
+				return fmt.Errorf("no events for %s", timeout.String())
+			}
+			time.Sleep(time.Millisecond)
+			continue
+		case errors.Is(err, sinsp.ErrEOF):
+			return nil
+		case err != nil:
+			return err
+		}
+
+		last = time.Now()
+		for _, evt := range evts {
+			if int(num) >= *n {
+				break
+			}
+			num++
+			s, err := p.EventToString(evt.Data)
+			if err != nil {
+				return err
+			}
+
+			info := &eventInfo{Num: num, Ts: evt.Ts, Len: len(evt.Data), String: s}
+			if *jsonOut {
+				if err := enc.Encode(info); err != nil {
+					return err
+				}
+				continue
+			}
+			fmt.Printf("%d %s len=%d %s\n", info.Num, time.Unix(0, int64(info.Ts)).UTC().Format(time.RFC3339Nano), info.Len, info.String)
+		}
+	}
+	return nil
+}
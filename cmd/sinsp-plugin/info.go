@@ -0,0 +1,124 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/ldegio/libsinsp-plugin-sdk-go/pkg/sinsp"
+	"github.com/ldegio/libsinsp-plugin-sdk-go/pkg/sinsp/host"
+)
+
+type pluginInfo struct {
+	Path               string             `json:"path"`
+	Type               string             `json:"type"`
+	ID                 uint32             `json:"id"`
+	Name               string             `json:"name"`
+	Description        string             `json:"description"`
+	RequiredAPIVersion string             `json:"requiredAPIVersion"`
+	Fields             []sinsp.FieldEntry `json:"fields"`
+	MissingSymbols     []string           `json:"missingSymbols,omitempty"`
+	UnavailableFields  []string           `json:"unavailableFields,omitempty"`
+}
+
+func typeName(t uint32) string {
+	switch t {
+	case sinsp.TypeSourcePlugin:
+		return "source"
+	case sinsp.TypeExtractorPlugin:
+		return "extractor"
+	default:
+		return fmt.Sprintf("unknown (%d)", t)
+	}
+}
+
+func readInfo(p *host.Plugin) (*pluginInfo, error) {
+	fields, err := p.Fields()
+	if err != nil {
+		return nil, err
+	}
+	return &pluginInfo{
+		Path:               p.Path(),
+		Type:               typeName(p.Type()),
+		ID:                 p.ID(),
+		Name:               p.Name(),
+		Description:        p.Description(),
+		RequiredAPIVersion: p.RequiredAPIVersion(),
+		Fields:             fields,
+		MissingSymbols:     p.Missing(),
+		UnavailableFields:  p.UnavailableFields(),
+	}, nil
+}
+
+func runInfo(args []string) error {
+	fs := newFlagSet("info")
+	jsonOut := fs.Bool("json", false, "print JSON instead of text")
+	path := pluginArg(fs, args)
+
+	p, err := host.Load(path)
+	if err != nil {
+		return err
+	}
+	info, err := readInfo(p)
+	if err != nil {
+		return err
+	}
+
+	if *jsonOut {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(info)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(w, "Type:\t%s\n", info.Type)
+	fmt.Fprintf(w, "ID:\t%d\n", info.ID)
+	fmt.Fprintf(w, "Name:\t%s\n", info.Name)
+	fmt.Fprintf(w, "Description:\t%s\n", info.Description)
+	fmt.Fprintf(w, "Required API version:\t%s\n", info.RequiredAPIVersion)
+	for _, s := range info.MissingSymbols {
+		fmt.Fprintf(w, "Missing symbol:\t%s\n", s)
+	}
+	for _, f := range info.UnavailableFields {
+		fmt.Fprintf(w, "Unavailable field:\t%s (plugin_extract_buf not exported)\n", f)
+	}
+	w.Flush()
+
+	if len(info.Fields) == 0 {
+		return nil
+	}
+	fmt.Println()
+	w = tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tNAME\tTYPE\tARG\tDISPLAY\tPROPERTIES\tDESCRIPTION")
+	for i, f := range info.Fields {
+		fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%s\t%s\t%s\n", i, f.Name, f.Type, fieldArg(f.Arg), f.Display, fieldProperties(f.Properties), f.Desc)
+	}
+	return w.Flush()
+}
+
+func fieldProperties(props []sinsp.FieldProperty) string {
+	l := make([]string, len(props))
+	for i, p := range props {
+		l[i] = string(p)
+	}
+	return strings.Join(l, ",")
+}
+
+// fieldArg describes the argument taken by a field, if any.
+func fieldArg(a *sinsp.FieldArg) string {
+	var s string
+	switch {
+	case a == nil:
+		return ""
+	case a.Index:
+		s = "index"
+	case a.Key:
+		s = "key"
+	}
+	if !a.Required {
+		s += " (optional)"
+	}
+	return s
+}
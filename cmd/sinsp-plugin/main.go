@@ -0,0 +1,99 @@
+// Command sinsp-plugin is a tool for developing plugins without running a
+// libsinsp host.
+//
+// Usage:
+//
+//     sinsp-plugin info [flags] <plugin.so>
+//     sinsp-plugin events [flags] <plugin.so>
+//     sinsp-plugin new [flags]
+//
+// The info command prints the metadata and the fields of a built plugin,
+// and the events command opens it and dumps its events through
+// plugin_event_to_string. Both print text by default, or JSON with -json.
+//
+// The new command generates a new plugin, with a makefile building it and a
+// starter test using the sinsptest package, from its flags or from a YAML spec:
+//
+//     name: myplugin
+//     id: 999
+//     description: my plugin
+//     module: github.com/me/myplugin
+//     kind: both # source, extractor or both
+//     async: true
+//     batch: true
+//     fields:
+//       - name: myplugin.user
+//         type: string
+//         desc: The user name
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+)
+
+type command struct {
+	usage string
+	run   func(args []string) error
+}
+
+var commands map[string]*command
+
+func init() {
+	commands = map[string]*command{
+		"info":   {usage: "info [flags] <plugin.so>", run: runInfo},
+		"events": {usage: "events [flags] <plugin.so>", run: runEvents},
+		"new":    {usage: "new [flags]", run: runNew},
+	}
+}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "Usage:\n")
+	var names []string
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(os.Stderr, "  %s %s\n", os.Args[0], commands[name].usage)
+	}
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(2)
+	}
+	cmd, ok := commands[os.Args[1]]
+	if !ok {
+		usage()
+		os.Exit(2)
+	}
+
+	if err := cmd.run(os.Args[2:]); err != nil {
+		fmt.Fprintf(os.Stderr, "error: %s\n", err.Error())
+		os.Exit(1)
+	}
+}
+
+// newFlagSet returns the flag set of the command name, printing its usage on errors.
+func newFlagSet(name string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s %s\n", os.Args[0], commands[name].usage)
+		fs.PrintDefaults()
+	}
+	return fs
+}
+
+// pluginArg parses args with fs and returns the only positional argument, the plugin path.
+func pluginArg(fs *flag.FlagSet, args []string) string {
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	return fs.Arg(0)
+}
@@ -0,0 +1,507 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"go/format"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"text/template"
+
+	"gopkg.in/yaml.v2"
+
+	"github.com/ldegio/libsinsp-plugin-sdk-go/pkg/sinsp"
+)
+
+const sdkModule = "github.com/ldegio/libsinsp-plugin-sdk-go"
+
+// Plugin kinds accepted by the new command
+const (
+	kindSource    = "source"
+	kindExtractor = "extractor"
+	kindBoth      = "both"
+)
+
+// spec describes the plugin generated by the new command, as read from a
+// YAML file or from the command line flags. Batch only generates the BatchOptions
+// of the plugin: source plugins always export plugin_next_batch, with the default
+// options otherwise.
+type spec struct {
+	Name        string      `yaml:"name"`
+	ID          uint32      `yaml:"id"`
+	Description string      `yaml:"description"`
+	Module      string      `yaml:"module"`
+	Kind        string      `yaml:"kind"`
+	Async       bool        `yaml:"async"`
+	Batch       bool        `yaml:"batch"`
+	Fields      []specField `yaml:"fields"`
+}
+
+type specField struct {
+	Name string          `yaml:"name"`
+	Type sinsp.FieldType `yaml:"type"`
+	Desc string          `yaml:"desc"`
+}
+
+var pluginNameRe = regexp.MustCompile(`^[a-z][a-z0-9_]*$`)
+
+// validate checks s, filling in the defaults for the missing values.
+func (s *spec) validate() error {
+	if !pluginNameRe.MatchString(s.Name) {
+		return fmt.Errorf("invalid plugin name %q: it must start with a lowercase letter and contain only lowercase letters, digits and underscores", s.Name)
+	}
+	if s.Kind == "" {
+		s.Kind = kindSource
+	}
+	if s.Kind != kindSource && s.Kind != kindExtractor && s.Kind != kindBoth {
+		return fmt.Errorf("invalid plugin kind %q: it must be %s, %s or %s", s.Kind, kindSource, kindExtractor, kindBoth)
+	}
+	if s.Batch && s.Kind == kindExtractor {
+		return fmt.Errorf("extractor plugins cannot read events in batches")
+	}
+	if s.Description == "" {
+		s.Description = fmt.Sprintf("the %s plugin", s.Name)
+	}
+
+	if len(s.Fields) == 0 && s.Kind != kindSource {
+		s.Fields = []specField{{Name: s.Name + ".data", Type: sinsp.FieldTypeString, Desc: "The event data"}}
+	}
+	// The fields are checked as sinsp.FieldsBuilder does, and then against
+	// what the generator supports
+	entries := make([]sinsp.FieldEntry, len(s.Fields))
+	for i, f := range s.Fields {
+		entries[i] = sinsp.FieldEntry{ID: uint32(i), Type: f.Type, Name: f.Name, Desc: f.Desc}
+	}
+	if err := sinsp.ValidateFields(s.Name, entries); err != nil {
+		return fmt.Errorf("invalid fields: %s", err.Error())
+	}
+	funcs := make(map[string]string)
+	for _, f := range s.Fields {
+		if fieldKinds[f.Type] == nil {
+			return fmt.Errorf("field %s has a type not supported by the generator %q", f.Name, f.Type)
+		}
+		fn := funcName(s.Name, f.Name)
+		if other, ok := funcs[fn]; ok {
+			return fmt.Errorf("fields %s and %s would both be extracted by %s", other, f.Name, fn)
+		}
+		funcs[fn] = f.Name
+	}
+	return nil
+}
+
+// fieldKind describes how the code for a field of a given type is generated.
+type fieldKind struct {
+	// Constructor of the sinsp.Field, with the ParamType argument if any
+	Constructor string
+	ParamType   string
+	ValueType   string
+	Zero        string
+}
+
+var fieldKinds = map[sinsp.FieldType]*fieldKind{
+	sinsp.FieldTypeString:   {Constructor: "StrField", ValueType: "string", Zero: `""`},
+	sinsp.FieldTypeUint64:   {Constructor: "U64Field", ValueType: "uint64", Zero: "0"},
+	sinsp.FieldTypeInt64:    {Constructor: "Int64Field", ValueType: "int64", Zero: "0"},
+	sinsp.FieldTypeBool:     {Constructor: "BoolField", ValueType: "bool", Zero: "false"},
+	sinsp.FieldTypeDouble:   {Constructor: "DoubleField", ValueType: "float64", Zero: "0"},
+	sinsp.FieldTypeAbsTime:  {Constructor: "AbsTimeField", ValueType: "time.Time", Zero: "time.Time{}"},
+	sinsp.FieldTypeRelTime:  {Constructor: "RelTimeField", ValueType: "time.Duration", Zero: "0"},
+	sinsp.FieldTypeByteBuf:  {Constructor: "BytesField", ValueType: "[]byte", Zero: "nil"},
+	sinsp.FieldTypeIPv4Addr: {Constructor: "IPField", ParamType: "ParamTypeIpv4Addr", ValueType: "net.IP", Zero: "nil"},
+	sinsp.FieldTypeIPv6Addr: {Constructor: "IPField", ParamType: "ParamTypeIpv6Addr", ValueType: "net.IP", Zero: "nil"},
+	sinsp.FieldTypeIPAddr:   {Constructor: "IPField", ParamType: "ParamTypeIpAddr", ValueType: "net.IP", Zero: "nil"},
+	sinsp.FieldTypeIPv4Net:  {Constructor: "IPNetField", ParamType: "ParamTypeIpv4Net", ValueType: "*net.IPNet", Zero: "nil"},
+	sinsp.FieldTypeIPv6Net:  {Constructor: "IPNetField", ParamType: "ParamTypeIpv6Net", ValueType: "*net.IPNet", Zero: "nil"},
+	sinsp.FieldTypeIPNet:    {Constructor: "IPNetField", ParamType: "ParamTypeIpNet", ValueType: "*net.IPNet", Zero: "nil"},
+}
+
+// templateField is a field as used by the templates.
+type templateField struct {
+	specField
+	*fieldKind
+	Func string
+	// whether the generated handler returns the event data
+	Data bool
+}
+
+// templateData is the data the templates are executed with.
+type templateData struct {
+	*spec
+	Fields     []templateField
+	Imports    []string
+	SDKVersion string
+	SDKPath    string
+}
+
+func (d *templateData) Source() bool {
+	return d.Kind != kindExtractor
+}
+
+// funcName returns the name of the extraction function of the field name,
+// e.g. extractUserName for myplugin.user.name.
+func funcName(plugin, name string) string {
+	var b strings.Builder
+	b.WriteString("extract")
+	for _, part := range strings.FieldsFunc(strings.TrimPrefix(name, plugin+"."), func(r rune) bool { return r == '.' || r == '_' }) {
+		b.WriteString(strings.ToUpper(part[:1]) + part[1:])
+	}
+	return b.String()
+}
+
+func newTemplateData(s *spec, sdkVersion, sdkPath string) *templateData {
+	d := &templateData{spec: s, SDKVersion: sdkVersion, SDKPath: sdkPath}
+	imports := map[string]bool{}
+	if d.Source() {
+		imports["fmt"] = true
+	}
+	if s.Batch {
+		imports["time"] = true
+	}
+	for _, f := range s.Fields {
+		k := fieldKinds[f.Type]
+		switch {
+		case strings.HasPrefix(k.ValueType, "time."):
+			imports["time"] = true
+		case strings.Contains(k.ValueType, "net."):
+			imports["net"] = true
+		}
+		d.Fields = append(d.Fields, templateField{
+			specField: f,
+			fieldKind: k,
+			Func:      funcName(s.Name, f.Name),
+			Data:      f.Type == sinsp.FieldTypeString || f.Type == sinsp.FieldTypeByteBuf,
+		})
+	}
+	for _, i := range []string{"fmt", "net", "time"} {
+		if imports[i] {
+			d.Imports = append(d.Imports, i)
+		}
+	}
+	return d
+}
+
+var pluginTemplate = template.Must(template.New("plugin").Parse(`package main
+
+import (
+{{- range .Imports}}
+	"{{.}}"
+{{- end}}
+
+	"github.com/ldegio/libsinsp-plugin-sdk-go/pkg/sinsp"
+{{- if .Async}}
+	_ "github.com/ldegio/libsinsp-plugin-sdk-go/pkg/sinsp/async"
+{{- end}}
+{{- if .Source}}
+	"github.com/ldegio/libsinsp-plugin-sdk-go/pkg/sinsp/source"
+{{- else}}
+	"github.com/ldegio/libsinsp-plugin-sdk-go/pkg/sinsp/extractor"
+{{- end}}
+)
+
+// Plugin consts
+const (
+	PluginID          uint32 = {{.ID}}
+	PluginName               = {{printf "%q" .Name}}
+	PluginDescription        = {{printf "%q" .Description}}
+)
+
+///////////////////////////////////////////////////////////////////////////////
+
+type pluginCtx struct{}
+{{- if .Source}}
+
+type openCtx struct {
+	counter uint64
+}
+{{- end}}
+
+func (p *pluginCtx) Info() *sinsp.PluginInfo {
+	return &sinsp.PluginInfo{
+		ID:          PluginID,
+		Name:        PluginName,
+		Description: PluginDescription,
+	}
+}
+
+func (p *pluginCtx) Init(config string) error {
+	return nil
+}
+
+func (p *pluginCtx) Destroy() {}
+{{- if .Source}}
+
+func (p *pluginCtx) Open(params string) (interface{}, error) {
+	return &openCtx{}, nil
+}
+
+func (p *pluginCtx) Close(openState interface{}) {}
+
+func (p *pluginCtx) Next(openState interface{}) (sinsp.Event, error) {
+	o := openState.(*openCtx)
+
+	// TODO: read the next event from the data source, returning sinsp.ErrTimeout
+	// if none is available yet and sinsp.ErrEOF when there are no more.
+	o.counter++
+	return sinsp.Event{Data: []byte(fmt.Sprintf("%s event %d", PluginName, o.counter))}, nil
+}
+
+func (p *pluginCtx) EventToString(data []byte) (string, error) {
+	return string(data), nil
+}
+{{- end}}
+{{- if .Batch}}
+
+func (p *pluginCtx) BatchOptions() sinsp.BatchOptions {
+	return sinsp.BatchOptions{
+		MaxEvents:  1000,
+		MaxLatency: 10 * time.Millisecond,
+	}
+}
+{{- end}}
+{{- if .Fields}}
+
+func (p *pluginCtx) Fields() []sinsp.Field {
+	return []sinsp.Field{
+{{- range .Fields}}
+		sinsp.{{.Constructor}}({{printf "%q" .Name}}, {{printf "%q" .Desc}}, {{if .ParamType}}sinsp.{{.ParamType}}, {{end}}{{.Func}}),
+{{- end}}
+	}
+}
+{{- range .Fields}}
+
+func {{.Func}}(req *sinsp.ExtractRequest) ({{.ValueType}}, bool, error) {
+{{- if .Data}}
+	// TODO: extract the field from the event data
+	return {{if eq .ValueType "string"}}string(req.Data){{else}}req.Data{{end}}, true, nil
+{{- else}}
+	// TODO: extract the field from the event data
+	return {{.Zero}}, false, nil
+{{- end}}
+}
+{{- end}}
+{{- end}}
+
+func init() {
+{{- if .Source}}
+	source.Register(func() sinsp.SourcePlugin { return &pluginCtx{} })
+{{- else}}
+	extractor.Register(func() sinsp.ExtractorPlugin { return &pluginCtx{} })
+{{- end}}
+}
+
+func main() {}
+`))
+
+var testTemplate = template.Must(template.New("test").Parse(`package main
+
+import (
+	"testing"
+
+	"github.com/ldegio/libsinsp-plugin-sdk-go/pkg/sinsp/sinsptest"
+{{- if .Source}}
+	"github.com/ldegio/libsinsp-plugin-sdk-go/pkg/sinsp/source"
+{{- else}}
+	"github.com/ldegio/libsinsp-plugin-sdk-go/pkg/sinsp/extractor"
+{{- end}}
+)
+
+func TestPlugin(t *testing.T) {
+{{- if .Source}}
+	res, err := sinsptest.Run(source.Entrypoints(), &sinsptest.Options{MaxEvents: 10{{if .Batch}}, NextBatch: true{{end}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Events) != 10 {
+		t.Fatalf("expected 10 events, got %d", len(res.Events))
+	}
+{{- else}}
+	res, err := sinsptest.Run(extractor.Entrypoints(), &sinsptest.Options{Inputs: [][]byte{[]byte("test")}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Events) != 1 {
+		t.Fatalf("expected 1 event, got %d", len(res.Events))
+	}
+{{- end}}
+	if res.Name != PluginName {
+		t.Fatalf("expected name %s, got %s", PluginName, res.Name)
+	}
+}
+`))
+
+var makefileTemplate = template.Must(template.New("makefile").Parse(`SHELL=/bin/bash -o pipefail
+
+GO ?= go
+
+.PHONY: lib{{.Name}}.so
+lib{{.Name}}.so:
+	$(GO) build -buildmode=c-shared -o $@ .
+
+# CGOCHECK2 enables the cgocheck2 experiment, available since go1.21.
+CGOCHECK2 := $(shell $(GO) version | grep -qE ' go1\.([0-9]|1[0-9]|20)([^0-9]|$$)' || echo GOEXPERIMENT=cgocheck2)
+
+# debug builds the plugin in the debug mode of the SDK, with the full checks of
+# the cgo pointer passing rules. Up to go1.20, which lacks that experiment, they
+# are enabled at run time instead, by loading the plugin with GODEBUG=cgocheck=2
+# in the environment of the host.
+.PHONY: debug
+debug:
+	$(CGOCHECK2) $(GO) build -buildmode=c-shared -tags sinsp_debug -o lib{{.Name}}.so .
+
+.PHONY: test
+test:
+	$(GO) test ./...
+`))
+
+var gomodTemplate = template.Must(template.New("gomod").Parse(`module {{.Module}}
+
+go 1.15
+{{- if .SDKPath}}
+
+require {{.SDKModule}} {{.SDKVersion}}
+
+replace {{.SDKModule}} => {{.SDKPath}}
+{{- else if .SDKVersion}}
+
+require {{.SDKModule}} {{.SDKVersion}}
+{{- end}}
+`))
+
+func (d *templateData) SDKModule() string {
+	return sdkModule
+}
+
+func runNew(args []string) error {
+	fs := newFlagSet("new")
+	specFile := fs.String("spec", "", "YAML file describing the plugin; flags override its values")
+	s := &spec{}
+	fs.StringVar(&s.Name, "name", "", "plugin name")
+	id := fs.Uint("id", 0, "plugin ID")
+	fs.StringVar(&s.Description, "description", "", "plugin description")
+	fs.StringVar(&s.Module, "module", "", "module path of the plugin; if empty no go.mod is generated")
+	fs.StringVar(&s.Kind, "kind", "", "plugin kind: source, extractor or both (default source)")
+	fs.BoolVar(&s.Async, "async", false, "enable asynchronous extraction")
+	fs.BoolVar(&s.Batch, "batch", false, "generate the BatchOptions of the events read with plugin_next_batch, which is exported anyway")
+	out := fs.String("o", "", "output directory (default the plugin name)")
+	sdkVersion := fs.String("sdk-version", "", "version of the SDK required by go.mod (default resolved by go mod tidy)")
+	sdkPath := fs.String("sdk-path", "", "local path of the SDK, replacing the SDK module in go.mod")
+	force := fs.Bool("force", false, "overwrite existing files")
+	fs.Parse(args)
+	if fs.NArg() != 0 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	if *specFile != "" {
+		flags := *s
+		b, err := ioutil.ReadFile(*specFile)
+		if err != nil {
+			return err
+		}
+		if err := yaml.UnmarshalStrict(b, s); err != nil {
+			return fmt.Errorf("invalid spec %s: %s", *specFile, err.Error())
+		}
+		// flags set explicitly override the spec
+		fs.Visit(func(f *flag.Flag) {
+			switch f.Name {
+			case "name":
+				s.Name = flags.Name
+			case "description":
+				s.Description = flags.Description
+			case "module":
+				s.Module = flags.Module
+			case "kind":
+				s.Kind = flags.Kind
+			case "async":
+				s.Async = flags.Async
+			case "batch":
+				s.Batch = flags.Batch
+			}
+		})
+	}
+	if isFlagSet(fs, "id") || *specFile == "" {
+		s.ID = uint32(*id)
+	}
+	if err := s.validate(); err != nil {
+		return err
+	}
+
+	dir := *out
+	if dir == "" {
+		dir = s.Name
+	}
+	if *sdkPath != "" {
+		p, err := filepath.Abs(*sdkPath)
+		if err != nil {
+			return err
+		}
+		*sdkPath = p
+		if *sdkVersion == "" {
+			*sdkVersion = "v0.0.0"
+		}
+	}
+	d := newTemplateData(s, *sdkVersion, *sdkPath)
+
+	files := []struct {
+		name  string
+		tmpl  *template.Template
+		gofmt bool
+	}{
+		{s.Name + ".go", pluginTemplate, true},
+		{s.Name + "_test.go", testTemplate, true},
+		{"makefile", makefileTemplate, false},
+		{".gitignore", template.Must(template.New("gitignore").Parse("lib{{.Name}}.*\n")), false},
+	}
+	if s.Module != "" {
+		files = append(files, struct {
+			name  string
+			tmpl  *template.Template
+			gofmt bool
+		}{"go.mod", gomodTemplate, false})
+	}
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	for _, f := range files {
+		path := filepath.Join(dir, f.name)
+		if _, err := os.Stat(path); err == nil && !*force {
+			return fmt.Errorf("%s already exists, use -force to overwrite it", path)
+		}
+
+		var b bytes.Buffer
+		if err := f.tmpl.Execute(&b, d); err != nil {
+			return err
+		}
+		src := b.Bytes()
+		if f.gofmt {
+			var err error
+			if src, err = format.Source(src); err != nil {
+				return fmt.Errorf("generating %s: %s", path, err.Error())
+			}
+		}
+		if err := ioutil.WriteFile(path, src, 0644); err != nil {
+			return err
+		}
+		fmt.Println(path)
+	}
+
+	if s.Module != "" && *sdkPath == "" && *sdkVersion == "" {
+		fmt.Printf("run go mod tidy in %s to resolve the dependencies\n", dir)
+	}
+	return nil
+}
+
+func isFlagSet(fs *flag.FlagSet, name string) bool {
+	set := false
+	fs.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			set = true
+		}
+	})
+	return set
+}
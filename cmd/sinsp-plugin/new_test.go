@@ -0,0 +1,112 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const allTypesSpec = `name: alltypes
+id: 999
+kind: both
+fields:
+  - {name: alltypes.str, type: string, desc: a string}
+  - {name: alltypes.u64, type: uint64, desc: an unsigned integer}
+  - {name: alltypes.i64, type: int64, desc: a signed integer}
+  - {name: alltypes.flag, type: bool, desc: a boolean}
+  - {name: alltypes.ratio, type: double, desc: a double}
+  - {name: alltypes.time, type: abstime, desc: a time}
+  - {name: alltypes.duration, type: reltime, desc: a duration}
+  - {name: alltypes.bytes, type: bytebuf, desc: bytes}
+  - {name: alltypes.ip4, type: ipv4addr, desc: an IPv4 address}
+  - {name: alltypes.ip6, type: ipv6addr, desc: an IPv6 address}
+  - {name: alltypes.ip, type: ipaddr, desc: an IP address}
+  - {name: alltypes.net4, type: ipv4net, desc: an IPv4 network}
+  - {name: alltypes.net6, type: ipv6net, desc: an IPv6 network}
+  - {name: alltypes.net, type: ipnet, desc: an IP network}
+`
+
+// goCmd runs the go command in dir, failing the test with its output on error.
+func goCmd(t *testing.T, dir string, args ...string) {
+	t.Helper()
+	cmd := exec.Command("go", args...)
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), "GOFLAGS=-mod=mod")
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("go %s: %s\n%s", strings.Join(args, " "), err.Error(), out)
+	}
+}
+
+// TestNewBuilds generates plugins against this SDK and checks that they
+// pass go vet and build as shared objects.
+func TestNewBuilds(t *testing.T) {
+	if testing.Short() {
+		t.Skip("builds the generated plugins")
+	}
+	if _, err := exec.LookPath("go"); err != nil {
+		t.Skip("go command not found")
+	}
+	sdkPath, err := filepath.Abs(filepath.Join("..", ".."))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tmp := t.TempDir()
+	specFile := filepath.Join(tmp, "alltypes.yaml")
+	if err := ioutil.WriteFile(specFile, []byte(allTypesSpec), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		args []string
+	}{
+		{"source", []string{"-name", "src", "-id", "1"}},
+		{"extractor", []string{"-name", "ext", "-kind", "extractor", "-async"}},
+		{"both", []string{"-name", "both", "-kind", "both", "-async", "-batch"}},
+		{"spec", []string{"-spec", specFile}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := filepath.Join(tmp, tt.name)
+			args := append(tt.args, "-module", "example.com/"+tt.name, "-sdk-path", sdkPath, "-o", dir)
+			if err := runNew(args); err != nil {
+				t.Fatalf("new: %s", err.Error())
+			}
+			goCmd(t, dir, "vet", "./...")
+			goCmd(t, dir, "build", "-buildmode=c-shared", "-o", filepath.Join(dir, "plugin.so"), ".")
+		})
+	}
+}
+
+func TestNewInvalidSpec(t *testing.T) {
+	tests := []struct {
+		spec spec
+		err  string
+	}{
+		{spec{Name: "My-Plugin"}, "invalid plugin name"},
+		{spec{Name: "p", Kind: "filter"}, "invalid plugin kind"},
+		{spec{Name: "p", Kind: kindExtractor, Batch: true}, "cannot read events in batches"},
+		{spec{Name: "p", Fields: []specField{{Name: "q.a", Type: "string", Desc: "a"}}}, `does not start with "p."`},
+		{spec{Name: "p", Fields: []specField{{Name: "p.a b", Type: "string", Desc: "a"}}}, "invalid name"},
+		{spec{Name: "p", Fields: []specField{{Name: "p.a", Type: "string"}}}, "description"},
+		{spec{Name: "p", Fields: []specField{{Name: "p.a", Type: "text", Desc: "a"}}}, "type"},
+		{spec{Name: "p", Fields: []specField{
+			{Name: "p.a", Type: "string", Desc: "a"},
+			{Name: "p.a", Type: "uint64", Desc: "a"},
+		}}, "duplicate name"},
+		{spec{Name: "p", Fields: []specField{
+			{Name: "p.a_b", Type: "string", Desc: "a"},
+			{Name: "p.a.b", Type: "string", Desc: "a"},
+		}}, "would both be extracted by extractAB"},
+	}
+	for i, tt := range tests {
+		err := tt.spec.validate()
+		if err == nil || !strings.Contains(err.Error(), tt.err) {
+			t.Errorf("%d: got %v, want an error containing %q", i, err, tt.err)
+		}
+	}
+}
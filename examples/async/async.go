@@ -2,7 +2,6 @@ package main
 
 import "C"
 import (
-	"encoding/json"
 	"log"
 	"unsafe"
 
@@ -23,9 +22,6 @@ type pluginCtx struct {
 	counter int
 }
 
-// todo: plugin_get_last_error() needs context as argument to avoid having this global
-var gLastError error
-
 //export plugin_get_type
 func plugin_get_type() uint32 {
 	log.Printf("[%s] plugin_get_type\n", PluginName)
@@ -37,23 +33,23 @@ func plugin_init(config *C.char, rc *int32) unsafe.Pointer {
 	log.Printf("[%s] plugin_init\n", PluginName)
 	log.Printf("config string:\n%s\n", C.GoString(config))
 
+	// The state container owns the memory of the extracted strings
+	pState := sinsp.NewStateContainer()
 	*rc = sinsp.ScapSuccess
 
-	return nil
+	return pState
 }
 
 //export plugin_get_last_error
-func plugin_get_last_error() *C.char {
+func plugin_get_last_error(pState unsafe.Pointer) *C.char {
 	log.Printf("[%s] plugin_get_last_error\n", PluginName)
-	if gLastError != nil {
-		return C.CString(gLastError.Error())
-	}
-	return nil
+	return (*C.char)(unsafe.Pointer(sinsp.LastErrorStr(pState)))
 }
 
 //export plugin_destroy
 func plugin_destroy(pState unsafe.Pointer) {
 	log.Printf("[%s] plugin_destroy\n", PluginName)
+	sinsp.Free(pState)
 }
 
 //export plugin_get_id
@@ -74,7 +70,7 @@ func plugin_get_description() *C.char {
 	return C.CString(PluginDescription)
 }
 
-// export plugin_get_required_api_version
+//export plugin_get_required_api_version
 func plugin_get_required_api_version() *C.char {
 	return C.CString("1.0.0")
 }
@@ -82,13 +78,12 @@ func plugin_get_required_api_version() *C.char {
 //export plugin_get_fields
 func plugin_get_fields() *C.char {
 	log.Printf("[%s] plugin_get_fields\n", PluginName)
-	flds := []sinsp.FieldEntry{
-		{Type: "string", Name: "async.field", Desc: "TBD"},
-	}
+	flds := sinsp.NewFieldsBuilder(PluginName)
+	flds.Add(sinsp.FieldTypeString, "async.field", "TBD")
 
-	b, err := json.Marshal(&flds)
+	b, err := flds.JSON()
 	if err != nil {
-		gLastError = err
+		sinsp.SetLastError(nil, err)
 		return nil
 	}
 
@@ -98,7 +93,7 @@ func plugin_get_fields() *C.char {
 //export plugin_extract_str
 func plugin_extract_str(pluginState unsafe.Pointer, evtnum uint64, id uint32, arg *byte, data *byte, datalen uint32) *byte {
 	//log.Printf("[%s] plugin_extract_str\n", PluginName)
-	return (*byte)(unsafe.Pointer(C.CString("ciao")))
+	return sinsp.ResultStr(pluginState, "ciao")
 }
 
 //export plugin_extract_u64
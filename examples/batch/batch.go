@@ -6,10 +6,10 @@ package main
 */
 import "C"
 import (
-	"encoding/json"
 	"fmt"
 	"log"
 	"math/rand"
+	"strings"
 	"unsafe"
 
 	"github.com/ldegio/libsinsp-plugin-sdk-go/pkg/sinsp"
@@ -32,9 +32,6 @@ type pluginCtx struct {
 	counter int
 }
 
-// todo: plugin_get_last_error() needs context as argument to avoid having this global
-var gLastError error
-
 //export plugin_get_type
 func plugin_get_type() uint32 {
 	log.Printf("[%s] plugin_get_type\n", PluginName)
@@ -46,20 +43,22 @@ func plugin_init(config *C.char, rc *int32) unsafe.Pointer {
 	log.Printf("[%s] plugin_init\n", PluginName)
 	log.Printf("config string:\n%s\n", C.GoString(config))
 
+	// On failure the state is returned anyway, so that the error can be
+	// retrieved with plugin_get_last_error
 	pState := sinsp.NewStateContainer()
-	sinsp.MakeBuffer(pState, outBufSize)
+	if err := sinsp.MakeBuffer(pState, outBufSize); err != nil {
+		*rc = sinsp.HandleError(pState, err)
+		return pState
+	}
 	*rc = sinsp.ScapSuccess
 
 	return pState
 }
 
 //export plugin_get_last_error
-func plugin_get_last_error() *C.char {
+func plugin_get_last_error(pState unsafe.Pointer) *C.char {
 	log.Printf("[%s] plugin_get_last_error\n", PluginName)
-	if gLastError != nil {
-		return C.CString(gLastError.Error())
-	}
-	return nil
+	return (*C.char)(unsafe.Pointer(sinsp.LastErrorStr(pState)))
 }
 
 //export plugin_destroy
@@ -86,7 +85,7 @@ func plugin_get_description() *C.char {
 	return C.CString(PluginDescription)
 }
 
-// export plugin_get_required_api_version
+//export plugin_get_required_api_version
 func plugin_get_required_api_version() *C.char {
 	return C.CString("1.0.0")
 }
@@ -94,13 +93,12 @@ func plugin_get_required_api_version() *C.char {
 //export plugin_get_fields
 func plugin_get_fields() *C.char {
 	log.Printf("[%s] plugin_get_fields\n", PluginName)
-	flds := []sinsp.FieldEntry{
-		{Type: "string", Name: "dummy.count", Desc: "TBD"},
-	}
+	flds := sinsp.NewFieldsBuilder(PluginName)
+	flds.Add(sinsp.FieldTypeString, "batch.count", "TBD")
 
-	b, err := json.Marshal(&flds)
+	b, err := flds.JSON()
 	if err != nil {
-		gLastError = err
+		sinsp.SetLastError(nil, err)
 		return nil
 	}
 
@@ -117,7 +115,11 @@ func plugin_open(pState unsafe.Pointer, params *C.char, rc *int32) unsafe.Pointe
 	m.m[4] = "ciao"
 
 	oState := sinsp.NewStateContainer()
-	sinsp.MakeBuffer(oState, nextBufSize)
+	if err := sinsp.MakeBuffer(oState, nextBufSize); err != nil {
+		sinsp.Free(oState)
+		*rc = sinsp.HandleError(pState, err)
+		return nil
+	}
 	sinsp.SetContext(oState, unsafe.Pointer(m))
 
 	*rc = sinsp.ScapSuccess
@@ -133,7 +135,7 @@ func plugin_close(pState unsafe.Pointer, oState unsafe.Pointer) {
 	sinsp.Free(oState)
 }
 
-func next(plgState unsafe.Pointer, oState unsafe.Pointer, data *[]byte, ts *uint64) int32 {
+func next(plgState unsafe.Pointer, oState unsafe.Pointer) (sinsp.Event, error) {
 
 	m := (*pluginCtx)(sinsp.Context(oState))
 
@@ -144,24 +146,13 @@ func next(plgState unsafe.Pointer, oState unsafe.Pointer, data *[]byte, ts *uint
 	// Put something not usefull in Go memory
 	m.m[rand.Intn(100)] = dummy
 
-	bdummy := []byte(dummy)
-	data = &bdummy
-
-	return sinsp.ScapSuccess
+	// The timestamp is left to zero, so that the current time is used
+	return sinsp.Event{Data: []byte(dummy)}, nil
 }
 
 //export plugin_next
 func plugin_next(pState unsafe.Pointer, oState unsafe.Pointer, data **byte, datalen *uint32, ts *uint64) int32 {
-	var nextData []byte
-
-	res := next(pState, oState, &nextData, ts)
-	if res == sinsp.ScapSuccess {
-		// Copy to and return the event buffer
-		*datalen = sinsp.CopyToBuffer(oState, nextData)
-		*data = sinsp.Buffer(oState)
-	}
-
-	return res
+	return sinsp.Next(pState, oState, data, datalen, ts, sinsp.ToNextFunc(next))
 }
 
 //export plugin_event_to_string
@@ -172,9 +163,20 @@ func plugin_event_to_string(data *C.char, datalen uint32) *C.char {
 	return C.CString(s)
 }
 
+//export plugin_extract_str
+func plugin_extract_str(pState unsafe.Pointer, evtnum uint64, id uint32, arg *byte, data *byte, datalen uint32) *byte {
+	if id != 0 {
+		sinsp.SetLastError(pState, fmt.Errorf("invalid field id %d", id))
+		return nil
+	}
+	// the count is what follows the "dummy" prefix of the event data
+	evt := C.GoStringN((*C.char)(unsafe.Pointer(data)), C.int(datalen))
+	return sinsp.ResultStr(pState, strings.TrimPrefix(evt, "dummy"))
+}
+
 //export plugin_next_batch
 func plugin_next_batch(pState unsafe.Pointer, oState unsafe.Pointer, data **byte, datalen *uint32) int32 {
-	return sinsp.NextBatch(pState, oState, data, datalen, next)
+	return sinsp.NextBatch(pState, oState, data, datalen, sinsp.ToNextFunc(next))
 }
 
 func main() {}
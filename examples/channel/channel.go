@@ -0,0 +1,79 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/ldegio/libsinsp-plugin-sdk-go/pkg/sinsp"
+	"github.com/ldegio/libsinsp-plugin-sdk-go/pkg/sinsp/source"
+)
+
+// Plugin consts
+const (
+	PluginID          uint32 = 113
+	PluginName               = "channel"
+	PluginDescription        = "source plugin fed by a producer goroutine"
+)
+
+const queueSize = 1024
+
+///////////////////////////////////////////////////////////////////////////////
+
+type pluginCtx struct{}
+
+func (p *pluginCtx) Info() *sinsp.PluginInfo {
+	return &sinsp.PluginInfo{
+		ID:          PluginID,
+		Name:        PluginName,
+		Description: PluginDescription,
+	}
+}
+
+func (p *pluginCtx) Init(config string) error {
+	return nil
+}
+
+func (p *pluginCtx) Destroy() {}
+
+func (p *pluginCtx) Open(params string) (interface{}, error) {
+	log.Printf("[%s] Open, params: %s\n", PluginName, params)
+	c := sinsp.NewChannelSource(queueSize)
+	go produce(c)
+	return c, nil
+}
+
+func (p *pluginCtx) Close(openState interface{}) {
+	log.Printf("[%s] Close\n", PluginName)
+	openState.(*sinsp.ChannelSource).Close()
+}
+
+func (p *pluginCtx) Next(openState interface{}) (sinsp.Event, error) {
+	return openState.(*sinsp.ChannelSource).Next()
+}
+
+func (p *pluginCtx) EventToString(data []byte) (string, error) {
+	return string(data), nil
+}
+
+// produce pushes a tick event every 10ms, until the source is closed
+func produce(c *sinsp.ChannelSource) {
+	ticker := time.NewTicker(10 * time.Millisecond)
+	defer ticker.Stop()
+
+	for t := range ticker.C {
+		evt := sinsp.Event{
+			Data: []byte(fmt.Sprintf("tick %s", t.Format(time.RFC3339Nano))),
+			Ts:   uint64(t.UnixNano()),
+		}
+		if err := c.Push(evt); err != nil {
+			return
+		}
+	}
+}
+
+func init() {
+	source.Register(func() sinsp.SourcePlugin { return &pluginCtx{} })
+}
+
+func main() {}
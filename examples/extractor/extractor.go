@@ -0,0 +1,95 @@
+package main
+
+import (
+	"log"
+	"strings"
+
+	"github.com/ldegio/libsinsp-plugin-sdk-go/pkg/sinsp"
+	_ "github.com/ldegio/libsinsp-plugin-sdk-go/pkg/sinsp/async"
+	"github.com/ldegio/libsinsp-plugin-sdk-go/pkg/sinsp/extractor"
+)
+
+// Plugin consts
+const (
+	PluginID          uint32 = 1112
+	PluginName               = "extractor"
+	PluginDescription        = "extractor plugin written against the high-level SDK interface"
+)
+
+///////////////////////////////////////////////////////////////////////////////
+
+type pluginCtx struct {
+	config string
+}
+
+func (p *pluginCtx) Info() *sinsp.PluginInfo {
+	return &sinsp.PluginInfo{
+		ID:          PluginID,
+		Name:        PluginName,
+		Description: PluginDescription,
+	}
+}
+
+func (p *pluginCtx) Fields() []sinsp.Field {
+	return []sinsp.Field{
+		sinsp.StrField("extractor.upper", "The event data in upper case", p.extractUpper),
+		sinsp.U64Field("extractor.len", "The length of the event data", p.extractLen),
+		sinsp.BoolField("extractor.empty", "Whether the event data is empty", p.extractEmpty),
+		sinsp.BytesField("extractor.raw", "The raw event data", p.extractRaw),
+		sinsp.U64Field("extractor.words", "The number of words in the event data", p.extractWords),
+		sinsp.StrField("extractor.first", "The first word of the event data", p.extractFirst),
+	}
+}
+
+// Decode splits the event data into words once, for all the fields using them.
+func (p *pluginCtx) Decode(data []byte) (interface{}, error) {
+	return strings.Fields(string(data)), nil
+}
+
+func (p *pluginCtx) Init(config string) error {
+	log.Printf("[%s] Init, config: %s\n", PluginName, config)
+	p.config = config
+	return nil
+}
+
+func (p *pluginCtx) Destroy() {
+	log.Printf("[%s] Destroy\n", PluginName)
+}
+
+func (p *pluginCtx) extractUpper(req *sinsp.ExtractRequest) (string, bool, error) {
+	return strings.ToUpper(string(req.Data)), true, nil
+}
+
+func (p *pluginCtx) extractLen(req *sinsp.ExtractRequest) (uint64, bool, error) {
+	return uint64(len(req.Data)), true, nil
+}
+
+func (p *pluginCtx) extractEmpty(req *sinsp.ExtractRequest) (bool, bool, error) {
+	return len(req.Data) == 0, true, nil
+}
+
+func (p *pluginCtx) extractRaw(req *sinsp.ExtractRequest) ([]byte, bool, error) {
+	return req.Data, true, nil
+}
+
+func (p *pluginCtx) extractWords(req *sinsp.ExtractRequest) (uint64, bool, error) {
+	words, err := req.Decoded()
+	if err != nil {
+		return 0, false, err
+	}
+	return uint64(len(words.([]string))), true, nil
+}
+
+func (p *pluginCtx) extractFirst(req *sinsp.ExtractRequest) (string, bool, error) {
+	words, err := req.Decoded()
+	if err != nil || len(words.([]string)) == 0 {
+		return "", false, err
+	}
+	return words.([]string)[0], true, nil
+}
+
+func init() {
+	extractor.Register(func() sinsp.ExtractorPlugin { return &pluginCtx{} })
+}
+
+func main() {}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"unsafe"
+
+	"github.com/ldegio/libsinsp-plugin-sdk-go/pkg/sinsp"
+	"github.com/ldegio/libsinsp-plugin-sdk-go/pkg/sinsp/extractor"
+	"github.com/ldegio/libsinsp-plugin-sdk-go/pkg/sinsp/sinsptest"
+)
+
+func TestExtractor(t *testing.T) {
+	res, err := sinsptest.Run(extractor.Entrypoints(), &sinsptest.Options{
+		Inputs: [][]byte{[]byte("hello world"), {}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Type != sinsp.TypeExtractorPlugin || res.ID != PluginID || res.Name != PluginName {
+		t.Fatalf("got info (%d, %d, %q)", res.Type, res.ID, res.Name)
+	}
+	if len(res.Fields) != 6 {
+		t.Fatalf("got %d fields, want 6", len(res.Fields))
+	}
+	if len(res.Events) != 2 {
+		t.Fatalf("got %d events, want 2", len(res.Events))
+	}
+
+	want := []map[string]sinsptest.Value{
+		{
+			"extractor.upper": {Present: true, Str: "HELLO WORLD"},
+			"extractor.len":   {Present: true, U64: 11},
+			"extractor.empty": {Present: true, U64: 0},
+			"extractor.raw":   {Present: true, Buf: []byte("hello world")},
+			"extractor.words": {Present: true, U64: 2},
+			"extractor.first": {Present: true, Str: "hello"},
+		},
+		{
+			"extractor.upper": {Present: true, Str: ""},
+			"extractor.len":   {Present: true, U64: 0},
+			"extractor.empty": {Present: true, U64: 1},
+			"extractor.raw":   {Present: true, Buf: []byte{}},
+			"extractor.words": {Present: true, U64: 0},
+			"extractor.first": {},
+		},
+	}
+	for i, evt := range res.Events {
+		if !reflect.DeepEqual(evt.Fields, want[i]) {
+			t.Errorf("event %d: got fields %+v, want %+v", i, evt.Fields, want[i])
+		}
+	}
+}
+
+func TestExtractorFields(t *testing.T) {
+	res, err := sinsptest.Run(extractor.Entrypoints(), &sinsptest.Options{
+		Inputs: [][]byte{[]byte("a b c")},
+		Fields: []string{"extractor.words"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]sinsptest.Value{"extractor.words": {Present: true, U64: 3}}
+	if len(res.Events) != 1 || !reflect.DeepEqual(res.Events[0].Fields, want) {
+		t.Fatalf("got events %+v, want fields %+v", res.Events, want)
+	}
+}
+
+func TestExtractorMissingEntrypoint(t *testing.T) {
+	e := extractor.Entrypoints()
+	e.ExtractBuf = nil
+	_, err := sinsptest.Run(e, &sinsptest.Options{Inputs: [][]byte{[]byte("data")}})
+	if err == nil || !strings.Contains(err.Error(), "field extractor.raw requires plugin_extract_buf") {
+		t.Fatalf("got %v, want a missing plugin_extract_buf error", err)
+	}
+}
+
+func TestExtractorError(t *testing.T) {
+	// Passing an argument to fields taking none fails the extraction
+	for _, name := range []string{"extractor.upper", "extractor.raw", "extractor.len"} {
+		_, err := sinsptest.Run(extractor.Entrypoints(), &sinsptest.Options{
+			Inputs: [][]byte{[]byte("data")},
+			Fields: []string{name},
+			Args:   map[string]string{name: "arg"},
+		})
+		if err == nil || !strings.Contains(err.Error(), "extracting field "+name+" from event 1") {
+			t.Errorf("field %s: got %v, want an extraction error", name, err)
+		}
+	}
+
+	// A failure repeating the message of an earlier error is detected too
+	e := extractor.Entrypoints()
+	extractStr := e.ExtractStr
+	calls := 0
+	e.ExtractStr = func(pState unsafe.Pointer, evtnum uint64, id uint32, arg *byte, data *byte, datalen uint32) *byte {
+		bad := []byte("arg\x00")
+		res := extractStr(pState, evtnum, id, &bad[0], data, datalen)
+		if calls++; calls == 1 {
+			// the first call sets the last error but still returns a value
+			return &[]byte("value\x00")[0]
+		}
+		return res
+	}
+	_, err := sinsptest.Run(e, &sinsptest.Options{
+		Inputs: [][]byte{[]byte("a"), []byte("b")},
+		Fields: []string{"extractor.upper"},
+	})
+	if err == nil || !strings.Contains(err.Error(), "extracting field extractor.upper from event 2") {
+		t.Fatalf("got %v, want an extraction error on event 2", err)
+	}
+}
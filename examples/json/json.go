@@ -0,0 +1,76 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net"
+	"time"
+
+	"github.com/ldegio/libsinsp-plugin-sdk-go/pkg/sinsp"
+	_ "github.com/ldegio/libsinsp-plugin-sdk-go/pkg/sinsp/async"
+	"github.com/ldegio/libsinsp-plugin-sdk-go/pkg/sinsp/extractor"
+)
+
+// Plugin consts
+const (
+	PluginID          uint32 = 1113
+	PluginName               = "json"
+	PluginDescription        = "extractor plugin declaring its fields with struct tags on JSON events"
+)
+
+///////////////////////////////////////////////////////////////////////////////
+
+type process struct {
+	Pid  uint64 `json:"pid" sinsp:"json.proc.pid,desc=Process ID"`
+	Name string `json:"name" sinsp:"json.proc.name,desc=Process name"`
+}
+
+type event struct {
+	Time     time.Time         `json:"time" sinsp:"json.time,desc=Event time"`
+	User     string            `json:"user" sinsp:"json.user,desc=User name,display=User"`
+	ExitCode int64             `json:"exit_code" sinsp:"json.exitcode,desc=Exit code of the process"`
+	Remote   net.IP            `json:"remote" sinsp:"json.remote,desc=Remote address, if any"`
+	Proc     *process          `json:"proc"`
+	Tags     []string          `json:"tags" sinsp:"json.tag,desc=Event tag at the given index"`
+	Labels   map[string]string `json:"labels" sinsp:"json.label,desc=Event label with the given key"`
+}
+
+type pluginCtx struct {
+}
+
+func (p *pluginCtx) Info() *sinsp.PluginInfo {
+	return &sinsp.PluginInfo{
+		ID:          PluginID,
+		Name:        PluginName,
+		Description: PluginDescription,
+	}
+}
+
+func (p *pluginCtx) Fields() []sinsp.Field {
+	fields, err := sinsp.StructFields(event{})
+	if err != nil {
+		return sinsp.FieldsError(err)
+	}
+	return fields
+}
+
+func (p *pluginCtx) Decode(data []byte) (interface{}, error) {
+	var evt event
+	err := json.Unmarshal(data, &evt)
+	return &evt, err
+}
+
+func (p *pluginCtx) Init(config string) error {
+	log.Printf("[%s] Init, config: %s\n", PluginName, config)
+	return nil
+}
+
+func (p *pluginCtx) Destroy() {
+	log.Printf("[%s] Destroy\n", PluginName)
+}
+
+func init() {
+	extractor.Register(func() sinsp.ExtractorPlugin { return &pluginCtx{} })
+}
+
+func main() {}
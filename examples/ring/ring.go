@@ -0,0 +1,161 @@
+package main
+
+/*
+#include <stdlib.h>
+#include <stdint.h>
+*/
+import "C"
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"sync"
+	"time"
+	"unsafe"
+
+	"github.com/ldegio/libsinsp-plugin-sdk-go/pkg/sinsp"
+)
+
+// Plugin consts
+const (
+	PluginID          uint32 = 114
+	PluginName               = "ring"
+	PluginDescription        = "source plugin producing events into a zero-copy ring buffer"
+)
+
+const ringSize uint32 = 8 * 1024 * 1024
+const maxEvtSize uint32 = 64
+
+///////////////////////////////////////////////////////////////////////////////
+
+type openCtx struct {
+	done chan struct{}
+	wg   sync.WaitGroup
+}
+
+//export plugin_get_type
+func plugin_get_type() uint32 {
+	return sinsp.TypeSourcePlugin
+}
+
+//export plugin_init
+func plugin_init(config *C.char, rc *int32) unsafe.Pointer {
+	log.Printf("[%s] plugin_init\n", PluginName)
+	*rc = sinsp.ScapSuccess
+	return sinsp.NewStateContainer()
+}
+
+//export plugin_get_last_error
+func plugin_get_last_error(pState unsafe.Pointer) *C.char {
+	return (*C.char)(unsafe.Pointer(sinsp.LastErrorStr(pState)))
+}
+
+//export plugin_destroy
+func plugin_destroy(pState unsafe.Pointer) {
+	log.Printf("[%s] plugin_destroy\n", PluginName)
+	sinsp.Free(pState)
+}
+
+//export plugin_get_id
+func plugin_get_id() uint32 {
+	return PluginID
+}
+
+//export plugin_get_name
+func plugin_get_name() *C.char {
+	return C.CString(PluginName)
+}
+
+//export plugin_get_description
+func plugin_get_description() *C.char {
+	return C.CString(PluginDescription)
+}
+
+//export plugin_get_required_api_version
+func plugin_get_required_api_version() *C.char {
+	return C.CString(sinsp.DefaultRequiredAPIVersion)
+}
+
+//export plugin_get_fields
+func plugin_get_fields() *C.char {
+	return C.CString("[]")
+}
+
+//export plugin_open
+func plugin_open(pState unsafe.Pointer, params *C.char, rc *int32) unsafe.Pointer {
+	log.Printf("[%s] plugin_open, params: %s\n", PluginName, C.GoString(params))
+
+	// params is the number of events to produce, or 0 for no limit
+	n, _ := strconv.Atoi(C.GoString(params))
+
+	m := &openCtx{done: make(chan struct{})}
+	oState := sinsp.NewStateContainer()
+	if err := sinsp.MakeRing(oState, ringSize); err != nil {
+		sinsp.Free(oState)
+		*rc = sinsp.HandleError(pState, err)
+		return nil
+	}
+	sinsp.SetContext(oState, unsafe.Pointer(m))
+
+	m.wg.Add(1)
+	go produce(oState, m, n)
+
+	*rc = sinsp.ScapSuccess
+	return oState
+}
+
+//export plugin_close
+func plugin_close(pState unsafe.Pointer, oState unsafe.Pointer) {
+	log.Printf("[%s] plugin_close\n", PluginName)
+	m := (*openCtx)(sinsp.Context(oState))
+	// The producer must be stopped before freeing the ring buffer
+	close(m.done)
+	m.wg.Wait()
+	sinsp.Free(oState)
+}
+
+// produce writes the events directly into the ring buffer of oState,
+// until n events have been written or the open state is closed
+func produce(oState unsafe.Pointer, m *openCtx, n int) {
+	defer m.wg.Done()
+	defer sinsp.RingClose(oState)
+
+	for i := 0; n == 0 || i < n; {
+		select {
+		case <-m.done:
+			return
+		default:
+		}
+
+		buf, err := sinsp.RingReserve(oState, maxEvtSize)
+		if err == sinsp.ErrRingFull {
+			// Wait for the consumer to catch up
+			time.Sleep(time.Millisecond)
+			continue
+		} else if err != nil {
+			log.Printf("[%s] %s\n", PluginName, err.Error())
+			return
+		}
+
+		l := copy(buf, fmt.Sprintf("ring%d", i))
+		sinsp.RingCommit(oState, uint64(time.Now().UnixNano()), uint32(l))
+		i++
+	}
+}
+
+//export plugin_next
+func plugin_next(pState unsafe.Pointer, oState unsafe.Pointer, data **byte, datalen *uint32, ts *uint64) int32 {
+	return sinsp.RingNext(pState, oState, data, datalen, ts)
+}
+
+//export plugin_next_batch
+func plugin_next_batch(pState unsafe.Pointer, oState unsafe.Pointer, data **byte, datalen *uint32) int32 {
+	return sinsp.RingNextBatch(pState, oState, data, datalen)
+}
+
+//export plugin_event_to_string
+func plugin_event_to_string(data *C.char, datalen uint32) *C.char {
+	return C.CString(C.GoStringN(data, C.int(datalen)))
+}
+
+func main() {}
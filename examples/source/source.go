@@ -0,0 +1,73 @@
+package main
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/ldegio/libsinsp-plugin-sdk-go/pkg/sinsp"
+	"github.com/ldegio/libsinsp-plugin-sdk-go/pkg/sinsp/source"
+)
+
+// Plugin consts
+const (
+	PluginID          uint32 = 112
+	PluginName               = "source"
+	PluginDescription        = "source plugin written against the high-level SDK interface"
+)
+
+///////////////////////////////////////////////////////////////////////////////
+
+type pluginCtx struct {
+	config string
+}
+
+type openCtx struct {
+	counter int
+}
+
+func (p *pluginCtx) Info() *sinsp.PluginInfo {
+	return &sinsp.PluginInfo{
+		ID:          PluginID,
+		Name:        PluginName,
+		Description: PluginDescription,
+	}
+}
+
+func (p *pluginCtx) Init(config string) error {
+	log.Printf("[%s] Init, config: %s\n", PluginName, config)
+	p.config = config
+	return nil
+}
+
+func (p *pluginCtx) Destroy() {
+	log.Printf("[%s] Destroy\n", PluginName)
+}
+
+func (p *pluginCtx) Open(params string) (interface{}, error) {
+	log.Printf("[%s] Open, params: %s\n", PluginName, params)
+	return &openCtx{}, nil
+}
+
+func (p *pluginCtx) Close(openState interface{}) {
+	log.Printf("[%s] Close\n", PluginName)
+}
+
+func (p *pluginCtx) Next(openState interface{}) (sinsp.Event, error) {
+	o := openState.(*openCtx)
+	if o.counter >= 1000 {
+		return sinsp.Event{}, sinsp.ErrEOF
+	}
+
+	o.counter++
+	return sinsp.Event{Data: []byte(fmt.Sprintf("source%d", o.counter))}, nil
+}
+
+func (p *pluginCtx) EventToString(data []byte) (string, error) {
+	return fmt.Sprintf("evt-to-string(len=%d): %s", len(data), data), nil
+}
+
+func init() {
+	source.Register(func() sinsp.SourcePlugin { return &pluginCtx{} })
+}
+
+func main() {}
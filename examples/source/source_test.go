@@ -0,0 +1,199 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"unsafe"
+
+	"github.com/ldegio/libsinsp-plugin-sdk-go/pkg/sinsp"
+	"github.com/ldegio/libsinsp-plugin-sdk-go/pkg/sinsp/sinsptest"
+	"github.com/ldegio/libsinsp-plugin-sdk-go/pkg/sinsp/source"
+)
+
+// cstr returns s as a NULL terminated string.
+func cstr(s string) *byte {
+	return &append([]byte(s), 0)[0]
+}
+
+func checkEvents(t *testing.T, res *sinsptest.Result, n int) {
+	t.Helper()
+	if len(res.Events) != n {
+		t.Fatalf("got %d events, want %d", len(res.Events), n)
+	}
+	for i, evt := range res.Events {
+		data := fmt.Sprintf("source%d", i+1)
+		if evt.Num != uint64(i+1) || string(evt.Data) != data {
+			t.Fatalf("event %d: got (%d, %q), want (%d, %q)", i, evt.Num, evt.Data, i+1, data)
+		}
+		if want := fmt.Sprintf("evt-to-string(len=%d): %s", len(data), data); evt.String != want {
+			t.Fatalf("event %d: got string %q, want %q", i, evt.String, want)
+		}
+	}
+}
+
+func TestSource(t *testing.T) {
+	res, err := sinsptest.Run(source.Entrypoints(), &sinsptest.Options{Params: "params", MaxEvents: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Type != sinsp.TypeSourcePlugin || res.ID != PluginID || res.Name != PluginName || res.Description != PluginDescription {
+		t.Fatalf("got info (%d, %d, %q, %q)", res.Type, res.ID, res.Name, res.Description)
+	}
+	if len(res.Fields) != 0 {
+		t.Fatalf("got %d fields, want none", len(res.Fields))
+	}
+	checkEvents(t, res, 10)
+}
+
+func TestSourceNextBatch(t *testing.T) {
+	res, err := sinsptest.Run(source.Entrypoints(), &sinsptest.Options{MaxEvents: 10, NextBatch: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkEvents(t, res, 10)
+}
+
+func TestSourceEOF(t *testing.T) {
+	res, err := sinsptest.Run(source.Entrypoints(), &sinsptest.Options{MaxEvents: 2000})
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkEvents(t, res, 1000)
+}
+
+func TestSourceErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		change func(e *sinsp.Entrypoints)
+		opts   sinsptest.Options
+		events int
+		err    string // empty if Run succeeds
+	}{
+		{
+			name:   "missing plugin_next",
+			change: func(e *sinsp.Entrypoints) { e.Next = nil },
+			err:    "missing required source plugin entry points",
+		},
+		{
+			name:   "missing plugin_next_batch",
+			change: func(e *sinsp.Entrypoints) { e.NextBatch = nil },
+			opts:   sinsptest.Options{NextBatch: true},
+			err:    "plugin_next_batch is not exported",
+		},
+		{
+			name:   "invalid fields",
+			change: func(e *sinsp.Entrypoints) { e.GetFields = func() *byte { return cstr("notjson") } },
+			err:    "plugin_get_fields returned invalid JSON",
+		},
+		{
+			name: "failing plugin_init",
+			change: func(e *sinsp.Entrypoints) {
+				e.Init = func(config *byte, rc *int32) unsafe.Pointer {
+					*rc = sinsp.ScapFailure
+					return nil
+				}
+			},
+			err: "plugin_init failed",
+		},
+		{
+			name: "failing plugin_open",
+			change: func(e *sinsp.Entrypoints) {
+				e.Open = func(pState unsafe.Pointer, params *byte, rc *int32) unsafe.Pointer {
+					*rc = sinsp.ScapFailure
+					return nil
+				}
+			},
+			err: "plugin_open failed",
+		},
+		{
+			name: "nil open state",
+			change: func(e *sinsp.Entrypoints) {
+				e.Open = func(pState unsafe.Pointer, params *byte, rc *int32) unsafe.Pointer {
+					*rc = sinsp.ScapSuccess
+					return nil
+				}
+			},
+			err: "plugin_open returned a nil state",
+		},
+		{
+			name: "failing plugin_next",
+			change: func(e *sinsp.Entrypoints) {
+				next, n := e.Next, 0
+				e.Next = func(pState, oState unsafe.Pointer, data **byte, datalen *uint32, ts *uint64) int32 {
+					if n++; n > 2 {
+						return sinsp.ScapFailure
+					}
+					return next(pState, oState, data, datalen, ts)
+				}
+			},
+			events: 2,
+			err:    "plugin_next failed",
+		},
+		{
+			name: "decreasing timestamps",
+			change: func(e *sinsp.Entrypoints) {
+				next, n := e.Next, uint64(0)
+				e.Next = func(pState, oState unsafe.Pointer, data **byte, datalen *uint32, ts *uint64) int32 {
+					rc := next(pState, oState, data, datalen, ts)
+					n++
+					*ts = 10 - n
+					return rc
+				}
+			},
+			events: 1,
+			err:    "event 2 has timestamp 8, lower than the previous one 9",
+		},
+		{
+			name: "timeouts",
+			change: func(e *sinsp.Entrypoints) {
+				e.Next = func(pState, oState unsafe.Pointer, data **byte, datalen *uint32, ts *uint64) int32 {
+					return sinsp.ScapTimeout
+				}
+			},
+			opts: sinsptest.Options{MaxTimeouts: 3},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := source.Entrypoints()
+			opened, closed, destroyed := 0, 0, 0
+			open, close, destroy := e.Open, e.Close, e.Destroy
+			e.Open = func(pState unsafe.Pointer, params *byte, rc *int32) unsafe.Pointer {
+				opened++
+				return open(pState, params, rc)
+			}
+			e.Close = func(pState, oState unsafe.Pointer) {
+				closed++
+				close(pState, oState)
+			}
+			e.Destroy = func(pState unsafe.Pointer) {
+				destroyed++
+				destroy(pState)
+			}
+			tt.change(e)
+
+			res, err := sinsptest.Run(e, &tt.opts)
+			switch {
+			case tt.err == "" && err != nil:
+				t.Fatalf("got %s, want no error", err)
+			case tt.err != "" && err == nil:
+				t.Fatalf("got no error, want %q", tt.err)
+			case tt.err != "" && !strings.Contains(err.Error(), tt.err):
+				t.Fatalf("got %s, want %q", err, tt.err)
+			}
+			if len(res.Events) != tt.events {
+				t.Fatalf("got %d events, want %d", len(res.Events), tt.events)
+			}
+
+			// The capture and the plugin are released anyway
+			if opened != closed {
+				t.Errorf("plugin_open called %d times, plugin_close %d times", opened, closed)
+			}
+			if destroyed > 1 {
+				t.Errorf("plugin_destroy called %d times", destroyed)
+			}
+		})
+	}
+}
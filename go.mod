@@ -1,3 +1,5 @@
 module github.com/ldegio/libsinsp-plugin-sdk-go
 
 go 1.15
+
+require gopkg.in/yaml.v2 v2.4.0
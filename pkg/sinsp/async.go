@@ -32,10 +32,178 @@ bool wait_bridge(async_extractor_info *info)
 */
 import "C"
 import (
+	"fmt"
+	"log"
+	"sync/atomic"
+	"time"
 	"unsafe"
 )
 
+// AsyncStopTimeout is how long Free() and plugin_destroy wait for the extraction
+// request in progress, if any, to complete after stopping the async extractor worker.
+const AsyncStopTimeout = 5 * time.Second
+
+// asyncStopTimeout is AsyncStopTimeout, lowered by the tests.
+var asyncStopTimeout = AsyncStopTimeout
+
+// AsyncWorker is the goroutine serving the asynchronous extraction requests of a plugin.
+//
+// The worker can only exit when sinsp ends the protocol, that is when the
+// cb_wait callback returns false. Stop makes it reject every request it receives
+// from then on, without invoking the extractor functions anymore.
+type AsyncWorker struct {
+	stopped int32
+	busy    chan struct{}
+	done    chan struct{}
+}
+
+// Stop makes w reject every subsequent extraction request with ScapFailure.
+// It does not wait for an in-flight request to complete, see Quiesce.
+func (w *AsyncWorker) Stop() {
+	atomic.StoreInt32(&w.stopped, 1)
+}
+
+// Quiesce waits for the extraction request in progress, if any, to complete,
+// and reports whether it did within timeout. It must be called after Stop:
+// once Quiesce returns true, w never touches the plugin state again, even
+// though the goroutine keeps running until sinsp ends the protocol.
+func (w *AsyncWorker) Quiesce(timeout time.Duration) bool {
+	t := time.NewTimer(timeout)
+	defer t.Stop()
+	select {
+	case w.busy <- struct{}{}:
+		<-w.busy
+		return true
+	case <-t.C:
+		return false
+	}
+}
+
+// Stopped reports whether Stop has been called on w.
+func (w *AsyncWorker) Stopped() bool {
+	return atomic.LoadInt32(&w.stopped) != 0
+}
+
+// Done returns a channel that is closed when the worker goroutine exits.
+func (w *AsyncWorker) Done() <-chan struct{} {
+	return w.done
+}
+
+// Wait blocks until the worker goroutine exits, that is until sinsp ends the protocol.
+func (w *AsyncWorker) Wait() {
+	<-w.done
+}
+
+// StartAsyncExtractors starts a worker goroutine serving the asynchronous extraction
+// requests described by asyncExtractorInfo, and returns it.
+//
+// Requests are dispatched according to their field type: strExtractorFunc serves
+// string fields and u64ExtractorFunc serves the fields whose value fits in 64 bits
+// (see ExtractU64()). A nil function makes the requests it would serve fail with
+// ScapNotSupported, as do the requests for the fields whose value is a byte buffer
+// (see StartAsyncBufExtractors()).
+//
+// A panic in the extractor functions is recovered and reported as ScapFailure,
+// with the panic stored as the last error of pluginState. Since the extractor functions
+// have no way to return an error, the requests they cannot serve succeed with no result,
+// as in the synchronous path. RegisterAsyncFieldExtractors() does report them as ScapFailure.
+func StartAsyncExtractors(
+	pluginState unsafe.Pointer,
+	asyncExtractorInfo unsafe.Pointer,
+	strExtractorFunc PluginExtractStrFunc,
+	u64ExtractorFunc PluginExtractU64Func,
+) *AsyncWorker {
+	funcs := pluginAsyncFuncs(strExtractorFunc, u64ExtractorFunc, nil)
+	return startAsyncWorker(pluginState, (*C.async_extractor_info)(asyncExtractorInfo), funcs)
+}
+
+// StartAsyncBufExtractors is like StartAsyncExtractors(), but bufExtractorFunc also serves
+// the fields whose value is a byte buffer (see ExtractBuf()), returning the buffer in res_str
+// and, since the libsinsp layout of asyncExtractorInfo has no dedicated member for it, its
+// length in res_u64. res_u64 is otherwise unused for these fields, and libsinsp only
+// requests string and uint64 fields, so this does not conflict with its protocol.
+func StartAsyncBufExtractors(
+	pluginState unsafe.Pointer,
+	asyncExtractorInfo unsafe.Pointer,
+	strExtractorFunc PluginExtractStrFunc,
+	u64ExtractorFunc PluginExtractU64Func,
+	bufExtractorFunc PluginExtractBufFunc,
+) *AsyncWorker {
+	funcs := pluginAsyncFuncs(strExtractorFunc, u64ExtractorFunc, bufExtractorFunc)
+	return startAsyncWorker(pluginState, (*C.async_extractor_info)(asyncExtractorInfo), funcs)
+}
+
+// startAsyncWorker starts the worker serving the requests of info.
+func startAsyncWorker(pluginState unsafe.Pointer, info *C.async_extractor_info, funcs asyncFuncs) *AsyncWorker {
+	return runAsyncWorker(
+		func() bool { return bool(C.wait_bridge(info)) },
+		func() { asyncExtract(pluginState, info, funcs) },
+		func() { (*info).rc = C.int32_t(ScapFailure) },
+	)
+}
+
+// runAsyncWorker starts a worker waiting for the requests with wait, as long as it returns
+// true, and serving each of them with serve, or with reject once the worker is stopped.
+func runAsyncWorker(wait func() bool, serve func(), reject func()) *AsyncWorker {
+	w := &AsyncWorker{busy: make(chan struct{}, 1), done: make(chan struct{})}
+	go func() {
+		defer close(w.done)
+		for wait() {
+			w.busy <- struct{}{}
+			if w.Stopped() {
+				reject()
+			} else {
+				serve()
+			}
+			<-w.busy
+		}
+	}()
+	return w
+}
+
+// registerAsyncWorker starts a worker with start and binds it to pluginState, if not nil.
+// The worker previously bound to pluginState, if any, is stopped first as done by Free(),
+// so that it cannot touch the state anymore once replaced. If it cannot be stopped,
+// no worker is started and ScapFailure is returned.
+func registerAsyncWorker(pluginState unsafe.Pointer, start func() *AsyncWorker) int32 {
+	if pluginState == nil {
+		start()
+		return ScapSuccess
+	}
+	if !stopAsyncExtractors(pluginState) {
+		return HandleError(pluginState, fmt.Errorf("the async extractor worker already registered did not stop within %s", asyncStopTimeout))
+	}
+	setAsyncExtractors(pluginState, start())
+	return ScapSuccess
+}
+
+// stopAsyncExtractors stops the async extractor worker bound to p, if any, and waits
+// for the request in progress with Quiesce(). It reports whether p can be safely
+// released, logging the failure otherwise.
+func stopAsyncExtractors(p unsafe.Pointer) bool {
+	w := AsyncExtractors(p)
+	if w == nil {
+		return true
+	}
+	w.Stop()
+	if !w.Quiesce(asyncStopTimeout) {
+		log.Printf("sinsp: async extraction still in progress after %s, the plugin state is not released\n", asyncStopTimeout)
+		return false
+	}
+	return true
+}
+
 // RegisterAsyncExtractors is a helper function to be used within plugin_register_async_extractor.
+// It starts the worker with StartAsyncExtractors() and, if pluginState is not nil, binds
+// it to pluginState (assumed to be a state container created with NewStateContainer()),
+// so that it can be retrieved with AsyncExtractors() and is stopped by Free().
+//
+// The worker can only exit when sinsp ends the asynchronous extraction protocol, but
+// the plugin state does not have to outlive it: once stopped, the worker rejects every
+// request without touching the state, so Free() only waits for the request in progress.
+// Likewise, if a worker is already bound to pluginState, it is stopped before the new one
+// is started, and ScapFailure is returned if its request in progress does not complete
+// within AsyncStopTimeout.
 //
 // Intended usage as in the following example:
 //
@@ -46,7 +214,7 @@ import (
 //
 //     //export plugin_register_async_extractor
 //     func plugin_register_async_extractor(pluginState unsafe.Pointer, asyncExtractorInfo unsafe.Pointer) int32 {
-//     	return sinsp.RegisterAsyncExtractors(pluginState, asyncExtractorInfo, plugin_extract_str)
+//     	return sinsp.RegisterAsyncExtractors(pluginState, asyncExtractorInfo, plugin_extract_str, plugin_extract_u64)
 //     }
 //
 func RegisterAsyncExtractors(
@@ -55,45 +223,173 @@ func RegisterAsyncExtractors(
 	strExtractorFunc PluginExtractStrFunc,
 	u64ExtractorFunc PluginExtractU64Func,
 ) int32 {
-	go func() {
-		info := (*C.async_extractor_info)(asyncExtractorInfo)
-		for C.wait_bridge(info) {
-			(*info).rc = C.int32_t(ScapSuccess)
-			switch uint32(info.ftype) {
-			case ParamTypeCharBuf:
-				if strExtractorFunc != nil {
-					(*info).res_str = (*C.char)(unsafe.Pointer(strExtractorFunc(
-						pluginState,
-						uint64(info.evtnum),
-						uint32(info.id),
-						(*byte)(unsafe.Pointer(info.arg)),
-						(*byte)(unsafe.Pointer(info.data)),
-						uint32(info.datalen),
-					)))
-				} else {
-					(*info).rc = C.int32_t(ScapNotSupported)
-				}
-			case ParamTypeUint64:
-				if u64ExtractorFunc != nil {
-					var field_present uint32
-					(*info).res_u64 = C.uint64_t(u64ExtractorFunc(
-						pluginState,
-						uint64(info.evtnum),
-						uint32(info.id),
-						(*byte)(unsafe.Pointer(info.arg)),
-						(*byte)(unsafe.Pointer(info.data)),
-						uint32(info.datalen),
-						&(field_present),
-					))
-
-					info.field_present = C.uint32_t(field_present)
-				} else {
-					(*info).rc = C.int32_t(ScapNotSupported)
-				}
-			default:
-				(*info).rc = C.int32_t(ScapNotSupported)
-			}
+	return registerAsyncWorker(pluginState, func() *AsyncWorker {
+		return StartAsyncExtractors(pluginState, asyncExtractorInfo, strExtractorFunc, u64ExtractorFunc)
+	})
+}
+
+// RegisterAsyncBufExtractors is like RegisterAsyncExtractors(), but starts the worker
+// with StartAsyncBufExtractors(), so that the buffer fields are served as well.
+func RegisterAsyncBufExtractors(
+	pluginState unsafe.Pointer,
+	asyncExtractorInfo unsafe.Pointer,
+	strExtractorFunc PluginExtractStrFunc,
+	u64ExtractorFunc PluginExtractU64Func,
+	bufExtractorFunc PluginExtractBufFunc,
+) int32 {
+	return registerAsyncWorker(pluginState, func() *AsyncWorker {
+		return StartAsyncBufExtractors(pluginState, asyncExtractorInfo, strExtractorFunc, u64ExtractorFunc, bufExtractorFunc)
+	})
+}
+
+// asyncFuncs are the functions serving the requests of an async extractor worker.
+// A nil function makes the requests it would serve fail with ScapNotSupported,
+// and an error makes the request fail with ScapFailure.
+type asyncFuncs struct {
+	str func(pluginState unsafe.Pointer, evtnum uint64, id uint32, arg *byte, data *byte, datalen uint32) (*byte, error)
+	u64 func(pluginState unsafe.Pointer, evtnum uint64, id uint32, arg *byte, data *byte, datalen uint32, fieldPresent *uint32) (uint64, error)
+	buf func(pluginState unsafe.Pointer, evtnum uint64, id uint32, arg *byte, data *byte, datalen uint32, reslen *uint32) (*byte, error)
+}
+
+// fieldAsyncFuncs serve the requests with the field registry bound to the plugin state.
+// The buffer fields are left to plugin_extract_buf, see RegisterAsyncFieldExtractors().
+var fieldAsyncFuncs = asyncFuncs{str: extractStr, u64: extractU64}
+
+// fieldBufAsyncFuncs are like fieldAsyncFuncs, but serve the buffer fields as well.
+var fieldBufAsyncFuncs = asyncFuncs{str: extractStr, u64: extractU64, buf: extractBuf}
+
+// pluginAsyncFuncs serve the requests with the given extractor functions, which
+// have no way to report errors.
+func pluginAsyncFuncs(strExtractorFunc PluginExtractStrFunc, u64ExtractorFunc PluginExtractU64Func, bufExtractorFunc PluginExtractBufFunc) asyncFuncs {
+	var f asyncFuncs
+	if strExtractorFunc != nil {
+		f.str = func(pluginState unsafe.Pointer, evtnum uint64, id uint32, arg *byte, data *byte, datalen uint32) (*byte, error) {
+			return strExtractorFunc(pluginState, evtnum, id, arg, data, datalen), nil
 		}
-	}()
-	return ScapSuccess
+	}
+	if u64ExtractorFunc != nil {
+		f.u64 = func(pluginState unsafe.Pointer, evtnum uint64, id uint32, arg *byte, data *byte, datalen uint32, fieldPresent *uint32) (uint64, error) {
+			return u64ExtractorFunc(pluginState, evtnum, id, arg, data, datalen, fieldPresent), nil
+		}
+	}
+	if bufExtractorFunc != nil {
+		f.buf = func(pluginState unsafe.Pointer, evtnum uint64, id uint32, arg *byte, data *byte, datalen uint32, reslen *uint32) (*byte, error) {
+			return bufExtractorFunc(pluginState, evtnum, id, arg, data, datalen, reslen), nil
+		}
+	}
+	return f
+}
+
+// asyncExtract serves a single extraction request, turning an error or a panic into
+// ScapFailure, stored as the last error of pluginState, so that a panic does not take
+// down the worker goroutine and the whole process.
+func asyncExtract(pluginState unsafe.Pointer, info *C.async_extractor_info, funcs asyncFuncs) {
+	defer Recover(pluginState, (*int32)(unsafe.Pointer(&info.rc)))
+
+	var err error
+	(*info).rc = C.int32_t(ScapSuccess)
+	switch uint32(info.ftype) {
+	case ParamTypeCharBuf:
+		if funcs.str != nil {
+			var res *byte
+			res, err = funcs.str(
+				pluginState,
+				uint64(info.evtnum),
+				uint32(info.id),
+				(*byte)(unsafe.Pointer(info.arg)),
+				(*byte)(unsafe.Pointer(info.data)),
+				uint32(info.datalen),
+			)
+			(*info).res_str = (*C.char)(unsafe.Pointer(res))
+		} else {
+			(*info).rc = C.int32_t(ScapNotSupported)
+		}
+	case ParamTypeUint64, ParamTypeInt64, ParamTypeBool, ParamTypeDouble, ParamTypeAbsTime, ParamTypeRelTime:
+		if funcs.u64 != nil {
+			var res uint64
+			var field_present uint32
+			res, err = funcs.u64(
+				pluginState,
+				uint64(info.evtnum),
+				uint32(info.id),
+				(*byte)(unsafe.Pointer(info.arg)),
+				(*byte)(unsafe.Pointer(info.data)),
+				uint32(info.datalen),
+				&(field_present),
+			)
+
+			(*info).res_u64 = C.uint64_t(res)
+			info.field_present = C.uint32_t(field_present)
+		} else {
+			(*info).rc = C.int32_t(ScapNotSupported)
+		}
+	case ParamTypeByteBuf, ParamTypeIpv4Addr, ParamTypeIpv6Addr, ParamTypeIpAddr,
+		ParamTypeIpv4Net, ParamTypeIpv6Net, ParamTypeIpNet:
+		// The buffer length is returned in res_u64, see StartAsyncBufExtractors()
+		if funcs.buf != nil {
+			var res *byte
+			var res_len uint32
+			res, err = funcs.buf(
+				pluginState,
+				uint64(info.evtnum),
+				uint32(info.id),
+				(*byte)(unsafe.Pointer(info.arg)),
+				(*byte)(unsafe.Pointer(info.data)),
+				uint32(info.datalen),
+				&(res_len),
+			)
+
+			(*info).res_str = (*C.char)(unsafe.Pointer(res))
+			(*info).res_u64 = C.uint64_t(res_len)
+		} else {
+			(*info).rc = C.int32_t(ScapNotSupported)
+		}
+	default:
+		(*info).rc = C.int32_t(ScapNotSupported)
+	}
+
+	if err != nil {
+		SetLastError(pluginState, err)
+		(*info).rc = C.int32_t(ScapFailure)
+	}
+}
+
+// RegisterAsyncFieldExtractors is like RegisterAsyncExtractors(), but serves the requests
+// with the field registry bound to pluginState with SetFields(), as ExtractStr() and
+// ExtractU64() do. Unlike them, it reports the extraction errors, such as a malformed
+// argument, by setting rc to ScapFailure, besides storing them as the last error of
+// pluginState. It is what the async package exports as plugin_register_async_extractor.
+//
+// The requests for buffer fields fail with ScapNotSupported, so that they keep being
+// extracted synchronously with plugin_extract_buf, as sinsp versions unaware of the
+// res_u64 length expect. Use RegisterAsyncFieldBufExtractors() to serve them too.
+func RegisterAsyncFieldExtractors(pluginState unsafe.Pointer, asyncExtractorInfo unsafe.Pointer) int32 {
+	return registerAsyncWorker(pluginState, func() *AsyncWorker {
+		return startAsyncWorker(pluginState, (*C.async_extractor_info)(asyncExtractorInfo), fieldAsyncFuncs)
+	})
+}
+
+// RegisterAsyncFieldBufExtractors is like RegisterAsyncFieldExtractors(), but serves the
+// buffer fields as well, as ExtractBuf() does, returning their length in res_u64
+// (see StartAsyncBufExtractors()). Plugins opt in by exporting it themselves:
+//
+//     //export plugin_register_async_extractor
+//     func plugin_register_async_extractor(pluginState unsafe.Pointer, asyncExtractorInfo unsafe.Pointer) int32 {
+//     	return sinsp.RegisterAsyncFieldBufExtractors(pluginState, asyncExtractorInfo)
+//     }
+//
+func RegisterAsyncFieldBufExtractors(pluginState unsafe.Pointer, asyncExtractorInfo unsafe.Pointer) int32 {
+	return registerAsyncWorker(pluginState, func() *AsyncWorker {
+		return startAsyncWorker(pluginState, (*C.async_extractor_info)(asyncExtractorInfo), fieldBufAsyncFuncs)
+	})
+}
+
+// asyncExtractType serves a request for a field of type ftype with funcs and returns
+// its rc, so that the tests can exercise asyncExtract() without building the request in C.
+func asyncExtractType(pluginState unsafe.Pointer, ftype uint32, funcs asyncFuncs) int32 {
+	info := (*C.async_extractor_info)(C.calloc(1, C.sizeof_async_extractor_info))
+	defer C.free(unsafe.Pointer(info))
+	info.ftype = C.uint32_t(ftype)
+	asyncExtract(pluginState, info, funcs)
+	return int32(info.rc)
 }
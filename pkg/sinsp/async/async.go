@@ -0,0 +1,25 @@
+// Package async exports plugin_register_async_extractor, enabling asynchronous
+// field extraction for plugins built with the source or extractor packages.
+//
+// The buffer fields are not served and keep being extracted synchronously with
+// plugin_extract_buf (see sinsp.RegisterAsyncFieldExtractors()). Plugins serving
+// them asynchronously export plugin_register_async_extractor themselves instead,
+// with sinsp.RegisterAsyncFieldBufExtractors().
+//
+// It is meant to be imported for its side effects only:
+//
+//     import _ "github.com/ldegio/libsinsp-plugin-sdk-go/pkg/sinsp/async"
+//
+package async
+
+import "C"
+import (
+	"unsafe"
+
+	"github.com/ldegio/libsinsp-plugin-sdk-go/pkg/sinsp"
+)
+
+//export plugin_register_async_extractor
+func plugin_register_async_extractor(pState unsafe.Pointer, asyncExtractorInfo unsafe.Pointer) int32 {
+	return sinsp.RegisterAsyncFieldExtractors(pState, asyncExtractorInfo)
+}
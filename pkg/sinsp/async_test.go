@@ -0,0 +1,188 @@
+package sinsp
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeAsyncHost drives an AsyncWorker the way sinsp does, one request at a time.
+type fakeAsyncHost struct {
+	// true sends a request, false ends the protocol
+	requests chan bool
+	results  chan string
+}
+
+// startFakeWorker starts a worker serving the requests of the returned host with serve.
+func startFakeWorker(serve func()) (*AsyncWorker, *fakeAsyncHost) {
+	h := &fakeAsyncHost{requests: make(chan bool), results: make(chan string, 1)}
+	w := runAsyncWorker(
+		func() bool { return <-h.requests },
+		func() {
+			serve()
+			h.results <- "served"
+		},
+		func() { h.results <- "rejected" },
+	)
+	return w, h
+}
+
+// request sends a request and checks its outcome.
+func (h *fakeAsyncHost) request(t *testing.T, want string) {
+	t.Helper()
+	h.requests <- true
+	if got := <-h.results; got != want {
+		t.Fatalf("request: got %s, want %s", got, want)
+	}
+}
+
+// end ends the protocol and waits for the worker to exit.
+func (h *fakeAsyncHost) end(t *testing.T, w *AsyncWorker) {
+	t.Helper()
+	h.requests <- false
+	select {
+	case <-w.Done():
+	case <-time.After(time.Second):
+		t.Fatal("the worker did not exit at the end of the protocol")
+	}
+	w.Wait()
+}
+
+func TestAsyncWorkerStop(t *testing.T) {
+	w, h := startFakeWorker(func() {})
+
+	h.request(t, "served")
+	if w.Stopped() {
+		t.Fatal("Stopped before Stop")
+	}
+	w.Stop()
+	if !w.Stopped() {
+		t.Fatal("not Stopped after Stop")
+	}
+
+	// The worker keeps running, rejecting the requests, until the protocol ends
+	h.request(t, "rejected")
+	h.request(t, "rejected")
+	h.end(t, w)
+}
+
+func TestAsyncWorkerQuiesce(t *testing.T) {
+	entered, release := make(chan struct{}), make(chan struct{})
+	w, h := startFakeWorker(func() {
+		close(entered)
+		<-release
+	})
+
+	if !w.Quiesce(time.Second) {
+		t.Fatal("Quiesce failed with no request in progress")
+	}
+
+	h.requests <- true
+	<-entered
+	w.Stop()
+	if w.Quiesce(10 * time.Millisecond) {
+		t.Fatal("Quiesce succeeded with a request in progress")
+	}
+
+	close(release)
+	if got := <-h.results; got != "served" {
+		t.Fatalf("request: got %s, want served", got)
+	}
+	if !w.Quiesce(time.Second) {
+		t.Fatal("Quiesce failed after the request completed")
+	}
+	h.request(t, "rejected")
+	h.end(t, w)
+}
+
+func TestFreeStopsAsyncWorker(t *testing.T) {
+	w, h := startFakeWorker(func() {})
+	pState := NewStateContainer()
+	setAsyncExtractors(pState, w)
+	h.request(t, "served")
+
+	Free(pState)
+	if !w.Stopped() {
+		t.Fatal("Free did not stop the worker")
+	}
+	h.request(t, "rejected")
+	h.end(t, w)
+}
+
+func TestFreeAsyncWorkerBusy(t *testing.T) {
+	defer func(d time.Duration) { asyncStopTimeout = d }(asyncStopTimeout)
+	asyncStopTimeout = 10 * time.Millisecond
+
+	entered, release := make(chan struct{}), make(chan struct{})
+	w, h := startFakeWorker(func() {
+		close(entered)
+		<-release
+	})
+	pState := NewStateContainer()
+	setAsyncExtractors(pState, w)
+	h.requests <- true
+	<-entered
+
+	// The state is still in use, so it is leaked, but not silently
+	Free(pState)
+	err := LastError(pState)
+	if err == nil || !strings.Contains(err.Error(), "not released") {
+		t.Fatalf("got last error %v, want the leak", err)
+	}
+	if debugMode && !strings.Contains(DebugReport(), "Free() failed: "+err.Error()) {
+		t.Fatalf("got report:\n%s\nwant the leak", DebugReport())
+	}
+
+	// Once the request completes, the state can be freed
+	close(release)
+	if got := <-h.results; got != "served" {
+		t.Fatalf("request: got %s, want served", got)
+	}
+	Free(pState)
+	h.request(t, "rejected")
+	h.end(t, w)
+}
+
+func TestRegisterAsyncWorkerStopsPrevious(t *testing.T) {
+	pState := NewStateContainer()
+	defer Free(pState)
+
+	w1, h1 := startFakeWorker(func() {})
+	if rc := registerAsyncWorker(pState, func() *AsyncWorker { return w1 }); rc != ScapSuccess {
+		t.Fatalf("first registration: got %s", CodeString(rc))
+	}
+	w2, h2 := startFakeWorker(func() {})
+	if rc := registerAsyncWorker(pState, func() *AsyncWorker { return w2 }); rc != ScapSuccess {
+		t.Fatalf("second registration: got %s", CodeString(rc))
+	}
+
+	if AsyncExtractors(pState) != w2 {
+		t.Fatal("the second worker is not bound to the state")
+	}
+	if !w1.Stopped() {
+		t.Fatal("the first worker was not stopped")
+	}
+	h1.request(t, "rejected")
+	h2.request(t, "served")
+	h1.end(t, w1)
+	h2.end(t, w2)
+}
+
+func TestAsyncFieldExtractorsBuf(t *testing.T) {
+	pState := NewStateContainer()
+	defer Free(pState)
+
+	// By default the buffer fields are left to plugin_extract_buf
+	for _, ft := range []uint32{ParamTypeByteBuf, ParamTypeIpv4Addr, ParamTypeIpNet} {
+		if rc := asyncExtractType(pState, ft, fieldAsyncFuncs); rc != ScapNotSupported {
+			t.Fatalf("type %d: got rc %d, want ScapNotSupported", ft, rc)
+		}
+	}
+	if fieldAsyncFuncs.str == nil || fieldAsyncFuncs.u64 == nil {
+		t.Fatal("the string and numeric fields are not served")
+	}
+	// Unless explicitly requested
+	if fieldBufAsyncFuncs.buf == nil {
+		t.Fatal("the buffer fields are not served by RegisterAsyncFieldBufExtractors")
+	}
+}
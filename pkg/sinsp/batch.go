@@ -1,75 +1,239 @@
 package sinsp
 
 import (
-	"encoding/binary"
+	"sync/atomic"
+	"time"
 	"unsafe"
+
+	"github.com/ldegio/libsinsp-plugin-sdk-go/pkg/sinsp/batch"
+)
+
+// OversizePolicy tells NextBatch() what to do with an event that does not fit
+// into the batch buffer by itself.
+type OversizePolicy int
+
+const (
+	// OversizeError makes NextBatch() drop the event and fail with ScapFailure.
+	OversizeError OversizePolicy = iota
+	// OversizeTruncate makes NextBatch() truncate the event payload to fit the buffer.
+	OversizeTruncate
+	// OversizeSplit makes NextBatch() split the event payload across multiple
+	// records with the same timestamp, returned by consecutive calls.
+	OversizeSplit
 )
 
+// BatchOptions configures the behavior of NextBatch() for an open state.
+//
+// NextBatch() returns as soon as any of the limits is reached. A zero limit
+// means no limit other than the size of the buffer.
+type BatchOptions struct {
+	Oversize OversizePolicy
+	// MaxEvents is the maximum number of events in a batch.
+	MaxEvents uint32
+	// MaxBytes is the maximum size of a batch, including the record headers.
+	// Events larger than that are handled according to Oversize.
+	MaxBytes uint32
+	// MaxLatency is the maximum time spent filling a batch, measured from the
+	// beginning of the NextBatch() call. Since it is checked between events,
+	// a nextf blocking for longer can make a batch exceed it. At least one
+	// event is requested from nextf anyway, so that a batch is never empty
+	// because of it.
+	MaxLatency time.Duration
+	// Clock returns the current time. If nil, time.Now is used.
+	Clock func() time.Time
+}
+
+func (o *BatchOptions) now() time.Time {
+	if o.Clock != nil {
+		return o.Clock()
+	}
+	return time.Now()
+}
+
+// limitReached reports whether a batch with n events and started at start is complete.
+func (o *BatchOptions) limitReached(n uint32, start time.Time) bool {
+	return (o.MaxEvents > 0 && n >= o.MaxEvents) ||
+		(o.MaxLatency > 0 && o.now().Sub(start) >= o.MaxLatency)
+}
+
 type batchContext struct {
 	nextBatchLastTs   uint64
 	nextBatchLastData []byte
+	// whether nextBatchLastData is the remainder of a split event
+	nextBatchLastSplit bool
+	// result of nextf to be returned by the next call, once the
+	// events packed before it have been delivered
+	nextBatchLastRes int32
+	// metadata of nextBatchLastData
+	nextBatchLastMetadata map[string]string
+	// metadata of the event just returned by a NextFunc made with ToNextFunc()
+	nextMetadata map[string]string
+	// metadata of the events returned by the last Next() or NextBatch() call
+	metadata []map[string]string
+	opts     BatchOptions
+	oversize uint64
+}
+
+// SetBatchOptions sets the options used by NextBatch() for openState,
+// assuming openState is a state container created with NewStateContainer().
+//
+// Since SetContext() resets the options, it must be called after it.
+func SetBatchOptions(openState unsafe.Pointer, opts BatchOptions) {
+	getBatchCtx(openState).opts = opts
+}
+
+// OversizeEvents returns the number of events that did not fit into the batch buffer
+// of openState by themselves, and have been handled according to the OversizePolicy.
+func OversizeEvents(openState unsafe.Pointer) uint64 {
+	return atomic.LoadUint64(&getBatchCtx(openState).oversize)
 }
 
 // NextFunc is the function type required by NextBatch().
 type NextFunc func(plgState unsafe.Pointer, openState unsafe.Pointer, data *[]byte, ts *uint64) int32
 
+// callNext invokes nextf, turning a panic into ScapFailure.
+func callNext(nextf NextFunc, plgState unsafe.Pointer, openState unsafe.Pointer, data *[]byte, ts *uint64) (res int32) {
+	defer Recover(plgState, &res)
+	return nextf(plgState, openState, data, ts)
+}
+
 // NextBatch is an helper function to be used within plugin_next_batch.
+//
+// The events returned by nextf are packed into the buffer of openState following
+// the framing implemented by the batch package, which can be used to decode them.
+// NextBatch calls nextf until the buffer is full, a limit set with SetBatchOptions()
+// is reached, or nextf returns something other than ScapSuccess. In the latter
+// case, if some events have already been packed, they are returned with
+// ScapSuccess and the code returned by nextf is returned by the following call.
+// An event that does not fit into the buffer by itself is handled according to
+// the OversizePolicy set with SetBatchOptions().
+//
+// A panic in nextf is recovered and reported as ScapFailure, with the
+// panic stored as the last error of plgState.
 func NextBatch(plgState unsafe.Pointer, openState unsafe.Pointer, data **byte, datalen *uint32, nextf NextFunc) int32 {
 	var ts uint64
-	tsbuf := make([]byte, int(unsafe.Sizeof(ts)))
-	var elen uint32
-	elenbuf := make([]byte, int(unsafe.Sizeof(elen)))
-	res := ScapSuccess
-	*datalen = 0
 	var pos uint32 = 0
 	var nextData []byte
+	var nevts uint32 = 0
+	res := ScapSuccess
+	*data = Buffer(openState)
+	*datalen = 0
 
 	bCtx := getBatchCtx(openState)
+	bCtx.metadata = nil
+	start := bCtx.opts.now()
 	if bCtx.nextBatchLastData != nil {
 		//
 		// There is leftover data from the previous call, copy it at the start
 		// of the buffer
 		//
-		loData := &bCtx.nextBatchLastData
-		binary.LittleEndian.PutUint64(tsbuf, bCtx.nextBatchLastTs)
-		binary.LittleEndian.PutUint32(elenbuf, uint32(len(*loData)))
-		pos += CopyToBufferAt(openState, tsbuf, pos)
-		pos += CopyToBufferAt(openState, elenbuf, pos)
-		pos += CopyToBufferAt(openState, *loData, pos)
+		loTs, loData, loSplit, loMetadata := bCtx.nextBatchLastTs, bCtx.nextBatchLastData, bCtx.nextBatchLastSplit, bCtx.nextBatchLastMetadata
+		bCtx.nextBatchLastData = nil
+		bCtx.nextBatchLastSplit = false
+		bCtx.nextBatchLastMetadata = nil
+		if pos, res = packEvent(plgState, openState, bCtx, pos, loTs, loData, loSplit, loMetadata); res != ScapSuccess {
+			return res
+		}
+		nevts++
+	} else if bCtx.nextBatchLastRes != ScapSuccess {
+		//
+		// The previous call packed some events before nextf returned
+		// this code, return it now
+		//
+		res, bCtx.nextBatchLastRes = bCtx.nextBatchLastRes, ScapSuccess
+		return res
 	}
 
-	bCtx.nextBatchLastData = nil
-
-	for true {
-		res = nextf(plgState, openState, &nextData, &ts)
+	for bCtx.nextBatchLastData == nil && (nevts == 0 || !bCtx.opts.limitReached(nevts, start)) {
+		bCtx.nextMetadata = nil
+		res = callNext(nextf, plgState, openState, &nextData, &ts)
 		if res == ScapSuccess {
-			endPos := pos + uint32(len(nextData)) + 12
-			if endPos < MaxNextBufSize {
-				// Copy the event into the buffer
-				binary.LittleEndian.PutUint64(tsbuf, ts)
-				binary.LittleEndian.PutUint32(elenbuf, uint32(len(nextData)))
-				pos += CopyToBufferAt(openState, tsbuf, pos)
-				pos += CopyToBufferAt(openState, elenbuf, pos)
-				pos += CopyToBufferAt(openState, nextData, pos)
-			} else {
-				if pos > 0 {
-					// Buffer full. Save this event for the next read
-					bCtx.nextBatchLastTs = ts
-					bCtx.nextBatchLastData = nextData
-				} else {
-					// This event is too big to fit in the buffer by itself.
-					// Skip it.
-					res = ScapTimeout
-				}
-				break
+			pos, res = packEvent(plgState, openState, bCtx, pos, ts, nextData, false, bCtx.nextMetadata)
+		}
+		if res == ScapSuccess && bCtx.nextBatchLastData == nil {
+			nevts++
+		}
+		if res != ScapSuccess {
+			if pos == 0 {
+				return res
 			}
-		} else {
+			// Deliver the events packed so far first
+			bCtx.nextBatchLastRes = res
 			break
 		}
 	}
 
-	*data = Buffer(openState)
 	*datalen = pos
+	return ScapSuccess
+}
+
+// batchBufferLen returns the number of bytes of the buffer of openState usable by NextBatch().
+func batchBufferLen(openState unsafe.Pointer, opts *BatchOptions) uint32 {
+	l := BufferLen(openState)
+	if l > MaxNextBufSize {
+		l = MaxNextBufSize
+	}
+	if opts.MaxBytes > 0 && l > opts.MaxBytes {
+		l = opts.MaxBytes
+	}
+	return l
+}
+
+// packEvent copies the event into the buffer of openState at pos, returning the position
+// following it. If the event does not fit, it is saved in bCtx for the next call, unless
+// the buffer is empty, in which case it is handled according to the oversize policy.
+// split tells whether data is the remainder of an event already split, and md is
+// the metadata of the event, recorded for each record written.
+func packEvent(plgState unsafe.Pointer, openState unsafe.Pointer, bCtx *batchContext, pos uint32, ts uint64, data []byte, split bool, md map[string]string) (uint32, int32) {
+	bufLen := batchBufferLen(openState, &bCtx.opts)
+	if uint64(pos)+uint64(batch.RecordSize(uint32(len(data)))) <= uint64(bufLen) {
+		bCtx.metadata = append(bCtx.metadata, md)
+		return writeRecord(openState, pos, ts, data), ScapSuccess
+	}
+
+	if pos > 0 {
+		// Buffer full. Save this event for the next read
+		if data == nil {
+			// nil means no leftover data
+			data = []byte{}
+		}
+		bCtx.nextBatchLastTs = ts
+		bCtx.nextBatchLastData = data
+		bCtx.nextBatchLastSplit = split
+		bCtx.nextBatchLastMetadata = md
+		return pos, ScapSuccess
+	}
+
+	// This event is too big to fit in the buffer by itself
+	if !split {
+		atomic.AddUint64(&bCtx.oversize, 1)
+	}
+	var maxData uint32
+	if bufLen > batch.HeaderSize {
+		maxData = bufLen - batch.HeaderSize
+	}
+	switch {
+	case maxData > 0 && bCtx.opts.Oversize == OversizeTruncate:
+		bCtx.metadata = append(bCtx.metadata, md)
+		return writeRecord(openState, pos, ts, data[:maxData]), ScapSuccess
+	case maxData > 0 && bCtx.opts.Oversize == OversizeSplit:
+		bCtx.nextBatchLastTs = ts
+		bCtx.nextBatchLastData = data[maxData:]
+		bCtx.nextBatchLastSplit = true
+		bCtx.nextBatchLastMetadata = md
+		bCtx.metadata = append(bCtx.metadata, md)
+		return writeRecord(openState, pos, ts, data[:maxData]), ScapSuccess
+	default:
+		return pos, HandleError(plgState, NewError(ScapFailure, "event of %d bytes does not fit into the %d bytes batch buffer", len(data), bufLen))
+	}
+}
 
-	return res
+// writeRecord copies the record of the event into the buffer of openState at pos,
+// returning the position following it.
+func writeRecord(openState unsafe.Pointer, pos uint32, ts uint64, data []byte) uint32 {
+	hdr := make([]byte, batch.HeaderSize)
+	batch.PutHeader(hdr, ts, uint32(len(data)))
+	pos += CopyToBufferAt(openState, hdr, pos)
+	pos += CopyToBufferAt(openState, data, pos)
+	return pos
 }
@@ -0,0 +1,159 @@
+// Package batch implements the framing of the event batches returned by plugin_next_batch.
+//
+// A batch is a sequence of records, one per event, each made of:
+//
+//     | ts (8 bytes, little endian) | len (4 bytes, little endian) | data (len bytes) |
+//
+// where ts is the event timestamp in nanoseconds and data is the event payload.
+package batch
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+// HeaderSize is the size of the header preceding the payload of each record.
+const HeaderSize = 12
+
+// ErrTruncated is returned when a batch ends in the middle of a record.
+var ErrTruncated = errors.New("batch: truncated record")
+
+// RecordSize returns the size of the record holding a payload of datalen bytes.
+func RecordSize(datalen uint32) uint32 {
+	return HeaderSize + datalen
+}
+
+// PutHeader writes the header of a record into b, which must be at least HeaderSize bytes long.
+func PutHeader(b []byte, ts uint64, datalen uint32) {
+	binary.LittleEndian.PutUint64(b[0:8], ts)
+	binary.LittleEndian.PutUint32(b[8:12], datalen)
+}
+
+// Header reads the header of a record from b, which must be at least HeaderSize bytes long.
+func Header(b []byte) (ts uint64, datalen uint32) {
+	return binary.LittleEndian.Uint64(b[0:8]), binary.LittleEndian.Uint32(b[8:12])
+}
+
+// AppendRecord appends the record for the given event to dst and returns the extended slice.
+func AppendRecord(dst []byte, ts uint64, data []byte) []byte {
+	var hdr [HeaderSize]byte
+	PutHeader(hdr[:], ts, uint32(len(data)))
+	return append(append(dst, hdr[:]...), data...)
+}
+
+// Encoder writes records to an io.Writer.
+type Encoder struct {
+	w io.Writer
+}
+
+// NewEncoder returns an Encoder writing to w.
+func NewEncoder(w io.Writer) *Encoder {
+	return &Encoder{w: w}
+}
+
+// Encode writes the record for the given event.
+func (e *Encoder) Encode(ts uint64, data []byte) error {
+	var hdr [HeaderSize]byte
+	PutHeader(hdr[:], ts, uint32(len(data)))
+	if _, err := e.w.Write(hdr[:]); err != nil {
+		return err
+	}
+	_, err := e.w.Write(data)
+	return err
+}
+
+// Decoder reads records from an io.Reader.
+type Decoder struct {
+	r io.Reader
+}
+
+// NewDecoder returns a Decoder reading from r.
+func NewDecoder(r io.Reader) *Decoder {
+	return &Decoder{r: r}
+}
+
+// Decode reads the next record. It returns io.EOF when there are no more records,
+// and ErrTruncated if the input ends in the middle of a record.
+func (d *Decoder) Decode() (ts uint64, data []byte, err error) {
+	var hdr [HeaderSize]byte
+	if _, err = io.ReadFull(d.r, hdr[:]); err != nil {
+		if err == io.ErrUnexpectedEOF {
+			err = ErrTruncated
+		}
+		return 0, nil, err
+	}
+
+	ts, datalen := Header(hdr[:])
+	data = make([]byte, datalen)
+	if _, err = io.ReadFull(d.r, data); err != nil {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			err = ErrTruncated
+		}
+		return 0, nil, err
+	}
+	return ts, data, nil
+}
+
+// Iterator iterates over the records of a batch held in memory, without copying them.
+//
+// Intended usage as in the following example:
+//
+//     it := batch.NewIterator(b)
+//     for it.Next() {
+//     	process(it.Ts(), it.Data())
+//     }
+//     if err := it.Err(); err != nil {
+//     	...
+//     }
+//
+type Iterator struct {
+	b    []byte
+	ts   uint64
+	data []byte
+	err  error
+}
+
+// NewIterator returns an Iterator over the records in b.
+func NewIterator(b []byte) *Iterator {
+	return &Iterator{b: b}
+}
+
+// Next advances to the next record and reports whether there is one.
+// It returns false at the end of the batch or if a record is truncated, see Err.
+func (it *Iterator) Next() bool {
+	if it.err != nil || len(it.b) == 0 {
+		return false
+	}
+	if len(it.b) < HeaderSize {
+		it.err = ErrTruncated
+		return false
+	}
+
+	ts, datalen := Header(it.b)
+	if uint64(len(it.b)-HeaderSize) < uint64(datalen) {
+		it.err = ErrTruncated
+		return false
+	}
+
+	end := HeaderSize + int(datalen)
+	it.ts = ts
+	it.data = it.b[HeaderSize:end:end]
+	it.b = it.b[end:]
+	return true
+}
+
+// Ts returns the timestamp of the current record.
+func (it *Iterator) Ts() uint64 {
+	return it.ts
+}
+
+// Data returns the payload of the current record. It aliases the batch memory.
+func (it *Iterator) Data() []byte {
+	return it.data
+}
+
+// Err returns the error, if any, that stopped the iteration.
+func (it *Iterator) Err() error {
+	return it.err
+}
@@ -0,0 +1,132 @@
+package batch
+
+import (
+	"bytes"
+	"io"
+	"testing"
+)
+
+type record struct {
+	ts   uint64
+	data []byte
+}
+
+var records = []record{
+	{ts: 1, data: []byte("first")},
+	{ts: 0, data: []byte{}},
+	{ts: 1 << 63, data: []byte("third record")},
+}
+
+func encode(t *testing.T) []byte {
+	var buf bytes.Buffer
+	enc := NewEncoder(&buf)
+	for _, r := range records {
+		if err := enc.Encode(r.ts, r.data); err != nil {
+			t.Fatalf("Encode: %s", err)
+		}
+	}
+	return buf.Bytes()
+}
+
+func TestEncoderMatchesAppendRecord(t *testing.T) {
+	var want []byte
+	for _, r := range records {
+		want = AppendRecord(want, r.ts, r.data)
+	}
+	if got := encode(t); !bytes.Equal(got, want) {
+		t.Fatalf("Encoder wrote %x, AppendRecord %x", got, want)
+	}
+}
+
+func TestDecoderRoundTrip(t *testing.T) {
+	dec := NewDecoder(bytes.NewReader(encode(t)))
+	for i, r := range records {
+		ts, data, err := dec.Decode()
+		if err != nil {
+			t.Fatalf("record %d: Decode: %s", i, err)
+		}
+		if ts != r.ts || !bytes.Equal(data, r.data) {
+			t.Fatalf("record %d: got (%d, %q), want (%d, %q)", i, ts, data, r.ts, r.data)
+		}
+	}
+	if _, _, err := dec.Decode(); err != io.EOF {
+		t.Fatalf("Decode at the end: got %v, want io.EOF", err)
+	}
+}
+
+func TestIteratorRoundTrip(t *testing.T) {
+	it := NewIterator(encode(t))
+	i := 0
+	for it.Next() {
+		if i >= len(records) {
+			t.Fatalf("unexpected record %d", i)
+		}
+		r := records[i]
+		if it.Ts() != r.ts || !bytes.Equal(it.Data(), r.data) {
+			t.Fatalf("record %d: got (%d, %q), want (%d, %q)", i, it.Ts(), it.Data(), r.ts, r.data)
+		}
+		i++
+	}
+	if err := it.Err(); err != nil {
+		t.Fatalf("Err: %s", err)
+	}
+	if i != len(records) {
+		t.Fatalf("got %d records, want %d", i, len(records))
+	}
+}
+
+func TestIteratorEmpty(t *testing.T) {
+	it := NewIterator(nil)
+	if it.Next() {
+		t.Fatal("Next returned true on an empty batch")
+	}
+	if err := it.Err(); err != nil {
+		t.Fatalf("Err: %s", err)
+	}
+}
+
+// truncations returns the encoded records cut in the middle of the header
+// and in the middle of the payload of the last record.
+func truncations(t *testing.T) map[string][]byte {
+	b := encode(t)
+	last := len(b) - int(RecordSize(uint32(len(records[len(records)-1].data))))
+	return map[string][]byte{
+		"header":  b[:last+HeaderSize/2],
+		"payload": b[:len(b)-1],
+	}
+}
+
+func TestDecoderTruncated(t *testing.T) {
+	for name, b := range truncations(t) {
+		dec := NewDecoder(bytes.NewReader(b))
+		var err error
+		for n := 0; err == nil; n++ {
+			if n > len(records) {
+				t.Fatalf("%s: no error after %d records", name, n)
+			}
+			_, _, err = dec.Decode()
+		}
+		if err != ErrTruncated {
+			t.Errorf("%s: got %v, want ErrTruncated", name, err)
+		}
+	}
+}
+
+func TestIteratorTruncated(t *testing.T) {
+	for name, b := range truncations(t) {
+		it := NewIterator(b)
+		n := 0
+		for it.Next() {
+			n++
+		}
+		if n != len(records)-1 {
+			t.Errorf("%s: got %d records, want %d", name, n, len(records)-1)
+		}
+		if err := it.Err(); err != ErrTruncated {
+			t.Errorf("%s: got %v, want ErrTruncated", name, err)
+		}
+		if it.Next() {
+			t.Errorf("%s: Next returned true after an error", name)
+		}
+	}
+}
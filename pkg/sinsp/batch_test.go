@@ -0,0 +1,261 @@
+package sinsp
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+	"unsafe"
+
+	"github.com/ldegio/libsinsp-plugin-sdk-go/pkg/sinsp/batch"
+)
+
+// fakeClock is a clock for BatchOptions.Clock that only moves when told to.
+type fakeClock struct {
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	return c.now
+}
+
+// fakeSource serves the events to NextBatch(), in order, with timestamps starting
+// from 1, and then returns res. Every event served advances clock by tick.
+type fakeSource struct {
+	events [][]byte
+	res    int32
+	served int
+	clock  *fakeClock
+	tick   time.Duration
+}
+
+func (s *fakeSource) next(plgState unsafe.Pointer, openState unsafe.Pointer, data *[]byte, ts *uint64) int32 {
+	if s.served == len(s.events) {
+		return s.res
+	}
+	*data = s.events[s.served]
+	s.served++
+	*ts = uint64(s.served)
+	if s.clock != nil {
+		s.clock.now = s.clock.now.Add(s.tick)
+	}
+	return ScapSuccess
+}
+
+// record is an event as decoded from a batch.
+type record struct {
+	ts   uint64
+	data string
+}
+
+// result is the outcome of a NextBatch() call.
+type result struct {
+	rc      int32
+	records []record
+}
+
+func (r result) String() string {
+	return fmt.Sprintf("%s %v", CodeString(r.rc), r.records)
+}
+
+// newBatchState returns an open state with a buffer of bufLen bytes, configured with opts.
+func newBatchState(t *testing.T, bufLen uint32, opts BatchOptions) unsafe.Pointer {
+	oState := NewStateContainer()
+	t.Cleanup(func() { Free(oState) })
+	if err := MakeBuffer(oState, bufLen); err != nil {
+		t.Fatalf("MakeBuffer: %s", err)
+	}
+	SetContextValue(oState, t.Name())
+	SetBatchOptions(oState, opts)
+	return oState
+}
+
+// nextBatch calls NextBatch() on oState with nextf and decodes the returned batch.
+func nextBatch(t *testing.T, oState unsafe.Pointer, nextf NextFunc) result {
+	var data *byte
+	var datalen uint32
+	r := result{rc: NextBatch(nil, oState, &data, &datalen, nextf)}
+	if r.rc != ScapSuccess && datalen != 0 {
+		t.Fatalf("NextBatch returned %s with %d bytes", CodeString(r.rc), datalen)
+	}
+	if data != Buffer(oState) {
+		t.Fatalf("NextBatch did not return the buffer of the open state")
+	}
+
+	it := batch.NewIterator(BufferBytes(oState)[:datalen])
+	for it.Next() {
+		r.records = append(r.records, record{ts: it.Ts(), data: string(it.Data())})
+	}
+	if err := it.Err(); err != nil {
+		t.Fatalf("decoding the batch: %s", err)
+	}
+	return r
+}
+
+// checkBatches calls NextBatch() once per expected result and compares the outcomes.
+func checkBatches(t *testing.T, oState unsafe.Pointer, src *fakeSource, want []result) {
+	t.Helper()
+	for i, w := range want {
+		if got := nextBatch(t, oState, src.next); got.String() != w.String() {
+			t.Fatalf("call %d: got %s, want %s", i, got, w)
+		}
+	}
+}
+
+// events returns n events of size bytes each, named after their index.
+func events(n int, size int) [][]byte {
+	evts := make([][]byte, n)
+	for i := range evts {
+		evts[i] = []byte(fmt.Sprintf("%0*d", size, i))
+	}
+	return evts
+}
+
+// records returns the records of the events of src from index i to j.
+func records(src *fakeSource, i, j int) []record {
+	var r []record
+	for ; i < j; i++ {
+		r = append(r, record{ts: uint64(i + 1), data: string(src.events[i])})
+	}
+	return r
+}
+
+func TestNextBatchMaxEvents(t *testing.T) {
+	clock := &fakeClock{now: time.Unix(0, 0)}
+	src := &fakeSource{events: events(7, 4), res: ScapEOF}
+	oState := newBatchState(t, 4096, BatchOptions{MaxEvents: 3, MaxLatency: time.Second, Clock: clock.Now})
+
+	checkBatches(t, oState, src, []result{
+		{ScapSuccess, records(src, 0, 3)},
+		{ScapSuccess, records(src, 3, 6)},
+		{ScapSuccess, records(src, 6, 7)},
+		{ScapEOF, nil},
+	})
+}
+
+func TestNextBatchMaxBytes(t *testing.T) {
+	clock := &fakeClock{now: time.Unix(0, 0)}
+	src := &fakeSource{events: events(5, 8), res: ScapEOF}
+	// Two records of 20 bytes fit, the third one does not
+	oState := newBatchState(t, 4096, BatchOptions{MaxBytes: 50, MaxLatency: time.Second, Clock: clock.Now})
+
+	checkBatches(t, oState, src, []result{
+		{ScapSuccess, records(src, 0, 2)},
+		{ScapSuccess, records(src, 2, 4)},
+		{ScapSuccess, records(src, 4, 5)},
+		{ScapEOF, nil},
+	})
+}
+
+func TestNextBatchMaxLatency(t *testing.T) {
+	clock := &fakeClock{now: time.Unix(0, 0)}
+	src := &fakeSource{events: events(7, 4), res: ScapEOF, clock: clock, tick: 10 * time.Millisecond}
+	// The limit is reached after the third event, 30ms into the batch
+	oState := newBatchState(t, 4096, BatchOptions{MaxLatency: 25 * time.Millisecond, Clock: clock.Now})
+
+	checkBatches(t, oState, src, []result{
+		{ScapSuccess, records(src, 0, 3)},
+		{ScapSuccess, records(src, 3, 6)},
+		{ScapSuccess, records(src, 6, 7)},
+		{ScapEOF, nil},
+	})
+}
+
+func TestNextBatchMaxLatencyElapsed(t *testing.T) {
+	clock := &fakeClock{now: time.Unix(0, 0)}
+	src := &fakeSource{events: events(2, 4), res: ScapEOF}
+	// The limit is reached before the first event is requested
+	oState := newBatchState(t, 4096, BatchOptions{MaxLatency: time.Millisecond, Clock: func() time.Time {
+		clock.now = clock.now.Add(time.Second)
+		return clock.now
+	}})
+
+	checkBatches(t, oState, src, []result{
+		{ScapSuccess, records(src, 0, 1)},
+		{ScapSuccess, records(src, 1, 2)},
+		{ScapEOF, nil},
+	})
+}
+
+func TestNextBatchPartialThenTerminalCode(t *testing.T) {
+	for _, res := range []int32{ScapTimeout, ScapEOF, ScapFailure} {
+		t.Run(CodeString(res), func(t *testing.T) {
+			src := &fakeSource{events: events(2, 4), res: res}
+			oState := newBatchState(t, 4096, BatchOptions{})
+
+			// The code is returned once the events packed before it
+			// are delivered, and then again by nextf
+			checkBatches(t, oState, src, []result{
+				{ScapSuccess, records(src, 0, 2)},
+				{res, nil},
+				{res, nil},
+			})
+
+			// Events produced afterwards are batched as usual
+			src.events = append(src.events, []byte("more"))
+			checkBatches(t, oState, src, []result{
+				{ScapSuccess, records(src, 2, 3)},
+				{res, nil},
+			})
+		})
+	}
+}
+
+func TestNextBatchOversize(t *testing.T) {
+	big := strings.Repeat("abcdefghij", 5)
+	tests := []struct {
+		name   string
+		policy OversizePolicy
+		want   []result
+	}{
+		{"error", OversizeError, []result{
+			{ScapSuccess, []record{{1, "small"}}},
+			{ScapFailure, nil},
+			{ScapSuccess, []record{{3, "tail"}}},
+			{ScapEOF, nil},
+		}},
+		{"truncate", OversizeTruncate, []result{
+			{ScapSuccess, []record{{1, "small"}}},
+			{ScapSuccess, []record{{2, big[:20]}}},
+			{ScapSuccess, []record{{3, "tail"}}},
+			{ScapEOF, nil},
+		}},
+		{"split", OversizeSplit, []result{
+			{ScapSuccess, []record{{1, "small"}}},
+			{ScapSuccess, []record{{2, big[:20]}}},
+			{ScapSuccess, []record{{2, big[20:40]}}},
+			{ScapSuccess, []record{{2, big[40:]}}},
+			{ScapSuccess, []record{{3, "tail"}}},
+			{ScapEOF, nil},
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			src := &fakeSource{events: [][]byte{[]byte("small"), []byte(big), []byte("tail")}, res: ScapEOF}
+			// Room for one record with up to 20 bytes of payload
+			oState := newBatchState(t, 32, BatchOptions{Oversize: tt.policy})
+
+			checkBatches(t, oState, src, tt.want)
+			if n := OversizeEvents(oState); n != 1 {
+				t.Errorf("OversizeEvents: got %d, want 1", n)
+			}
+		})
+	}
+}
+
+func TestNextBatchOversizeMaxBytes(t *testing.T) {
+	src := &fakeSource{events: [][]byte{bytes.Repeat([]byte("x"), 40), []byte("ok")}, res: ScapEOF}
+	// The buffer is large enough, but MaxBytes is not
+	oState := newBatchState(t, 4096, BatchOptions{MaxBytes: 32, Oversize: OversizeError})
+
+	checkBatches(t, oState, src, []result{
+		{ScapFailure, nil},
+		{ScapSuccess, []record{{2, "ok"}}},
+		{ScapEOF, nil},
+	})
+	if n := OversizeEvents(oState); n != 1 {
+		t.Errorf("OversizeEvents: got %d, want 1", n)
+	}
+}
@@ -0,0 +1,131 @@
+package sinsp
+
+import (
+	"errors"
+	"sync"
+	"unsafe"
+)
+
+// ErrSourceClosed is returned when pushing events to a closed ChannelSource.
+var ErrSourceClosed = errors.New("channel source closed")
+
+// ChannelSource adapts goroutines pushing events to the pull model of plugin_next
+// and plugin_next_batch, by means of a bounded channel. It is meant to be created
+// for every open state and can be used by any number of producer goroutines.
+//
+// Next returns ErrTimeout when no event is available, and ErrEOF once the source
+// has been closed and all the events pushed before have been returned.
+type ChannelSource struct {
+	events    chan Event
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+// NewChannelSource returns a ChannelSource buffering up to size events.
+func NewChannelSource(size int) *ChannelSource {
+	return &ChannelSource{
+		events: make(chan Event, size),
+		closed: make(chan struct{}),
+	}
+}
+
+// Push adds evt to c, blocking while c is full. It returns ErrSourceClosed
+// if c is closed. Events pushed concurrently with Close may be dropped.
+func (c *ChannelSource) Push(evt Event) error {
+	select {
+	case <-c.closed:
+		return ErrSourceClosed
+	default:
+	}
+
+	select {
+	case c.events <- evt:
+		return nil
+	case <-c.closed:
+		return ErrSourceClosed
+	}
+}
+
+// TryPush adds evt to c without blocking. It returns false if c is full or closed.
+func (c *ChannelSource) TryPush(evt Event) bool {
+	select {
+	case <-c.closed:
+		return false
+	default:
+	}
+
+	select {
+	case c.events <- evt:
+		return true
+	default:
+		return false
+	}
+}
+
+// Close signals that no more events will be pushed to c. It can be called more than once.
+func (c *ChannelSource) Close() {
+	c.closeOnce.Do(func() { close(c.closed) })
+}
+
+// Len returns the number of events waiting in c.
+func (c *ChannelSource) Len() int {
+	return len(c.events)
+}
+
+// Next returns the next event without blocking. It returns ErrTimeout if no event
+// is available, and ErrEOF if c is closed and all its events have been returned.
+func (c *ChannelSource) Next() (Event, error) {
+	select {
+	case evt := <-c.events:
+		return evt, nil
+	default:
+	}
+
+	select {
+	case <-c.closed:
+		// The source may have been closed after the first check,
+		// return the events pushed before that
+		select {
+		case evt := <-c.events:
+			return evt, nil
+		default:
+			return Event{}, ErrEOF
+		}
+	default:
+		return Event{}, ErrTimeout
+	}
+}
+
+// ChannelNext is a NextEventFunc returning the events of the ChannelSource set
+// as the context of openState with SetContextValue(). It returns an error if the
+// context of openState is not a *ChannelSource, including one set with SetContext().
+//
+// Intended usage as in the following example:
+//
+//     //export plugin_open
+//     func plugin_open(pState unsafe.Pointer, params *C.char, rc *int32) unsafe.Pointer {
+//     	c := sinsp.NewChannelSource(1024)
+//     	go produce(c)
+//     	oState := sinsp.NewStateContainer()
+//     	if err := sinsp.MakeBuffer(oState, sinsp.MaxNextBufSize); err != nil {
+//     		sinsp.Free(oState)
+//     		*rc = sinsp.HandleError(pState, err)
+//     		return nil
+//     	}
+//     	sinsp.SetContextValue(oState, c)
+//     	*rc = sinsp.ScapSuccess
+//     	return oState
+//     }
+//
+//     //export plugin_next_batch
+//     func plugin_next_batch(pState unsafe.Pointer, oState unsafe.Pointer, data **byte, datalen *uint32) int32 {
+//     	return sinsp.NextBatch(pState, oState, data, datalen, sinsp.ToNextFunc(sinsp.ChannelNext))
+//     }
+//
+func ChannelNext(plgState unsafe.Pointer, openState unsafe.Pointer) (Event, error) {
+	c, ok := ContextValue(openState).(*ChannelSource)
+	if !ok {
+		return Event{}, NewError(ScapFailure, "the context of the open state is %T, not a *ChannelSource set with SetContextValue()", ContextValue(openState))
+	}
+	return c.Next()
+}
@@ -0,0 +1,149 @@
+package sinsp
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+	"unsafe"
+)
+
+// checkNext calls c.Next() and compares the event data and error returned.
+func checkNext(t *testing.T, c *ChannelSource, data string, err error) {
+	t.Helper()
+	evt, e := c.Next()
+	if string(evt.Data) != data || !errors.Is(e, err) {
+		t.Fatalf("got (%q, %v), want (%q, %v)", evt.Data, e, data, err)
+	}
+}
+
+func TestChannelSourceTimeout(t *testing.T) {
+	c := NewChannelSource(4)
+	checkNext(t, c, "", ErrTimeout)
+
+	if err := c.Push(Event{Data: []byte("a")}); err != nil {
+		t.Fatalf("Push: %s", err)
+	}
+	checkNext(t, c, "a", nil)
+	checkNext(t, c, "", ErrTimeout)
+}
+
+func TestChannelSourceClose(t *testing.T) {
+	c := NewChannelSource(4)
+	for _, s := range []string{"a", "b"} {
+		if err := c.Push(Event{Data: []byte(s)}); err != nil {
+			t.Fatalf("Push: %s", err)
+		}
+	}
+	c.Close()
+	c.Close()
+
+	// The events pushed before closing are returned first
+	if c.Len() != 2 {
+		t.Fatalf("got %d events waiting, want 2", c.Len())
+	}
+	checkNext(t, c, "a", nil)
+	checkNext(t, c, "b", nil)
+	checkNext(t, c, "", ErrEOF)
+	checkNext(t, c, "", ErrEOF)
+
+	if err := c.Push(Event{Data: []byte("c")}); err != ErrSourceClosed {
+		t.Fatalf("Push after Close: got %v, want %v", err, ErrSourceClosed)
+	}
+	if c.TryPush(Event{Data: []byte("c")}) {
+		t.Fatalf("TryPush after Close: got true")
+	}
+	checkNext(t, c, "", ErrEOF)
+}
+
+func TestChannelSourceFull(t *testing.T) {
+	c := NewChannelSource(1)
+	if !c.TryPush(Event{Data: []byte("a")}) {
+		t.Fatalf("TryPush: got false on an empty source")
+	}
+	if c.TryPush(Event{Data: []byte("b")}) {
+		t.Fatalf("TryPush: got true on a full source")
+	}
+
+	// Push blocks until there is room, or the source is closed
+	pushed := make(chan error)
+	go func() { pushed <- c.Push(Event{Data: []byte("b")}) }()
+	select {
+	case err := <-pushed:
+		t.Fatalf("Push returned %v on a full source", err)
+	case <-time.After(10 * time.Millisecond):
+	}
+	checkNext(t, c, "a", nil)
+	if err := <-pushed; err != nil {
+		t.Fatalf("Push: %s", err)
+	}
+
+	go func() { pushed <- c.Push(Event{Data: []byte("c")}) }()
+	c.Close()
+	if err := <-pushed; err != ErrSourceClosed {
+		t.Fatalf("Push blocked by Close: got %v, want %v", err, ErrSourceClosed)
+	}
+}
+
+func TestChannelNextBatch(t *testing.T) {
+	c := NewChannelSource(16)
+	oState := NewStateContainer()
+	defer Free(oState)
+	if err := MakeBuffer(oState, 4096); err != nil {
+		t.Fatalf("MakeBuffer: %s", err)
+	}
+	SetContextValue(oState, c)
+	nextf := ToNextFunc(ChannelNext)
+
+	var want []record
+	for i := 1; i <= 10; i++ {
+		data := fmt.Sprintf("evt%d", i)
+		if err := c.Push(Event{Data: []byte(data), Ts: uint64(i)}); err != nil {
+			t.Fatalf("Push: %s", err)
+		}
+		want = append(want, record{ts: uint64(i), data: data})
+	}
+
+	// A batch drains the events available, and then the timeout is returned
+	for i, w := range []result{
+		{ScapSuccess, want},
+		{ScapTimeout, nil},
+	} {
+		if got := nextBatch(t, oState, nextf); got.String() != w.String() {
+			t.Fatalf("call %d: got %s, want %s", i, got, w)
+		}
+	}
+
+	// After closing, the events pushed before are drained, and then EOF is returned
+	c.Push(Event{Data: []byte("last"), Ts: 11})
+	c.Close()
+	for i, w := range []result{
+		{ScapSuccess, []record{{11, "last"}}},
+		{ScapEOF, nil},
+		{ScapEOF, nil},
+	} {
+		if got := nextBatch(t, oState, nextf); got.String() != w.String() {
+			t.Fatalf("call %d after Close: got %s, want %s", i, got, w)
+		}
+	}
+}
+
+func TestChannelNextContext(t *testing.T) {
+	c := NewChannelSource(4)
+	c.Push(Event{Data: []byte("evt"), Ts: 1})
+	oState := NewStateContainer()
+	defer Free(oState)
+
+	SetContextValue(oState, c)
+	if evt, err := ChannelNext(nil, oState); err != nil || string(evt.Data) != "evt" {
+		t.Fatalf("ChannelNext: got (%q, %v), want evt", evt.Data, err)
+	}
+
+	// Any other context is an error, including a raw pointer to the source
+	for _, ctx := range []interface{}{nil, "notasource", unsafe.Pointer(c)} {
+		SetContextValue(oState, ctx)
+		if _, err := ChannelNext(nil, oState); ErrorCode(err) != ScapFailure {
+			t.Errorf("ChannelNext with context %v: got %v, want a failure", ctx, err)
+		}
+	}
+}
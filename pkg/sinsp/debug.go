@@ -0,0 +1,13 @@
+//go:build sinsp_debug
+// +build sinsp_debug
+
+package sinsp
+
+import "runtime/debug"
+
+// debugMode is true when the SDK is built with the sinsp_debug tag.
+const debugMode = true
+
+func debugStack() []byte {
+	return debug.Stack()
+}
@@ -0,0 +1,114 @@
+//go:build sinsp_debug
+// +build sinsp_debug
+
+package sinsp
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"testing"
+	"unsafe"
+)
+
+// checkPanic checks that f panics with a message containing all of want.
+func checkPanic(t *testing.T, f func(), want ...string) {
+	t.Helper()
+	defer func() {
+		t.Helper()
+		r := recover()
+		msg, _ := r.(string)
+		if r == nil {
+			t.Fatalf("no panic, want %q", want)
+		}
+		for _, w := range want {
+			if !strings.Contains(msg, w) {
+				t.Fatalf("got panic %q, want %q", msg, w)
+			}
+		}
+	}()
+	f()
+}
+
+func TestDebugCorruptedHeader(t *testing.T) {
+	pState := NewStateContainer()
+	defer Free(pState)
+
+	// The magic value is the first member of the state container
+	magic := (*uint32)(pState)
+	*magic = 0xdeadbeef
+	checkPanic(t, func() { BufferLen(pState) },
+		fmt.Sprintf("state container %p has a corrupted header (0xdeadbeef), allocated at:", pState),
+		"TestDebugCorruptedHeader")
+	checkPanic(t, func() { Free(pState) }, "corrupted header")
+
+	*magic = stateMagic
+	if n := BufferLen(pState); n != 0 {
+		t.Fatalf("got a buffer of %d bytes", n)
+	}
+}
+
+func TestDebugUseAfterFree(t *testing.T) {
+	pState := NewStateContainer()
+	Free(pState)
+
+	if magic := *(*uint32)(pState); magic != stateFreedMagic {
+		t.Fatalf("got magic %#x after Free, want %#x", magic, stateFreedMagic)
+	}
+	checkPanic(t, func() { SetContextValue(pState, 1) },
+		fmt.Sprintf("state container %p used after Free(), freed at:", pState), "TestDebugUseAfterFree")
+	checkPanic(t, func() { Free(pState) },
+		fmt.Sprintf("state container %p freed twice, first freed at:", pState), "TestDebugUseAfterFree")
+}
+
+func TestDebugUnknownState(t *testing.T) {
+	var notState [64]byte
+	p := unsafe.Pointer(&notState)
+	checkPanic(t, func() { Buffer(p) }, fmt.Sprintf("%p is not a state container created with NewStateContainer()", p))
+}
+
+func TestDebugReport(t *testing.T) {
+	live := LiveStates()
+	pState := NewStateContainer()
+	if err := MakeBuffer(pState, 16); err != nil {
+		t.Fatalf("MakeBuffer: %s", err)
+	}
+	SetContextValue(pState, "ctx")
+	h := NewHandle(42)
+	if n := LiveStates(); n != live+1 {
+		t.Fatalf("got %d live states, want %d", n, live+1)
+	}
+
+	r := DebugReport()
+	for _, want := range []string{
+		fmt.Sprintf("state container %p (buffer 16 bytes, result buffer 0 bytes, ring 0 bytes, context string) allocated at:", pState),
+		fmt.Sprintf("handle %d (int) created at:", h),
+		"TestDebugReport",
+	} {
+		if !strings.Contains(r, want) {
+			t.Fatalf("got report:\n%s\nwant %q", r, want)
+		}
+	}
+
+	// The leaks are logged by ReportLeakedHandles
+	var out bytes.Buffer
+	log.SetOutput(&out)
+	ReportLeakedHandles()
+	log.SetOutput(os.Stderr)
+	if !strings.Contains(out.String(), "sinsp: ") || !strings.Contains(out.String(), fmt.Sprintf("handle %d (int)", h)) {
+		t.Fatalf("got log:\n%s\nwant the leaked handle", out.String())
+	}
+
+	// Freed state containers and deleted handles are not reported
+	Free(pState)
+	h.Delete()
+	r = DebugReport()
+	if strings.Contains(r, fmt.Sprintf("state container %p", pState)) || strings.Contains(r, fmt.Sprintf("handle %d ", h)) {
+		t.Fatalf("got report:\n%s\nwith released memory", r)
+	}
+	if n := LiveStates(); n != live {
+		t.Fatalf("got %d live states, want %d", n, live)
+	}
+}
@@ -0,0 +1,149 @@
+package sinsp
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"unsafe"
+)
+
+// Magic values stored at the beginning of every state container,
+// validated by its accessors when built with the sinsp_debug tag.
+const (
+	stateMagic      uint32 = 0x53494e53 // "SINS"
+	stateFreedMagic uint32 = 0x46524545 // "FREE"
+)
+
+// debugState tracks a state container when built with the sinsp_debug tag.
+type debugState struct {
+	allocStack []byte
+	freeStack  []byte
+	// why Free() did not release the state container, if it was called
+	leak string
+}
+
+var (
+	debugStatesMu sync.Mutex
+	debugStates   = map[unsafe.Pointer]*debugState{}
+)
+
+// debugNewState records the allocation of the state container p.
+func debugNewState(p unsafe.Pointer) {
+	debugStatesMu.Lock()
+	defer debugStatesMu.Unlock()
+	debugStates[p] = &debugState{allocStack: debugStack()}
+}
+
+// debugCheckState panics if p is not a live state container, or if its magic header is corrupted.
+func debugCheckState(p unsafe.Pointer, magic func() uint32) {
+	debugStatesMu.Lock()
+	s, ok := debugStates[p]
+	debugStatesMu.Unlock()
+
+	switch {
+	case !ok:
+		panic(fmt.Sprintf("sinsp: %p is not a state container created with NewStateContainer()", p))
+	case s.freeStack != nil:
+		panic(fmt.Sprintf("sinsp: state container %p used after Free(), freed at:\n%s", p, s.freeStack))
+	case magic() != stateMagic:
+		panic(fmt.Sprintf("sinsp: state container %p has a corrupted header (%#x), allocated at:\n%s", p, magic(), s.allocStack))
+	}
+}
+
+// debugCheckFree panics if the state container p cannot be freed,
+// reporting where it was first freed if it is freed twice.
+func debugCheckFree(p unsafe.Pointer, magic func() uint32) {
+	debugStatesMu.Lock()
+	s, ok := debugStates[p]
+	debugStatesMu.Unlock()
+
+	if ok && s.freeStack != nil {
+		panic(fmt.Sprintf("sinsp: state container %p freed twice, first freed at:\n%s", p, s.freeStack))
+	}
+	debugCheckState(p, magic)
+}
+
+// debugLeakState records that Free() did not release the state container p, and why.
+func debugLeakState(p unsafe.Pointer, reason string) {
+	debugStatesMu.Lock()
+	defer debugStatesMu.Unlock()
+	debugStates[p].leak = reason
+}
+
+// debugFreeState records that the state container p has been freed.
+func debugFreeState(p unsafe.Pointer) {
+	debugStatesMu.Lock()
+	defer debugStatesMu.Unlock()
+	debugStates[p].freeStack = debugStack()
+}
+
+// LiveStates returns the number of state containers not yet freed, when built
+// with the sinsp_debug tag. Otherwise, it returns zero.
+func LiveStates() int {
+	debugStatesMu.Lock()
+	defer debugStatesMu.Unlock()
+	n := 0
+	for _, s := range debugStates {
+		if s.freeStack == nil {
+			n++
+		}
+	}
+	return n
+}
+
+// DebugReport returns a description of the state containers not yet freed, including
+// their allocation stacks and the size of their buffers, and of the handles not yet deleted.
+// It returns an empty string if there is none.
+//
+// State containers are only tracked when built with the sinsp_debug tag, in which case
+// every state container accessor also panics when used on a freed state container, and
+// Free() panics when a state container is freed twice. To make that possible, the memory
+// of the state containers is never released in debug builds.
+//
+// Intended usage in integration tests, after closing and destroying the plugin:
+//
+//     if r := sinsp.DebugReport(); r != "" {
+//     	t.Fatal(r)
+//     }
+//
+func DebugReport() string {
+	var b strings.Builder
+
+	debugStatesMu.Lock()
+	var live []unsafe.Pointer
+	for p, s := range debugStates {
+		if s.freeStack == nil {
+			live = append(live, p)
+		}
+	}
+	sort.Slice(live, func(i, j int) bool { return uintptr(live[i]) < uintptr(live[j]) })
+	stacks := make([][]byte, len(live))
+	leaks := make([]string, len(live))
+	for i, p := range live {
+		stacks[i] = debugStates[p].allocStack
+		leaks[i] = debugStates[p].leak
+	}
+	debugStatesMu.Unlock()
+
+	if len(live) > 0 {
+		fmt.Fprintf(&b, "%d state containers not freed", len(live))
+		for i, p := range live {
+			bufLen, resBufLen, ringLen, ctx := stateInfo(p)
+			fmt.Fprintf(&b, "\nstate container %p (buffer %d bytes, result buffer %d bytes, ring %d bytes, context %T) allocated at:\n%s",
+				p, bufLen, resBufLen, ringLen, ctx, stacks[i])
+			if leaks[i] != "" {
+				fmt.Fprintf(&b, "\nFree() failed: %s", leaks[i])
+			}
+		}
+	}
+
+	if err := CheckHandles(); err != nil {
+		if b.Len() > 0 {
+			b.WriteString("\n")
+		}
+		b.WriteString(err.Error())
+	}
+
+	return b.String()
+}
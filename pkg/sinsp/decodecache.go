@@ -0,0 +1,141 @@
+package sinsp
+
+import (
+	"bytes"
+	"container/list"
+	"sync"
+)
+
+// DefaultDecodeCacheSize is the number of events whose decoded payload is kept
+// by the decode cache created for plugins implementing Decoder.
+const DefaultDecodeCacheSize = 16
+
+// EventDecodeFunc decodes the payload of an event into a value shared by all
+// the fields extracted from that event.
+type EventDecodeFunc func(data []byte) (interface{}, error)
+
+// Decoder is optionally implemented by plugins exposing fields, so that the payload
+// of each event is decoded once for all the fields extracted from it. The decoded
+// value is returned by ExtractRequest.Decoded().
+type Decoder interface {
+	Decode(data []byte) (interface{}, error)
+}
+
+type decodeEntry struct {
+	evtnum uint64
+	data   []byte
+	value  interface{}
+	err    error
+}
+
+// DecodeCache memoizes the result of an EventDecodeFunc by event number, keeping
+// the results of the most recently used events up to a fixed number. Decoding
+// errors are cached as well. It is safe for concurrent use, so that the same cache
+// can serve both the synchronous and the asynchronous extraction paths.
+//
+// Results are keyed by event number. Since the event numbers start over with every
+// capture, a cached result is only used if the event payload is the same as the one
+// it was decoded from, otherwise the payload is decoded again.
+type DecodeCache struct {
+	decode EventDecodeFunc
+	size   int
+
+	mu      sync.Mutex
+	lru     *list.List // of *decodeEntry, most recently used first
+	entries map[uint64]*list.Element
+}
+
+// NewDecodeCache returns a DecodeCache holding the results of decode for up to size events.
+// A size lower than 1 is treated as 1.
+func NewDecodeCache(size int, decode EventDecodeFunc) *DecodeCache {
+	if size < 1 {
+		size = 1
+	}
+	return &DecodeCache{
+		decode:  decode,
+		size:    size,
+		lru:     list.New(),
+		entries: make(map[uint64]*list.Element, size),
+	}
+}
+
+// Get returns the decoded payload of the event evtnum, calling the decode function
+// on data only if the result is not in the cache already. The cache keeps a reference
+// to data, which must not be modified afterwards.
+//
+// A cached result is only used if data is the same as the payload it was decoded from,
+// so every hit compares the two payloads byte by byte, which is linear in their size
+// but much cheaper than decoding them again. The decode function is called without
+// holding the lock of the cache, so that a slow decode does not block the extractions
+// from other events; concurrent misses on the same event may then decode it more than
+// once, in which case the last result is kept.
+func (c *DecodeCache) Get(evtnum uint64, data []byte) (interface{}, error) {
+	if entry := c.lookup(evtnum, data); entry != nil {
+		return entry.value, entry.err
+	}
+
+	value, err := c.decode(data)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if e, ok := c.entries[evtnum]; ok {
+		c.lru.Remove(e)
+	}
+	c.entries[evtnum] = c.lru.PushFront(&decodeEntry{evtnum: evtnum, data: data, value: value, err: err})
+	if c.lru.Len() > c.size {
+		oldest := c.lru.Back()
+		c.lru.Remove(oldest)
+		delete(c.entries, oldest.Value.(*decodeEntry).evtnum)
+	}
+	return value, err
+}
+
+// lookup returns the cached result of the event evtnum, or nil if there is none
+// or if it was decoded from a payload other than data.
+func (c *DecodeCache) lookup(evtnum uint64, data []byte) *decodeEntry {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e, ok := c.entries[evtnum]
+	if !ok {
+		return nil
+	}
+	entry := e.Value.(*decodeEntry)
+	if !bytes.Equal(entry.data, data) {
+		// The event changed, the result is stale
+		c.lru.Remove(e)
+		delete(c.entries, evtnum)
+		return nil
+	}
+	c.lru.MoveToFront(e)
+	return entry
+}
+
+// Invalidate removes the result of the event evtnum from the cache, if any.
+func (c *DecodeCache) Invalidate(evtnum uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if e, ok := c.entries[evtnum]; ok {
+		c.lru.Remove(e)
+		delete(c.entries, evtnum)
+	}
+}
+
+// Reset removes all the results from the cache.
+func (c *DecodeCache) Reset() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.lru.Init()
+	c.entries = make(map[uint64]*list.Element, c.size)
+}
+
+// Len returns the number of events whose result is in the cache.
+func (c *DecodeCache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.lru.Len()
+}
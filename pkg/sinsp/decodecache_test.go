@@ -0,0 +1,161 @@
+package sinsp
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+// countingDecoder decodes payloads into strings, counting the decodes.
+type countingDecoder struct {
+	decodes int
+}
+
+func (d *countingDecoder) decode(data []byte) (interface{}, error) {
+	d.decodes++
+	return fmt.Sprintf("%s#%d", data, d.decodes), nil
+}
+
+// checkGet calls c.Get() and compares the value returned and the number of decodes so far.
+func checkGet(t *testing.T, c *DecodeCache, d *countingDecoder, evtnum uint64, data string, want string, decodes int) {
+	t.Helper()
+	v, err := c.Get(evtnum, []byte(data))
+	if v != want || err != nil || d.decodes != decodes {
+		t.Fatalf("event %d (%s): got (%v, %v) after %d decodes, want (%s, nil) after %d", evtnum, data, v, err, d.decodes, want, decodes)
+	}
+}
+
+func TestDecodeCacheLRU(t *testing.T) {
+	d := &countingDecoder{}
+	c := NewDecodeCache(2, d.decode)
+
+	checkGet(t, c, d, 1, "a", "a#1", 1)
+	checkGet(t, c, d, 2, "b", "b#2", 2)
+	checkGet(t, c, d, 1, "a", "a#1", 2)
+
+	// Event 2 is now the least recently used one
+	checkGet(t, c, d, 3, "c", "c#3", 3)
+	if c.Len() != 2 {
+		t.Fatalf("got %d entries, want 2", c.Len())
+	}
+	checkGet(t, c, d, 1, "a", "a#1", 3)
+	checkGet(t, c, d, 3, "c", "c#3", 3)
+	checkGet(t, c, d, 2, "b", "b#4", 4)
+
+	// Which evicted event 1
+	checkGet(t, c, d, 3, "c", "c#3", 4)
+	checkGet(t, c, d, 1, "a", "a#5", 5)
+}
+
+func TestDecodeCacheMinSize(t *testing.T) {
+	d := &countingDecoder{}
+	c := NewDecodeCache(0, d.decode)
+
+	checkGet(t, c, d, 1, "a", "a#1", 1)
+	checkGet(t, c, d, 1, "a", "a#1", 1)
+	checkGet(t, c, d, 2, "b", "b#2", 2)
+	checkGet(t, c, d, 1, "a", "a#3", 3)
+}
+
+func TestDecodeCacheEvtnumReuse(t *testing.T) {
+	d := &countingDecoder{}
+	c := NewDecodeCache(DefaultDecodeCacheSize, d.decode)
+
+	checkGet(t, c, d, 1, "first capture", "first capture#1", 1)
+
+	// A new capture reuses the event number with another payload
+	checkGet(t, c, d, 1, "second capture", "second capture#2", 2)
+	if c.Len() != 1 {
+		t.Fatalf("got %d entries, want 1", c.Len())
+	}
+
+	// Payloads are compared by content: a copy of the same payload hits,
+	// while a payload differing by one byte, or only by its length, does not
+	checkGet(t, c, d, 1, string([]byte("second capture")), "second capture#2", 2)
+	checkGet(t, c, d, 1, "second captura", "second captura#3", 3)
+	checkGet(t, c, d, 1, "second captur", "second captur#4", 4)
+}
+
+func TestDecodeCacheErrors(t *testing.T) {
+	decodes := 0
+	errDecode := errors.New("invalid payload")
+	c := NewDecodeCache(DefaultDecodeCacheSize, func(data []byte) (interface{}, error) {
+		decodes++
+		return nil, errDecode
+	})
+
+	for i := 0; i < 2; i++ {
+		if v, err := c.Get(1, []byte("data")); v != nil || err != errDecode {
+			t.Fatalf("got (%v, %v), want %v", v, err, errDecode)
+		}
+	}
+	if decodes != 1 {
+		t.Fatalf("got %d decodes, want the error cached", decodes)
+	}
+}
+
+func TestDecodeCacheInvalidate(t *testing.T) {
+	d := &countingDecoder{}
+	c := NewDecodeCache(DefaultDecodeCacheSize, d.decode)
+
+	checkGet(t, c, d, 1, "a", "a#1", 1)
+	checkGet(t, c, d, 2, "b", "b#2", 2)
+
+	c.Invalidate(1)
+	c.Invalidate(3)
+	checkGet(t, c, d, 2, "b", "b#2", 2)
+	checkGet(t, c, d, 1, "a", "a#3", 3)
+
+	c.Reset()
+	if c.Len() != 0 {
+		t.Fatalf("got %d entries after Reset", c.Len())
+	}
+	checkGet(t, c, d, 1, "a", "a#4", 4)
+}
+
+func TestDecodeCacheDecodeOutsideLock(t *testing.T) {
+	started := make(chan struct{})
+	release := make(chan struct{})
+	c := NewDecodeCache(DefaultDecodeCacheSize, func(data []byte) (interface{}, error) {
+		if string(data) == "slow" {
+			close(started)
+			<-release
+		}
+		return string(data), nil
+	})
+
+	done := make(chan interface{})
+	go func() {
+		v, _ := c.Get(1, []byte("slow"))
+		done <- v
+	}()
+	<-started
+
+	// The other events are served while the slow one is being decoded
+	served := make(chan interface{})
+	go func() {
+		v, _ := c.Get(2, []byte("fast"))
+		served <- v
+	}()
+	select {
+	case v := <-served:
+		if v != "fast" {
+			t.Fatalf("got %v, want fast", v)
+		}
+	case <-time.After(5 * time.Second):
+		close(release)
+		t.Fatal("Get blocked by the decoding of another event")
+	}
+	if c.Len() != 1 {
+		t.Fatalf("got %d entries while decoding, want 1", c.Len())
+	}
+
+	close(release)
+	if v := <-done; v != "slow" {
+		t.Fatalf("got %v, want slow", v)
+	}
+	if c.Len() != 2 {
+		t.Fatalf("got %d entries, want 2", c.Len())
+	}
+}
@@ -0,0 +1,34 @@
+package sinsp
+
+import "unsafe"
+
+// Entrypoints holds the Go functions implementing the plugin API, that are
+// otherwise called by libsinsp through their exported C symbols. C strings are
+// passed as *byte pointers to NULL terminated strings.
+//
+// It is returned by source.Entrypoints() and extractor.Entrypoints(), and used
+// by the sinsptest package to drive a plugin in-process.
+//
+// Optional entry points may be left nil. ClearLastError is not part of the plugin API:
+// it clears the error reported by GetLastError, so that the errors raised by the
+// extraction functions, which have no return code, can be told apart.
+type Entrypoints struct {
+	GetType               func() uint32
+	GetID                 func() uint32
+	GetName               func() *byte
+	GetDescription        func() *byte
+	GetRequiredAPIVersion func() *byte
+	GetFields             func() *byte
+	GetLastError          func(pState unsafe.Pointer) *byte
+	ClearLastError        func(pState unsafe.Pointer)
+	Init                  func(config *byte, rc *int32) unsafe.Pointer
+	Destroy               func(pState unsafe.Pointer)
+	Open                  func(pState unsafe.Pointer, params *byte, rc *int32) unsafe.Pointer
+	Close                 func(pState unsafe.Pointer, oState unsafe.Pointer)
+	Next                  func(pState unsafe.Pointer, oState unsafe.Pointer, data **byte, datalen *uint32, ts *uint64) int32
+	NextBatch             func(pState unsafe.Pointer, oState unsafe.Pointer, data **byte, datalen *uint32) int32
+	EventToString         func(data *byte, datalen uint32) *byte
+	ExtractStr            PluginExtractStrFunc
+	ExtractU64            PluginExtractU64Func
+	ExtractBuf            PluginExtractBufFunc
+}
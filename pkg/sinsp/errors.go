@@ -0,0 +1,131 @@
+package sinsp
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"unsafe"
+)
+
+// Error is an error carrying a SCAP return code, with an optional message
+// and an optional wrapped error.
+//
+// Two Errors match with errors.Is() when they have the same code, so that
+// any Error with code ScapEOF matches ErrEOF, regardless of its message.
+type Error struct {
+	Code int32
+	Msg  string
+	Err  error
+}
+
+// Sentinel errors for the SCAP return codes, to be used with errors.Is().
+var (
+	ErrFailure         = &Error{Code: ScapFailure}
+	ErrTimeout         = &Error{Code: ScapTimeout}
+	ErrIllegalInput    = &Error{Code: ScapIllegalInput}
+	ErrNotFound        = &Error{Code: ScapNotFound}
+	ErrInputTooSmall   = &Error{Code: ScapInputTooSmall}
+	ErrEOF             = &Error{Code: ScapEOF}
+	ErrUnexpectedBlock = &Error{Code: ScapUnexpectedBlock}
+	ErrVersionMismatch = &Error{Code: ScapVersionMismatch}
+	ErrNotSupported    = &Error{Code: ScapNotSupported}
+)
+
+var codeStrings = map[int32]string{
+	ScapSuccess:         "success",
+	ScapFailure:         "failure",
+	ScapTimeout:         "timeout",
+	ScapIllegalInput:    "illegal input",
+	ScapNotFound:        "not found",
+	ScapInputTooSmall:   "input too small",
+	ScapEOF:             "eof",
+	ScapUnexpectedBlock: "unexpected block",
+	ScapVersionMismatch: "version mismatch",
+	ScapNotSupported:    "not supported",
+}
+
+// CodeString returns a short description of the SCAP return code.
+func CodeString(code int32) string {
+	if s, ok := codeStrings[code]; ok {
+		return s
+	}
+	return fmt.Sprintf("unknown code %d", code)
+}
+
+// NewError returns an Error with the given code and a message formatted
+// according to format. If the format verb %w is used, the corresponding
+// error is wrapped, as with fmt.Errorf().
+func NewError(code int32, format string, a ...interface{}) error {
+	err := fmt.Errorf(format, a...)
+	return &Error{Code: code, Msg: err.Error(), Err: errors.Unwrap(err)}
+}
+
+func (e *Error) Error() string {
+	switch {
+	case e.Msg != "":
+		return e.Msg
+	case e.Err != nil:
+		return e.Err.Error()
+	default:
+		return CodeString(e.Code)
+	}
+}
+
+// Unwrap returns the error wrapped by e, if any.
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// Is reports whether target is an Error with the same code as e.
+func (e *Error) Is(target error) bool {
+	t, ok := target.(*Error)
+	return ok && t.Code == e.Code
+}
+
+// ErrorCode returns the SCAP return code that corresponds to err:
+// ScapSuccess for nil, the code of the first Error found in the chain of err,
+// ScapEOF for io.EOF and ScapFailure for anything else.
+func ErrorCode(err error) int32 {
+	if err == nil {
+		return ScapSuccess
+	}
+
+	var e *Error
+	if errors.As(err, &e) {
+		return e.Code
+	}
+	if errors.Is(err, io.EOF) {
+		return ScapEOF
+	}
+	return ScapFailure
+}
+
+// CodeError returns the error corresponding to a SCAP return code:
+// nil for ScapSuccess and an Error with that code otherwise.
+func CodeError(code int32) error {
+	if code == ScapSuccess {
+		return nil
+	}
+	return &Error{Code: code}
+}
+
+// HandleError converts an error returned by a plugin callback into the SCAP return code
+// to be returned at the C boundary, and stores it as the last error of p (see SetLastError()).
+// Timeouts are not stored, since they are not failures.
+//
+// Intended usage as in the following example:
+//
+//     //export plugin_open
+//     func plugin_open(pState unsafe.Pointer, params *C.char, rc *int32) unsafe.Pointer {
+//     	oState, err := open(pState, C.GoString(params))
+//     	*rc = sinsp.HandleError(pState, err)
+//     	return oState
+//     }
+//
+func HandleError(p unsafe.Pointer, err error) int32 {
+	code := ErrorCode(err)
+	if code != ScapSuccess && code != ScapTimeout {
+		SetLastError(p, err)
+	}
+	return code
+}
@@ -0,0 +1,113 @@
+package sinsp
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"testing"
+)
+
+func TestErrorString(t *testing.T) {
+	tests := []struct {
+		err  *Error
+		want string
+	}{
+		{&Error{Code: ScapTimeout}, "timeout"},
+		{&Error{Code: 42}, "unknown code 42"},
+		{&Error{Code: ScapFailure, Err: io.ErrUnexpectedEOF}, "unexpected EOF"},
+		{&Error{Code: ScapFailure, Msg: "bad", Err: io.ErrUnexpectedEOF}, "bad"},
+	}
+	for _, tt := range tests {
+		if got := tt.err.Error(); got != tt.want {
+			t.Errorf("%#v: got %q, want %q", tt.err, got, tt.want)
+		}
+	}
+}
+
+func TestNewError(t *testing.T) {
+	err := NewError(ScapNotFound, "no %s: %w", "file", io.ErrUnexpectedEOF)
+	if err.Error() != "no file: unexpected EOF" {
+		t.Errorf("got message %q", err.Error())
+	}
+	if !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Errorf("the error given with %%w is not wrapped")
+	}
+	if !errors.Is(err, ErrNotFound) || errors.Is(err, ErrFailure) {
+		t.Errorf("the error does not match its code only")
+	}
+
+	if err := NewError(ScapFailure, "plain"); errors.Unwrap(err) != nil {
+		t.Errorf("got wrapped error %v without %%w", errors.Unwrap(err))
+	}
+}
+
+func TestErrorIs(t *testing.T) {
+	err := fmt.Errorf("reading: %w", NewError(ScapEOF, "end of capture"))
+	if !errors.Is(err, ErrEOF) {
+		t.Errorf("an Error with code ScapEOF does not match ErrEOF")
+	}
+	for _, target := range []error{ErrFailure, ErrTimeout, io.EOF} {
+		if errors.Is(err, target) {
+			t.Errorf("an Error with code ScapEOF matches %v", target)
+		}
+	}
+}
+
+func TestErrorCode(t *testing.T) {
+	tests := []struct {
+		err  error
+		want int32
+	}{
+		{nil, ScapSuccess},
+		{ErrTimeout, ScapTimeout},
+		{NewError(ScapNotSupported, "no"), ScapNotSupported},
+		{fmt.Errorf("wrapped: %w", ErrIllegalInput), ScapIllegalInput},
+		{io.EOF, ScapEOF},
+		{fmt.Errorf("wrapped: %w", io.EOF), ScapEOF},
+		// The code of an Error prevails over the errors it wraps
+		{NewError(ScapFailure, "failed: %w", io.EOF), ScapFailure},
+		{errors.New("plain"), ScapFailure},
+	}
+	for _, tt := range tests {
+		if got := ErrorCode(tt.err); got != tt.want {
+			t.Errorf("%v: got code %d, want %d", tt.err, got, tt.want)
+		}
+	}
+}
+
+func TestCodeError(t *testing.T) {
+	if err := CodeError(ScapSuccess); err != nil {
+		t.Errorf("ScapSuccess: got %v, want nil", err)
+	}
+	for code := range codeStrings {
+		if code == ScapSuccess {
+			continue
+		}
+		if err := CodeError(code); ErrorCode(err) != code || err.Error() != CodeString(code) {
+			t.Errorf("code %d: got %v with code %d", code, err, ErrorCode(err))
+		}
+	}
+}
+
+func TestHandleError(t *testing.T) {
+	pState := NewStateContainer()
+	defer Free(pState)
+
+	handle := func(err error, code int32, lastErr string) {
+		t.Helper()
+		rc := HandleError(pState, err)
+		got := ""
+		if err := LastError(pState); err != nil {
+			got = err.Error()
+		}
+		if rc != code || got != lastErr {
+			t.Fatalf("%v: got (%d, %q), want (%d, %q)", err, rc, got, code, lastErr)
+		}
+	}
+
+	handle(nil, ScapSuccess, "")
+	handle(errors.New("failed"), ScapFailure, "failed")
+	// Timeouts are not failures, and do not replace the last error
+	handle(ErrTimeout, ScapTimeout, "failed")
+	handle(io.EOF, ScapEOF, "EOF")
+}
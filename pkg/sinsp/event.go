@@ -0,0 +1,108 @@
+package sinsp
+
+import (
+	"errors"
+	"time"
+	"unsafe"
+)
+
+// ErrEmptyEvent is returned when a source produces an event without payload.
+var ErrEmptyEvent = errors.New("event with empty payload")
+
+// Event is a single event produced by a source plugin.
+type Event struct {
+	// Data is the event payload. It must not be empty.
+	Data []byte
+	// Ts is the event timestamp, in nanoseconds since the epoch.
+	// If zero, the time the event is handed to sinsp is used.
+	Ts uint64
+	// Metadata holds optional information about the event. It is not
+	// passed to sinsp, but it is available to Go code driving the plugin
+	// in process, such as the sinsptest package, through EventMetadata().
+	Metadata map[string]string
+}
+
+// NextEventFunc is a variant of NextFunc returning the next event, or an error
+// such as ErrTimeout or ErrEOF if there is none.
+type NextEventFunc func(plgState unsafe.Pointer, openState unsafe.Pointer) (Event, error)
+
+// ToNextFunc returns a NextFunc calling f. Events with a zero timestamp get the
+// current wall-clock time, while events with an empty payload are rejected
+// with ErrEmptyEvent. Errors returned by f are converted with HandleError().
+// The event metadata is kept by Next() and NextBatch(), see EventMetadata().
+func ToNextFunc(f NextEventFunc) NextFunc {
+	return func(plgState unsafe.Pointer, openState unsafe.Pointer, data *[]byte, ts *uint64) int32 {
+		evt, err := f(plgState, openState)
+		if err == nil {
+			err = evt.normalize()
+		}
+		if bCtx := batchCtxOf(openState); bCtx != nil {
+			bCtx.nextMetadata = evt.Metadata
+		}
+		if err != nil {
+			return HandleError(plgState, err)
+		}
+
+		*data = evt.Data
+		*ts = evt.Ts
+		return ScapSuccess
+	}
+}
+
+// normalize checks e and sets its default values.
+func (e *Event) normalize() error {
+	if len(e.Data) == 0 {
+		return ErrEmptyEvent
+	}
+	if e.Ts == 0 {
+		e.Ts = uint64(time.Now().UnixNano())
+	}
+	return nil
+}
+
+// Next is an helper function to be used within plugin_next. It copies the event
+// returned by nextf into the buffer of openState and returns it. An event that
+// does not fit into the buffer, even after growing it according to SetBufferGrowth(),
+// is reported as ScapFailure instead of being truncated.
+//
+// A panic in nextf is recovered and reported as ScapFailure, with the
+// panic stored as the last error of plgState.
+func Next(plgState unsafe.Pointer, openState unsafe.Pointer, data **byte, datalen *uint32, ts *uint64, nextf NextFunc) int32 {
+	var nextData []byte
+
+	bCtx := batchCtxOf(openState)
+	if bCtx != nil {
+		bCtx.metadata = nil
+		bCtx.nextMetadata = nil
+	}
+
+	res := callNext(nextf, plgState, openState, &nextData, ts)
+	if res == ScapSuccess {
+		n, err := WriteBuffer(openState, nextData)
+		if err != nil {
+			return HandleError(plgState, err)
+		}
+		*datalen = n
+		*data = Buffer(openState)
+		if bCtx != nil {
+			bCtx.metadata = []map[string]string{bCtx.nextMetadata}
+		}
+	}
+
+	return res
+}
+
+// EventMetadata returns the metadata of the events returned by the last Next() or NextBatch()
+// call on openState, in the same order, assuming openState is a state container created with
+// NewStateContainer(). An event split by NextBatch() has its metadata repeated for each record.
+// The metadata is the one set in Event.Metadata by the NextEventFunc given to ToNextFunc(),
+// and is nil for events without metadata or produced by other kinds of NextFunc.
+//
+// Since the metadata is not passed to sinsp, EventMetadata is meant for the Go code
+// driving the plugin in process, such as the sinsptest package.
+func EventMetadata(openState unsafe.Pointer) []map[string]string {
+	if bCtx := batchCtxOf(openState); bCtx != nil {
+		return bCtx.metadata
+	}
+	return nil
+}
@@ -0,0 +1,54 @@
+package sinsp
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"unsafe"
+)
+
+func TestEventMetadata(t *testing.T) {
+	evts := []Event{
+		{Data: []byte("first"), Ts: 1, Metadata: map[string]string{"n": "1"}},
+		{Data: []byte(strings.Repeat("x", 30)), Ts: 2, Metadata: map[string]string{"n": "2"}},
+		{Data: []byte("third"), Ts: 3},
+	}
+	nextf := ToNextFunc(func(plgState unsafe.Pointer, openState unsafe.Pointer) (Event, error) {
+		if len(evts) == 0 {
+			return Event{}, ErrEOF
+		}
+		evt := evts[0]
+		evts = evts[1:]
+		return evt, nil
+	})
+	// Room for one record with up to 20 bytes of payload, so that the second event is split
+	oState := newBatchState(t, 32, BatchOptions{Oversize: OversizeSplit})
+
+	want := [][]map[string]string{
+		{{"n": "1"}},
+		{{"n": "2"}},
+		{{"n": "2"}},
+		{nil},
+		nil,
+	}
+	for i, w := range want {
+		var data *byte
+		var datalen uint32
+		NextBatch(nil, oState, &data, &datalen, nextf)
+		if got := EventMetadata(oState); fmt.Sprint(got) != fmt.Sprint(w) {
+			t.Fatalf("call %d: got %v, want %v", i, got, w)
+		}
+	}
+
+	// Next() keeps the metadata of its single event
+	evts = []Event{{Data: []byte("next"), Metadata: map[string]string{"n": "4"}}}
+	var data *byte
+	var datalen uint32
+	var ts uint64
+	if rc := Next(nil, oState, &data, &datalen, &ts, nextf); rc != ScapSuccess {
+		t.Fatalf("Next: got %s", CodeString(rc))
+	}
+	if got := EventMetadata(oState); fmt.Sprint(got) != "[map[n:4]]" {
+		t.Fatalf("Next: got %v", got)
+	}
+}
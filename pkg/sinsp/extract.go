@@ -0,0 +1,145 @@
+package sinsp
+
+/*
+#include <stdlib.h>
+*/
+import "C"
+import "unsafe"
+
+func goBytes(data *byte, datalen uint32) []byte {
+	if data == nil || datalen == 0 {
+		return nil
+	}
+	return C.GoBytes(unsafe.Pointer(data), C.int(datalen))
+}
+
+func goString(s *byte) string {
+	if s == nil {
+		return ""
+	}
+	return C.GoString((*C.char)(unsafe.Pointer(s)))
+}
+
+// ExtractStr serves a plugin_extract_str() call using the field registry bound to pluginState
+// with SetFields(). It satisfies PluginExtractStrFunc.
+//
+// It returns nil if the field is not present in the event or cannot be extracted,
+// in which case the error is stored as the last error of pluginState. Since
+// plugin_extract_str has no return code, extraction errors, including malformed
+// arguments, are only visible to the caller through plugin_get_last_error.
+// Panics are recovered and handled the same way.
+// The returned string is stored with ResultStr(), so it is owned by pluginState
+// and valid until the next extraction on it.
+func ExtractStr(pluginState unsafe.Pointer, evtnum uint64, id uint32, arg *byte, data *byte, datalen uint32) (res *byte) {
+	defer Recover(pluginState, nil)
+	res, err := extractStr(pluginState, evtnum, id, arg, data, datalen)
+	if err != nil {
+		SetLastError(pluginState, err)
+	}
+	return res
+}
+
+// extractStr is like ExtractStr(), but returns the extraction errors rather than storing them.
+func extractStr(pluginState unsafe.Pointer, evtnum uint64, id uint32, arg *byte, data *byte, datalen uint32) (*byte, error) {
+	if err := CheckEnabled(pluginState); err != nil {
+		return nil, err
+	}
+
+	r := Fields(pluginState)
+	if r == nil {
+		return nil, nil
+	}
+
+	value, present, err := r.extractStr(evtnum, id, goString(arg), goBytes(data, datalen), DecodeCacheOf(pluginState))
+	if err != nil || !present {
+		return nil, err
+	}
+
+	return resultStr(pluginState, value)
+}
+
+// ExtractU64 serves a plugin_extract_u64() call using the field registry bound to pluginState
+// with SetFields(). It satisfies PluginExtractU64Func.
+//
+// Besides uint64 fields, it serves all the fields whose value fits in 64 bits,
+// encoded as described in FieldRegistry.ExtractU64().
+//
+// Extraction errors and panics are stored as the last error of pluginState, and the
+// field is reported as not present. As for ExtractStr(), they are only visible to the
+// caller through plugin_get_last_error.
+func ExtractU64(pluginState unsafe.Pointer, evtnum uint64, id uint32, arg *byte, data *byte, datalen uint32, fieldPresent *uint32) (res uint64) {
+	*fieldPresent = 0
+	defer Recover(pluginState, nil)
+	res, err := extractU64(pluginState, evtnum, id, arg, data, datalen, fieldPresent)
+	if err != nil {
+		SetLastError(pluginState, err)
+	}
+	return res
+}
+
+// extractU64 is like ExtractU64(), but returns the extraction errors rather than storing them.
+func extractU64(pluginState unsafe.Pointer, evtnum uint64, id uint32, arg *byte, data *byte, datalen uint32, fieldPresent *uint32) (uint64, error) {
+	*fieldPresent = 0
+	if err := CheckEnabled(pluginState); err != nil {
+		return 0, err
+	}
+
+	r := Fields(pluginState)
+	if r == nil {
+		return 0, nil
+	}
+
+	value, present, err := r.extractU64(evtnum, id, goString(arg), goBytes(data, datalen), DecodeCacheOf(pluginState))
+	if err != nil || !present {
+		return 0, err
+	}
+
+	*fieldPresent = 1
+	return value, nil
+}
+
+// ExtractBuf serves a plugin_extract_buf() call using the field registry bound to pluginState
+// with SetFields(). It satisfies PluginExtractBufFunc.
+//
+// It serves all the fields whose value is a byte buffer, encoded as described in
+// FieldRegistry.ExtractBuf(), and stores the buffer length in reslen.
+// It returns nil if the field is not present in the event or cannot be extracted,
+// in which case the error is stored as the last error of pluginState, only visible
+// to the caller through plugin_get_last_error as for ExtractStr().
+// Panics are recovered and handled the same way.
+// The returned buffer is stored with ResultBuf(), so it is owned by pluginState
+// and valid until the next extraction on it.
+func ExtractBuf(pluginState unsafe.Pointer, evtnum uint64, id uint32, arg *byte, data *byte, datalen uint32, reslen *uint32) (res *byte) {
+	*reslen = 0
+	defer Recover(pluginState, nil)
+	res, err := extractBuf(pluginState, evtnum, id, arg, data, datalen, reslen)
+	if err != nil {
+		SetLastError(pluginState, err)
+	}
+	return res
+}
+
+// extractBuf is like ExtractBuf(), but returns the extraction errors rather than storing them.
+func extractBuf(pluginState unsafe.Pointer, evtnum uint64, id uint32, arg *byte, data *byte, datalen uint32, reslen *uint32) (*byte, error) {
+	*reslen = 0
+	if err := CheckEnabled(pluginState); err != nil {
+		return nil, err
+	}
+
+	r := Fields(pluginState)
+	if r == nil {
+		return nil, nil
+	}
+
+	value, present, err := r.extractBuf(evtnum, id, goString(arg), goBytes(data, datalen), DecodeCacheOf(pluginState))
+	if err != nil || !present {
+		return nil, err
+	}
+
+	res, err := resultBuf(pluginState, value)
+	if err != nil {
+		return nil, err
+	}
+	*reslen = uint32(len(value))
+	return res, nil
+}
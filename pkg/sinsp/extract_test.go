@@ -0,0 +1,107 @@
+package sinsp
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// cstr returns s as a NULL terminated string.
+func cstr(s string) *byte {
+	return &append([]byte(s), 0)[0]
+}
+
+func TestExtractMalformedArgument(t *testing.T) {
+	r, err := NewFieldRegistry([]Field{
+		{Name: "test.tag", Desc: "Tag", Arg: &FieldArg{Required: true, Index: true},
+			ExtractStr: func(req *ExtractRequest) (string, bool, error) {
+				return "tag", true, nil
+			}},
+	})
+	if err != nil {
+		t.Fatalf("NewFieldRegistry: %s", err)
+	}
+	pState := NewStateContainer()
+	defer Free(pState)
+	SetFields(pState, r)
+	data := []byte("data")
+
+	if res, err := extractStr(pState, 1, 0, cstr("0"), &data[0], uint32(len(data))); res == nil || err != nil {
+		t.Fatalf("valid argument: got (%v, %v)", res, err)
+	}
+
+	// The error is returned to the async path...
+	if res, err := extractStr(pState, 1, 0, cstr("abc"), &data[0], uint32(len(data))); res != nil || err == nil {
+		t.Fatalf("malformed argument: got (%v, %v), want an error", res, err)
+	}
+
+	// ...and stored as the last error by the sync one
+	if res := ExtractStr(pState, 1, 0, cstr("abc"), &data[0], uint32(len(data))); res != nil {
+		t.Fatalf("ExtractStr: got a result for a malformed argument")
+	}
+	if err := LastError(pState); err == nil {
+		t.Fatalf("ExtractStr: no last error for a malformed argument")
+	}
+}
+
+func TestExtractArguments(t *testing.T) {
+	extract := func(req *ExtractRequest) (string, bool, error) {
+		return fmt.Sprintf("%t,%d,%s", req.HasArg, req.ArgIndex, req.ArgKey), true, nil
+	}
+	r, err := NewFieldRegistry([]Field{
+		{Name: "test.plain", Desc: "No argument", ExtractStr: extract},
+		{Name: "test.tag", Desc: "Required index", Arg: &FieldArg{Required: true, Index: true}, ExtractStr: extract},
+		{Name: "test.label", Desc: "Required key", Arg: &FieldArg{Required: true, Key: true}, ExtractStr: extract},
+		{Name: "test.opt", Desc: "Optional index", Arg: &FieldArg{Index: true}, ExtractStr: extract},
+		{Name: "test.optkey", Desc: "Optional key", Arg: &FieldArg{Key: true}, ExtractStr: extract},
+	})
+	if err != nil {
+		t.Fatalf("NewFieldRegistry: %s", err)
+	}
+
+	tests := []struct {
+		id   uint32
+		arg  string
+		want string
+		err  string // empty if valid
+	}{
+		{0, "", "false,0,", ""},
+		{0, "x", "", `field test.plain: unexpected argument "x"`},
+		{1, "3", "true,3,", ""},
+		{1, "", "", "field test.tag: missing required argument"},
+		{1, "-1", "", `field test.tag: invalid index argument "-1"`},
+		{2, "HOME", "true,0,HOME", ""},
+		{2, "0", "true,0,0", ""},
+		{2, "", "", "field test.label: missing required argument"},
+		{3, "", "false,0,", ""},
+		{3, "7", "true,7,", ""},
+		{3, "x", "", `field test.opt: invalid index argument "x"`},
+		{4, "", "false,0,", ""},
+		{4, "a b", "true,0,a b", ""},
+	}
+
+	data := []byte("data")
+	for _, tt := range tests {
+		v, ok, err := r.extractStr(1, tt.id, tt.arg, data, nil)
+		switch {
+		case tt.err == "" && (err != nil || !ok || v != tt.want):
+			t.Errorf("field %d, argument %q: got (%q, %t, %v), want %q", tt.id, tt.arg, v, ok, err, tt.want)
+		case tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err)):
+			t.Errorf("field %d, argument %q: got (%q, %t, %v), want error %q", tt.id, tt.arg, v, ok, err, tt.err)
+		}
+	}
+
+	// A NULL argument, as passed by sinsp when there is none, is the same as an empty one
+	pState := NewStateContainer()
+	defer Free(pState)
+	SetFields(pState, r)
+	if res := ExtractStr(pState, 1, 1, nil, &data[0], uint32(len(data))); res != nil {
+		t.Fatalf("ExtractStr: got a result without the required argument")
+	}
+	if err := LastError(pState); err == nil || !strings.Contains(err.Error(), "missing required argument") {
+		t.Fatalf("ExtractStr: got last error %v, want a missing argument", err)
+	}
+	if res := ExtractStr(pState, 1, 3, nil, &data[0], uint32(len(data))); res == nil {
+		t.Fatalf("ExtractStr: got no result without the optional argument: %v", LastError(pState))
+	}
+}
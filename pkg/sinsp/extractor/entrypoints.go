@@ -0,0 +1,14 @@
+package extractor
+
+import (
+	"github.com/ldegio/libsinsp-plugin-sdk-go/pkg/sinsp"
+	"github.com/ldegio/libsinsp-plugin-sdk-go/pkg/sinsp/internal/exports"
+)
+
+// Entrypoints returns the entry points exported by this package, so that the
+// registered plugin can be tested in-process with the sinsptest package.
+func Entrypoints() *sinsp.Entrypoints {
+	e := &sinsp.Entrypoints{}
+	exports.Entrypoints(e)
+	return e
+}
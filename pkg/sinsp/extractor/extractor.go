@@ -0,0 +1,33 @@
+// Package extractor exports the C symbols required by a libsinsp extractor plugin
+// and dispatches them to a sinsp.ExtractorPlugin implementation.
+//
+// A plugin only needs to register its factory and provide an empty main:
+//
+//     func init() {
+//     	extractor.Register(func() sinsp.ExtractorPlugin { return &myPlugin{} })
+//     }
+//
+//     func main() {}
+//
+// Importing the async package as well enables asynchronous extraction.
+// Plugins implementing sinsp.Decoder get a decode cache bound to each plugin
+// state, so that the payload of an event is decoded once for all its fields.
+package extractor
+
+import (
+	"github.com/ldegio/libsinsp-plugin-sdk-go/pkg/sinsp"
+	"github.com/ldegio/libsinsp-plugin-sdk-go/pkg/sinsp/internal/exports"
+)
+
+// Register sets the factory used to create a new sinsp.ExtractorPlugin for every
+// plugin_init call. It must be called exactly once, typically from an init function.
+// The fields of the plugin are validated with sinsp.MarshalFields(), so their names
+// must start with the plugin name followed by a dot.
+//
+// All the symbols of an extractor plugin are common to all the plugin types, and
+// are served by a sinsp.Registration.
+func Register(f func() sinsp.ExtractorPlugin) {
+	exports.Register("extractor", func() *sinsp.Registration {
+		return sinsp.NewRegistration(sinsp.TypeExtractorPlugin, func() sinsp.Lifecycle { return f() })
+	})
+}
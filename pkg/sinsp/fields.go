@@ -1,12 +1,593 @@
 package sinsp
 
+import (
+	"fmt"
+	"math"
+	"net"
+	"strconv"
+	"time"
+)
+
 // FieldEntry represents a single field entry that an extractor plugin can expose.
 // Should be used when implementing plugin_get_fields().
+//
+// Use MarshalFields() or a FieldsBuilder to validate the entries and encode them.
+//
+// Properties is encoded as a JSON array of strings, such as ["hidden","info"], and
+// omitted when empty. Note that it used to be a single string, always encoded, so the
+// consumers of the plugin_get_fields JSON relying on that shape have to be updated.
 type FieldEntry struct {
-	Type       string `json:"type"`
-	ID         uint32 `json: "ID"`
-	Name       string `json:"name"`
-	Display    string `json:"display"`
-	Desc       string `json:"desc"`
-	Properties string `json:"properties"`
+	Type       FieldType       `json:"type"`
+	ID         uint32          `json:"ID"`
+	Name       string          `json:"name"`
+	Arg        *FieldArg       `json:"arg,omitempty"`
+	Display    string          `json:"display"`
+	Desc       string          `json:"desc"`
+	Properties []FieldProperty `json:"properties,omitempty"`
+}
+
+// FieldType is the type of a field, as reported in FieldEntry.Type.
+type FieldType string
+
+// FieldArg describes the argument taken by a field, given between square brackets
+// after the field name, as in myplugin.addr[1] or myplugin.label[app].
+// A field takes either an index or a key.
+type FieldArg struct {
+	// Required tells whether the field can only be used with an argument.
+	Required bool `json:"isRequired"`
+	// Index tells whether the argument is an index, that is a non-negative integer.
+	Index bool `json:"isIndex"`
+	// Key tells whether the argument is a key, that is any non-empty string.
+	Key bool `json:"isKey"`
+}
+
+// Field types, as reported in FieldEntry.Type
+const (
+	FieldTypeString   FieldType = "string"
+	FieldTypeUint64   FieldType = "uint64"
+	FieldTypeInt64    FieldType = "int64"
+	FieldTypeBool     FieldType = "bool"
+	FieldTypeDouble   FieldType = "double"
+	FieldTypeAbsTime  FieldType = "abstime"
+	FieldTypeRelTime  FieldType = "reltime"
+	FieldTypeIPv4Addr FieldType = "ipv4addr"
+	FieldTypeIPv6Addr FieldType = "ipv6addr"
+	FieldTypeIPAddr   FieldType = "ipaddr"
+	FieldTypeIPv4Net  FieldType = "ipv4net"
+	FieldTypeIPv6Net  FieldType = "ipv6net"
+	FieldTypeIPNet    FieldType = "ipnet"
+	FieldTypeByteBuf  FieldType = "bytebuf"
+)
+
+// FieldProperty is a property of a field, as reported in FieldEntry.Properties.
+type FieldProperty string
+
+// Field properties, as reported in FieldEntry.Properties
+const (
+	// FieldPropertyHidden hides the field from the lists of fields shown to the users.
+	FieldPropertyHidden FieldProperty = "hidden"
+	// FieldPropertyInfo marks a field carrying general information about the event.
+	FieldPropertyInfo FieldProperty = "info"
+	// FieldPropertyConversation marks a field identifying a conversation,
+	// such as a connection or a session, that related events share.
+	FieldPropertyConversation FieldProperty = "conversation"
+)
+
+var fieldProperties = map[FieldProperty]bool{
+	FieldPropertyHidden:       true,
+	FieldPropertyInfo:         true,
+	FieldPropertyConversation: true,
+}
+
+var fieldTypes = map[uint32]FieldType{
+	ParamTypeCharBuf:  FieldTypeString,
+	ParamTypeUint64:   FieldTypeUint64,
+	ParamTypeInt64:    FieldTypeInt64,
+	ParamTypeBool:     FieldTypeBool,
+	ParamTypeDouble:   FieldTypeDouble,
+	ParamTypeAbsTime:  FieldTypeAbsTime,
+	ParamTypeRelTime:  FieldTypeRelTime,
+	ParamTypeIpv4Addr: FieldTypeIPv4Addr,
+	ParamTypeIpv6Addr: FieldTypeIPv6Addr,
+	ParamTypeIpAddr:   FieldTypeIPAddr,
+	ParamTypeIpv4Net:  FieldTypeIPv4Net,
+	ParamTypeIpv6Net:  FieldTypeIPv6Net,
+	ParamTypeIpNet:    FieldTypeIPNet,
+	ParamTypeByteBuf:  FieldTypeByteBuf,
+}
+
+// ExtractRequest describes a single field extraction requested by sinsp.
+//
+// Arg is the argument of the field as given by sinsp, or empty if there is none.
+// For the fields declaring a FieldArg, it is validated before the extract function
+// is called, and parsed into ArgIndex or ArgKey.
+type ExtractRequest struct {
+	EvtNum  uint64
+	FieldID uint32
+	Field   *Field
+	Arg     string
+	Data    []byte
+
+	// HasArg tells whether the field is extracted with an argument.
+	HasArg bool
+	// ArgIndex is the argument of the fields taking an index.
+	ArgIndex uint64
+	// ArgKey is the argument of the fields taking a key.
+	ArgKey string
+
+	cache *DecodeCache
+}
+
+// Decoded returns the payload of the event decoded by the decode cache bound to the
+// plugin state with SetDecodeCache(), so that it is decoded once for all the fields
+// extracted from the event. It fails if no decode cache is bound to the plugin state
+// or if the payload cannot be decoded.
+func (req *ExtractRequest) Decoded() (interface{}, error) {
+	if req.cache == nil {
+		return nil, fmt.Errorf("field %s: no decode cache", req.Field.Name)
+	}
+	v, err := req.cache.Get(req.EvtNum, req.Data)
+	if err != nil {
+		return nil, fmt.Errorf("field %s: cannot decode event %d: %s", req.Field.Name, req.EvtNum, err.Error())
+	}
+	return v, nil
+}
+
+// StrExtractFunc extracts the value of a string field from the event in req.
+// It returns false if the field is not present in the event.
+type StrExtractFunc func(req *ExtractRequest) (value string, present bool, err error)
+
+// U64ExtractFunc extracts the value of an uint64 field from the event in req.
+// It returns false if the field is not present in the event.
+type U64ExtractFunc func(req *ExtractRequest) (value uint64, present bool, err error)
+
+// Int64ExtractFunc extracts the value of an int64 field from the event in req.
+// It returns false if the field is not present in the event.
+type Int64ExtractFunc func(req *ExtractRequest) (value int64, present bool, err error)
+
+// BoolExtractFunc extracts the value of a bool field from the event in req.
+// It returns false if the field is not present in the event.
+type BoolExtractFunc func(req *ExtractRequest) (value bool, present bool, err error)
+
+// DoubleExtractFunc extracts the value of a double field from the event in req.
+// It returns false if the field is not present in the event.
+type DoubleExtractFunc func(req *ExtractRequest) (value float64, present bool, err error)
+
+// AbsTimeExtractFunc extracts the value of an absolute time field from the event in req.
+// It returns false if the field is not present in the event.
+type AbsTimeExtractFunc func(req *ExtractRequest) (value time.Time, present bool, err error)
+
+// RelTimeExtractFunc extracts the value of a relative time field from the event in req.
+// It returns false if the field is not present in the event.
+type RelTimeExtractFunc func(req *ExtractRequest) (value time.Duration, present bool, err error)
+
+// IPExtractFunc extracts the value of an IP address field from the event in req.
+// It returns false if the field is not present in the event.
+type IPExtractFunc func(req *ExtractRequest) (value net.IP, present bool, err error)
+
+// IPNetExtractFunc extracts the value of an IP network field from the event in req.
+// It returns false if the field is not present in the event.
+type IPNetExtractFunc func(req *ExtractRequest) (value *net.IPNet, present bool, err error)
+
+// BytesExtractFunc extracts the value of a byte buffer field from the event in req.
+// It returns false if the field is not present in the event.
+type BytesExtractFunc func(req *ExtractRequest) (value []byte, present bool, err error)
+
+// Field declares a single field that a plugin can expose, together with the
+// function that extracts it. Exactly one of the extract functions must be set.
+//
+// ParamType is one of the ParamType constants and can be left to zero when it is
+// implied by the extract function. Arg declares the argument taken by the field,
+// if any, so that it is validated and parsed before the extract function is
+// called. ExtractIP and ExtractIPNet default to ParamTypeIpAddr and
+// ParamTypeIpNet respectively, but can also be used with the IPv4 and IPv6
+// specific types.
+type Field struct {
+	Name       string
+	Display    string
+	Desc       string
+	Properties []FieldProperty
+	ParamType  uint32
+	Arg        *FieldArg
+
+	ExtractStr     StrExtractFunc
+	ExtractU64     U64ExtractFunc
+	ExtractInt64   Int64ExtractFunc
+	ExtractBool    BoolExtractFunc
+	ExtractDouble  DoubleExtractFunc
+	ExtractAbsTime AbsTimeExtractFunc
+	ExtractRelTime RelTimeExtractFunc
+	ExtractIP      IPExtractFunc
+	ExtractIPNet   IPNetExtractFunc
+	ExtractBytes   BytesExtractFunc
+
+	// set by FieldsError()
+	err error
+}
+
+// FieldsError returns fields that fail validation with err. It lets Extractor.Fields()
+// report an error, such as the one returned by StructFields(), which is then reported
+// by plugin_get_fields and plugin_init as any invalid field.
+func FieldsError(err error) []Field {
+	return []Field{{err: err}}
+}
+
+// StrField returns a string Field with the given name, description and extract function.
+func StrField(name, desc string, f StrExtractFunc) Field {
+	return Field{Name: name, Desc: desc, ExtractStr: f}
+}
+
+// U64Field returns an uint64 Field with the given name, description and extract function.
+func U64Field(name, desc string, f U64ExtractFunc) Field {
+	return Field{Name: name, Desc: desc, ExtractU64: f}
+}
+
+// Int64Field returns an int64 Field with the given name, description and extract function.
+func Int64Field(name, desc string, f Int64ExtractFunc) Field {
+	return Field{Name: name, Desc: desc, ExtractInt64: f}
+}
+
+// BoolField returns a bool Field with the given name, description and extract function.
+func BoolField(name, desc string, f BoolExtractFunc) Field {
+	return Field{Name: name, Desc: desc, ExtractBool: f}
+}
+
+// DoubleField returns a double Field with the given name, description and extract function.
+func DoubleField(name, desc string, f DoubleExtractFunc) Field {
+	return Field{Name: name, Desc: desc, ExtractDouble: f}
+}
+
+// AbsTimeField returns an absolute time Field with the given name, description and extract function.
+func AbsTimeField(name, desc string, f AbsTimeExtractFunc) Field {
+	return Field{Name: name, Desc: desc, ExtractAbsTime: f}
+}
+
+// RelTimeField returns a relative time Field with the given name, description and extract function.
+func RelTimeField(name, desc string, f RelTimeExtractFunc) Field {
+	return Field{Name: name, Desc: desc, ExtractRelTime: f}
+}
+
+// IPField returns an IP address Field with the given name, description and extract function.
+// paramType must be one of ParamTypeIpv4Addr, ParamTypeIpv6Addr or ParamTypeIpAddr.
+func IPField(name, desc string, paramType uint32, f IPExtractFunc) Field {
+	return Field{Name: name, Desc: desc, ParamType: paramType, ExtractIP: f}
+}
+
+// IPNetField returns an IP network Field with the given name, description and extract function.
+// paramType must be one of ParamTypeIpv4Net, ParamTypeIpv6Net or ParamTypeIpNet.
+func IPNetField(name, desc string, paramType uint32, f IPNetExtractFunc) Field {
+	return Field{Name: name, Desc: desc, ParamType: paramType, ExtractIPNet: f}
+}
+
+// BytesField returns a byte buffer Field with the given name, description and extract function.
+func BytesField(name, desc string, f BytesExtractFunc) Field {
+	return Field{Name: name, Desc: desc, ExtractBytes: f}
+}
+
+// extractFuncs returns the number of extract functions set in f, and the
+// param types compatible with the last one.
+func (f *Field) extractFuncs() (n int, types []uint32) {
+	check := func(set bool, t ...uint32) {
+		if set {
+			n++
+			types = t
+		}
+	}
+	check(f.ExtractStr != nil, ParamTypeCharBuf)
+	check(f.ExtractU64 != nil, ParamTypeUint64)
+	check(f.ExtractInt64 != nil, ParamTypeInt64)
+	check(f.ExtractBool != nil, ParamTypeBool)
+	check(f.ExtractDouble != nil, ParamTypeDouble)
+	check(f.ExtractAbsTime != nil, ParamTypeAbsTime)
+	check(f.ExtractRelTime != nil, ParamTypeRelTime)
+	check(f.ExtractIP != nil, ParamTypeIpAddr, ParamTypeIpv4Addr, ParamTypeIpv6Addr)
+	check(f.ExtractIPNet != nil, ParamTypeIpNet, ParamTypeIpv4Net, ParamTypeIpv6Net)
+	check(f.ExtractBytes != nil, ParamTypeByteBuf)
+	return
+}
+
+// paramType returns the ParamType constant of f, or ParamTypeNone if f is not valid.
+func (f *Field) paramType() uint32 {
+	n, types := f.extractFuncs()
+	if n != 1 {
+		return ParamTypeNone
+	}
+	if f.ParamType == ParamTypeNone {
+		return types[0]
+	}
+	for _, t := range types {
+		if t == f.ParamType {
+			return t
+		}
+	}
+	return ParamTypeNone
+}
+
+// Type returns the type of f, as reported in FieldEntry.Type.
+func (f *Field) Type() FieldType {
+	return fieldTypes[f.paramType()]
+}
+
+// FieldParamType returns the ParamType constant matching the type t, as reported in
+// FieldEntry.Type, or false if t is not a valid field type.
+func FieldParamType(t FieldType) (uint32, bool) {
+	for pt, name := range fieldTypes {
+		if name == t {
+			return pt, true
+		}
+	}
+	return ParamTypeNone, false
+}
+
+// FieldRegistry holds the fields exposed by a plugin, each identified by
+// its position in the list the registry was created with.
+type FieldRegistry struct {
+	fields []Field
+}
+
+// NewFieldRegistry validates fields and returns a FieldRegistry for them.
+// Field IDs are assigned following the order of fields, and the resulting
+// entries must be valid as described in ValidateFields().
+func NewFieldRegistry(fields []Field) (*FieldRegistry, error) {
+	names := make(map[string]bool, len(fields))
+	for i, f := range fields {
+		if f.err != nil {
+			return nil, f.err
+		}
+		if f.Name == "" {
+			return nil, fmt.Errorf("field %d: empty name", i)
+		}
+		if names[f.Name] {
+			return nil, fmt.Errorf("field %s: duplicate name", f.Name)
+		}
+		names[f.Name] = true
+		switch n, _ := f.extractFuncs(); {
+		case n == 0:
+			return nil, fmt.Errorf("field %s: no extract function", f.Name)
+		case n > 1:
+			return nil, fmt.Errorf("field %s: more than one extract function", f.Name)
+		}
+		if f.paramType() == ParamTypeNone {
+			return nil, fmt.Errorf("field %s: param type %d does not match the extract function", f.Name, f.ParamType)
+		}
+		if f.Arg != nil && f.Arg.Index == f.Arg.Key {
+			return nil, fmt.Errorf("field %s: the argument must be either an index or a key", f.Name)
+		}
+	}
+
+	r := &FieldRegistry{fields: make([]Field, len(fields))}
+	copy(r.fields, fields)
+	for i := range r.fields {
+		if a := r.fields[i].Arg; a != nil {
+			// Do not share the argument with the caller
+			argCopy := *a
+			r.fields[i].Arg = &argCopy
+		}
+	}
+	if err := ValidateFields("", r.Entries()); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Field returns the field with the given id, or nil if there is none.
+func (r *FieldRegistry) Field(id uint32) *Field {
+	if int(id) >= len(r.fields) {
+		return nil
+	}
+	return &r.fields[id]
+}
+
+// Entries returns the FieldEntry list describing the fields of r.
+func (r *FieldRegistry) Entries() []FieldEntry {
+	entries := make([]FieldEntry, len(r.fields))
+	for i, f := range r.fields {
+		entries[i] = FieldEntry{
+			Type:       f.Type(),
+			ID:         uint32(i),
+			Name:       f.Name,
+			Arg:        f.Arg,
+			Display:    f.Display,
+			Desc:       f.Desc,
+			Properties: append([]FieldProperty(nil), f.Properties...),
+		}
+	}
+	return entries
+}
+
+// JSON returns the JSON encoding of the fields of r, as expected from plugin_get_fields().
+// Use MarshalFields() with the Entries() of r to also check that the field names
+// start with the plugin name.
+func (r *FieldRegistry) JSON() ([]byte, error) {
+	return MarshalFields("", r.Entries())
+}
+
+func (r *FieldRegistry) request(evtnum uint64, id uint32, arg string, data []byte, cache *DecodeCache) (*ExtractRequest, error) {
+	f := r.Field(id)
+	if f == nil {
+		return nil, fmt.Errorf("unknown field id %d", id)
+	}
+	req := &ExtractRequest{EvtNum: evtnum, FieldID: id, Field: f, Arg: arg, Data: data, cache: cache}
+	if err := req.parseArg(); err != nil {
+		return nil, err
+	}
+	return req, nil
+}
+
+// parseArg validates the argument of req against the FieldArg of its field,
+// and parses it into ArgIndex or ArgKey.
+func (req *ExtractRequest) parseArg() error {
+	f := req.Field
+	req.HasArg = req.Arg != ""
+	switch {
+	case f.Arg == nil:
+		if req.HasArg {
+			return fmt.Errorf("field %s: unexpected argument %q", f.Name, req.Arg)
+		}
+	case !req.HasArg:
+		if f.Arg.Required {
+			return fmt.Errorf("field %s: missing required argument", f.Name)
+		}
+	case f.Arg.Index:
+		i, err := strconv.ParseUint(req.Arg, 10, 64)
+		if err != nil {
+			return fmt.Errorf("field %s: invalid index argument %q", f.Name, req.Arg)
+		}
+		req.ArgIndex = i
+	case f.Arg.Key:
+		req.ArgKey = req.Arg
+	}
+	return nil
+}
+
+// ParamType returns the ParamType constant of the field id, or ParamTypeNone if there is no such field.
+func (r *FieldRegistry) ParamType(id uint32) uint32 {
+	if f := r.Field(id); f != nil {
+		return f.paramType()
+	}
+	return ParamTypeNone
+}
+
+// ExtractStr routes the extraction of the string field id to its extract function.
+func (r *FieldRegistry) ExtractStr(evtnum uint64, id uint32, arg string, data []byte) (string, bool, error) {
+	return r.extractStr(evtnum, id, arg, data, nil)
+}
+
+func (r *FieldRegistry) extractStr(evtnum uint64, id uint32, arg string, data []byte, cache *DecodeCache) (string, bool, error) {
+	req, err := r.request(evtnum, id, arg, data, cache)
+	if err != nil {
+		return "", false, err
+	}
+	if req.Field.ExtractStr == nil {
+		return "", false, fmt.Errorf("field %s: not a string field", req.Field.Name)
+	}
+	return req.Field.ExtractStr(req)
+}
+
+// ExtractU64 routes the extraction of the field id to its extract function, for all the
+// fields whose value fits in 64 bits, and encodes it as:
+//  - uint64 and int64: the two's complement value
+//  - bool: 1 for true and 0 for false
+//  - double: the IEEE 754 binary representation
+//  - abstime: nanoseconds since the epoch
+//  - reltime: nanoseconds
+func (r *FieldRegistry) ExtractU64(evtnum uint64, id uint32, arg string, data []byte) (uint64, bool, error) {
+	return r.extractU64(evtnum, id, arg, data, nil)
+}
+
+func (r *FieldRegistry) extractU64(evtnum uint64, id uint32, arg string, data []byte, cache *DecodeCache) (uint64, bool, error) {
+	req, err := r.request(evtnum, id, arg, data, cache)
+	if err != nil {
+		return 0, false, err
+	}
+
+	f := req.Field
+	switch f.paramType() {
+	case ParamTypeUint64:
+		return f.ExtractU64(req)
+	case ParamTypeInt64:
+		v, present, err := f.ExtractInt64(req)
+		return uint64(v), present, err
+	case ParamTypeBool:
+		v, present, err := f.ExtractBool(req)
+		if v {
+			return 1, present, err
+		}
+		return 0, present, err
+	case ParamTypeDouble:
+		v, present, err := f.ExtractDouble(req)
+		return math.Float64bits(v), present, err
+	case ParamTypeAbsTime:
+		v, present, err := f.ExtractAbsTime(req)
+		if !present || err != nil {
+			return 0, present, err
+		}
+		return uint64(v.UnixNano()), present, err
+	case ParamTypeRelTime:
+		v, present, err := f.ExtractRelTime(req)
+		return uint64(v.Nanoseconds()), present, err
+	default:
+		return 0, false, fmt.Errorf("field %s: not a 64 bit field", f.Name)
+	}
+}
+
+// ExtractBuf routes the extraction of the field id to its extract function, for all the
+// fields whose value is a byte buffer, and encodes it as:
+//  - bytebuf: the raw bytes
+//  - ipv4addr, ipv6addr: the 4 or 16 bytes of the address in network order
+//  - ipaddr: as ipv4addr for IPv4 addresses and as ipv6addr otherwise
+//  - ipv4net, ipv6net, ipnet: the address followed by the mask, encoded as above
+func (r *FieldRegistry) ExtractBuf(evtnum uint64, id uint32, arg string, data []byte) ([]byte, bool, error) {
+	return r.extractBuf(evtnum, id, arg, data, nil)
+}
+
+func (r *FieldRegistry) extractBuf(evtnum uint64, id uint32, arg string, data []byte, cache *DecodeCache) ([]byte, bool, error) {
+	req, err := r.request(evtnum, id, arg, data, cache)
+	if err != nil {
+		return nil, false, err
+	}
+
+	f := req.Field
+	switch t := f.paramType(); t {
+	case ParamTypeByteBuf:
+		return f.ExtractBytes(req)
+	case ParamTypeIpv4Addr, ParamTypeIpv6Addr, ParamTypeIpAddr:
+		v, present, err := f.ExtractIP(req)
+		if !present || err != nil {
+			return nil, present, err
+		}
+		b, err := encodeIP(t, v)
+		if err != nil {
+			return nil, false, fmt.Errorf("field %s: %s", f.Name, err.Error())
+		}
+		return b, true, nil
+	case ParamTypeIpv4Net, ParamTypeIpv6Net, ParamTypeIpNet:
+		v, present, err := f.ExtractIPNet(req)
+		if !present || err != nil {
+			return nil, present, err
+		}
+		b, err := encodeIPNet(t, v)
+		if err != nil {
+			return nil, false, fmt.Errorf("field %s: %s", f.Name, err.Error())
+		}
+		return b, true, nil
+	default:
+		return nil, false, fmt.Errorf("field %s: not a buffer field", f.Name)
+	}
+}
+
+func encodeIP(t uint32, ip net.IP) ([]byte, error) {
+	ip4 := ip.To4()
+	switch {
+	case t == ParamTypeIpv4Addr && ip4 != nil:
+		return ip4, nil
+	case t == ParamTypeIpv6Addr && len(ip) == net.IPv6len:
+		return ip, nil
+	case t == ParamTypeIpAddr && ip4 != nil:
+		return ip4, nil
+	case t == ParamTypeIpAddr && len(ip) == net.IPv6len:
+		return ip, nil
+	default:
+		return nil, fmt.Errorf("invalid %s value %v", fieldTypes[t], ip)
+	}
+}
+
+func encodeIPNet(t uint32, n *net.IPNet) ([]byte, error) {
+	if n == nil {
+		return nil, fmt.Errorf("invalid %s value <nil>", fieldTypes[t])
+	}
+
+	addrType := ParamTypeIpAddr
+	switch t {
+	case ParamTypeIpv4Net:
+		addrType = ParamTypeIpv4Addr
+	case ParamTypeIpv6Net:
+		addrType = ParamTypeIpv6Addr
+	}
+
+	ip, err := encodeIP(addrType, n.IP)
+	if err != nil || len(ip) != len(n.Mask) {
+		return nil, fmt.Errorf("invalid %s value %v", fieldTypes[t], n)
+	}
+	return append(ip, n.Mask...), nil
 }
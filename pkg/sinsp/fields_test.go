@@ -0,0 +1,143 @@
+package sinsp
+
+import (
+	"bytes"
+	"math"
+	"net"
+	"testing"
+	"time"
+)
+
+// newRegistry returns a FieldRegistry for the single field f.
+func newRegistry(t *testing.T, f Field) *FieldRegistry {
+	t.Helper()
+	if f.Desc == "" {
+		f.Desc = "test field"
+	}
+	r, err := NewFieldRegistry([]Field{f})
+	if err != nil {
+		t.Fatalf("NewFieldRegistry: %s", err)
+	}
+	return r
+}
+
+func TestExtractU64Encodings(t *testing.T) {
+	abs := time.Date(2021, 1, 2, 3, 4, 5, 6, time.UTC)
+	tests := []struct {
+		name string
+		f    Field
+		want uint64
+	}{
+		{"uint64", U64Field("test.u64", "", func(*ExtractRequest) (uint64, bool, error) {
+			return math.MaxUint64, true, nil
+		}), math.MaxUint64},
+		{"int64", Int64Field("test.i64", "", func(*ExtractRequest) (int64, bool, error) {
+			return -2, true, nil
+		}), math.MaxUint64 - 1},
+		{"true", BoolField("test.bool", "", func(*ExtractRequest) (bool, bool, error) {
+			return true, true, nil
+		}), 1},
+		{"false", BoolField("test.bool", "", func(*ExtractRequest) (bool, bool, error) {
+			return false, true, nil
+		}), 0},
+		{"double", DoubleField("test.double", "", func(*ExtractRequest) (float64, bool, error) {
+			return -1.5, true, nil
+		}), math.Float64bits(-1.5)},
+		{"abstime", AbsTimeField("test.abstime", "", func(*ExtractRequest) (time.Time, bool, error) {
+			return abs, true, nil
+		}), uint64(abs.UnixNano())},
+		{"reltime", RelTimeField("test.reltime", "", func(*ExtractRequest) (time.Duration, bool, error) {
+			return 1500 * time.Millisecond, true, nil
+		}), 1500000000},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := newRegistry(t, tt.f)
+			v, present, err := r.ExtractU64(1, 0, "", nil)
+			if err != nil || !present {
+				t.Fatalf("got (%t, %v)", present, err)
+			}
+			if v != tt.want {
+				t.Fatalf("got %#x, want %#x", v, tt.want)
+			}
+		})
+	}
+}
+
+func TestExtractU64NotPresent(t *testing.T) {
+	r := newRegistry(t, AbsTimeField("test.abstime", "", func(*ExtractRequest) (time.Time, bool, error) {
+		return time.Time{}, false, nil
+	}))
+	if v, present, err := r.ExtractU64(1, 0, "", nil); v != 0 || present || err != nil {
+		t.Fatalf("got (%d, %t, %v), want not present", v, present, err)
+	}
+}
+
+func TestExtractBufIPEncodings(t *testing.T) {
+	v4 := net.ParseIP("10.1.2.3")
+	v6 := net.ParseIP("2001:db8::1")
+	_, v4Net, _ := net.ParseCIDR("10.0.0.0/8")
+	_, v6Net, _ := net.ParseCIDR("2001:db8::/32")
+
+	tests := []struct {
+		name      string
+		paramType uint32
+		ip        net.IP
+		ipNet     *net.IPNet
+		want      []byte // nil if an error is expected
+	}{
+		{"ipv4addr", ParamTypeIpv4Addr, v4, nil, []byte{10, 1, 2, 3}},
+		{"ipv4addr of v6", ParamTypeIpv4Addr, v6, nil, nil},
+		{"ipv6addr", ParamTypeIpv6Addr, v6, nil, v6},
+		{"ipv6addr of v4", ParamTypeIpv6Addr, v4.To4(), nil, nil},
+		{"ipaddr of v4", ParamTypeIpAddr, v4, nil, []byte{10, 1, 2, 3}},
+		{"ipaddr of v6", ParamTypeIpAddr, v6, nil, v6},
+		{"ipaddr invalid", ParamTypeIpAddr, net.IP{1, 2}, nil, nil},
+		{"ipv4net", ParamTypeIpv4Net, nil, v4Net, []byte{10, 0, 0, 0, 255, 0, 0, 0}},
+		{"ipv4net of v6", ParamTypeIpv4Net, nil, v6Net, nil},
+		{"ipv6net", ParamTypeIpv6Net, nil, v6Net, append(append([]byte{}, v6Net.IP...), v6Net.Mask...)},
+		{"ipv6net of v4", ParamTypeIpv6Net, nil, v4Net, nil},
+		{"ipnet of v4", ParamTypeIpNet, nil, v4Net, []byte{10, 0, 0, 0, 255, 0, 0, 0}},
+		{"ipnet of v6", ParamTypeIpNet, nil, v6Net, append(append([]byte{}, v6Net.IP...), v6Net.Mask...)},
+		{"ipnet mask mismatch", ParamTypeIpNet, nil, &net.IPNet{IP: v4.To4(), Mask: v6Net.Mask}, nil},
+		{"ipnet nil", ParamTypeIpNet, nil, nil, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := IPField("test.ip", "", tt.paramType, func(*ExtractRequest) (net.IP, bool, error) {
+				return tt.ip, true, nil
+			})
+			if tt.ip == nil {
+				f = IPNetField("test.net", "", tt.paramType, func(*ExtractRequest) (*net.IPNet, bool, error) {
+					return tt.ipNet, true, nil
+				})
+			}
+
+			r := newRegistry(t, f)
+			b, present, err := r.ExtractBuf(1, 0, "", nil)
+			if tt.want == nil {
+				if err == nil {
+					t.Fatalf("got %v, want an error", b)
+				}
+				return
+			}
+			if err != nil || !present {
+				t.Fatalf("got (%t, %v)", present, err)
+			}
+			if !bytes.Equal(b, tt.want) {
+				t.Fatalf("got %v, want %v", b, tt.want)
+			}
+		})
+	}
+}
+
+func TestExtractBufBytes(t *testing.T) {
+	r := newRegistry(t, BytesField("test.bytes", "", func(*ExtractRequest) ([]byte, bool, error) {
+		return []byte{0, 1, 2}, true, nil
+	}))
+	if b, present, err := r.ExtractBuf(1, 0, "", nil); !bytes.Equal(b, []byte{0, 1, 2}) || !present || err != nil {
+		t.Fatalf("got (%v, %t, %v)", b, present, err)
+	}
+}
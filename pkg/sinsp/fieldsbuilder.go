@@ -0,0 +1,146 @@
+package sinsp
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// fieldNameRe matches the field names made of dot separated words of letters, digits and underscores.
+var fieldNameRe = regexp.MustCompile(`^[a-zA-Z0-9_]+(\.[a-zA-Z0-9_]+)*$`)
+
+// FieldsBuilder builds the FieldEntry list of a plugin, assigning the field IDs
+// in order, and validates it as described in ValidateFields().
+//
+// Intended usage as in the following example:
+//
+//     //export plugin_get_fields
+//     func plugin_get_fields() *C.char {
+//     	b := sinsp.NewFieldsBuilder("myplugin")
+//     	b.Add(sinsp.FieldTypeString, "myplugin.user", "User name")
+//     	b.Add(sinsp.FieldTypeUint64, "myplugin.pid", "Process ID", sinsp.FieldPropertyInfo)
+//     	j, err := b.JSON()
+//     	if err != nil {
+//     		sinsp.SetLastError(nil, err)
+//     		return nil
+//     	}
+//     	return C.CString(string(j))
+//     }
+//
+type FieldsBuilder struct {
+	pluginName string
+	entries    []FieldEntry
+}
+
+// NewFieldsBuilder returns a FieldsBuilder for the fields of the plugin pluginName.
+// Unless pluginName is empty, the names of the fields must start with it, followed by a dot.
+func NewFieldsBuilder(pluginName string) *FieldsBuilder {
+	return &FieldsBuilder{pluginName: pluginName}
+}
+
+// Add appends a field of type t with the given name, description and properties,
+// and returns its ID.
+func (b *FieldsBuilder) Add(t FieldType, name, desc string, props ...FieldProperty) uint32 {
+	return b.AddEntry(FieldEntry{Type: t, Name: name, Desc: desc, Properties: props})
+}
+
+// AddEntry appends the field e, setting its ID to the next one, and returns it.
+// It allows setting the display name and the argument of the field.
+func (b *FieldsBuilder) AddEntry(e FieldEntry) uint32 {
+	e.ID = uint32(len(b.entries))
+	b.entries = append(b.entries, e)
+	return e.ID
+}
+
+// Entries validates the fields added to b and returns them.
+func (b *FieldsBuilder) Entries() ([]FieldEntry, error) {
+	if err := ValidateFields(b.pluginName, b.entries); err != nil {
+		return nil, err
+	}
+	entries := make([]FieldEntry, len(b.entries))
+	copy(entries, b.entries)
+	return entries, nil
+}
+
+// JSON validates the fields added to b and returns their JSON encoding, as expected
+// from plugin_get_fields().
+func (b *FieldsBuilder) JSON() ([]byte, error) {
+	return MarshalFields(b.pluginName, b.entries)
+}
+
+// ValidateFields checks that the fields of the plugin pluginName are valid for libsinsp:
+//  - the ID of every field is its position in fields
+//  - names are made of dot separated words of letters, digits and underscores, and
+//    are unique; unless pluginName is empty, they must start with pluginName and a dot
+//  - types are one of the FieldType constants
+//  - descriptions are not empty
+//  - properties are one of the FieldProperty constants, each given at most once
+//  - arguments are either an index or a key
+func ValidateFields(pluginName string, fields []FieldEntry) error {
+	names := make(map[string]bool, len(fields))
+	for i, f := range fields {
+		if err := validateField(pluginName, uint32(i), &f); err != nil {
+			if f.Name == "" {
+				return fmt.Errorf("field %d: %s", i, err.Error())
+			}
+			return fmt.Errorf("field %d (%s): %s", i, f.Name, err.Error())
+		}
+		if names[f.Name] {
+			return fmt.Errorf("field %d (%s): duplicate name", i, f.Name)
+		}
+		names[f.Name] = true
+	}
+	return nil
+}
+
+func validateField(pluginName string, id uint32, f *FieldEntry) error {
+	if f.ID != id {
+		return fmt.Errorf("ID %d does not match the position of the field", f.ID)
+	}
+
+	switch {
+	case f.Name == "":
+		return fmt.Errorf("empty name")
+	case !fieldNameRe.MatchString(f.Name):
+		return fmt.Errorf("invalid name, only dot separated words of letters, digits and underscores are allowed")
+	case pluginName != "" && !strings.HasPrefix(f.Name, pluginName+"."):
+		return fmt.Errorf("the name does not start with %q", pluginName+".")
+	}
+
+	if _, ok := FieldParamType(f.Type); !ok {
+		return fmt.Errorf("invalid type %q", f.Type)
+	}
+	if strings.TrimSpace(f.Desc) == "" {
+		return fmt.Errorf("empty description")
+	}
+
+	props := make(map[FieldProperty]bool, len(f.Properties))
+	for _, p := range f.Properties {
+		if !fieldProperties[p] {
+			return fmt.Errorf("invalid property %q", p)
+		}
+		if props[p] {
+			return fmt.Errorf("duplicate property %q", p)
+		}
+		props[p] = true
+	}
+
+	if f.Arg != nil && f.Arg.Index == f.Arg.Key {
+		return fmt.Errorf("the argument must be either an index or a key")
+	}
+	return nil
+}
+
+// MarshalFields validates the fields of the plugin pluginName with ValidateFields()
+// and returns their JSON encoding, as expected from plugin_get_fields().
+func MarshalFields(pluginName string, fields []FieldEntry) ([]byte, error) {
+	if err := ValidateFields(pluginName, fields); err != nil {
+		return nil, err
+	}
+	if fields == nil {
+		// an empty list rather than null
+		fields = []FieldEntry{}
+	}
+	return json.Marshal(fields)
+}
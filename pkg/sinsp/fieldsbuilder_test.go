@@ -0,0 +1,139 @@
+package sinsp
+
+import (
+	"strings"
+	"testing"
+)
+
+// validEntry returns a valid entry with the given ID.
+func validEntry(id uint32) FieldEntry {
+	return FieldEntry{Type: FieldTypeString, ID: id, Name: "test.field", Desc: "A field"}
+}
+
+func TestValidateFields(t *testing.T) {
+	tests := []struct {
+		name   string
+		change func(e *FieldEntry)
+		err    string // empty if valid
+	}{
+		{"valid", func(e *FieldEntry) {}, ""},
+		{"nested name", func(e *FieldEntry) { e.Name = "test.a_1.B2" }, ""},
+		{"wrong ID", func(e *FieldEntry) { e.ID = 3 }, "does not match the position"},
+		{"empty name", func(e *FieldEntry) { e.Name = "" }, "empty name"},
+		{"empty word", func(e *FieldEntry) { e.Name = "test..field" }, "invalid name"},
+		{"trailing dot", func(e *FieldEntry) { e.Name = "test.field." }, "invalid name"},
+		{"space", func(e *FieldEntry) { e.Name = "test.my field" }, "invalid name"},
+		{"other plugin", func(e *FieldEntry) { e.Name = "other.field" }, `does not start with "test."`},
+		{"plugin name only", func(e *FieldEntry) { e.Name = "test" }, `does not start with "test."`},
+		{"invalid type", func(e *FieldEntry) { e.Type = "uint32" }, `invalid type "uint32"`},
+		{"empty desc", func(e *FieldEntry) { e.Desc = " " }, "empty description"},
+		{"properties", func(e *FieldEntry) {
+			e.Properties = []FieldProperty{FieldPropertyHidden, FieldPropertyInfo, FieldPropertyConversation}
+		}, ""},
+		{"invalid property", func(e *FieldEntry) { e.Properties = []FieldProperty{"secret"} }, `invalid property "secret"`},
+		{"duplicate property", func(e *FieldEntry) {
+			e.Properties = []FieldProperty{FieldPropertyInfo, FieldPropertyInfo}
+		}, `duplicate property "info"`},
+		{"index arg", func(e *FieldEntry) { e.Arg = &FieldArg{Index: true} }, ""},
+		{"required key arg", func(e *FieldEntry) { e.Arg = &FieldArg{Required: true, Key: true} }, ""},
+		{"index and key arg", func(e *FieldEntry) { e.Arg = &FieldArg{Index: true, Key: true} }, "either an index or a key"},
+		{"untyped arg", func(e *FieldEntry) { e.Arg = &FieldArg{Required: true} }, "either an index or a key"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := validEntry(0)
+			tt.change(&e)
+			err := ValidateFields("test", []FieldEntry{e})
+			switch {
+			case tt.err == "" && err != nil:
+				t.Fatalf("got %s, want no error", err)
+			case tt.err != "" && err == nil:
+				t.Fatalf("got no error, want %q", tt.err)
+			case tt.err != "" && !strings.Contains(err.Error(), tt.err):
+				t.Fatalf("got %s, want %q", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestValidateFieldsDuplicateName(t *testing.T) {
+	err := ValidateFields("test", []FieldEntry{validEntry(0), validEntry(1)})
+	if err == nil || !strings.Contains(err.Error(), "field 1 (test.field): duplicate name") {
+		t.Fatalf("got %v, want a duplicate name error", err)
+	}
+}
+
+func TestValidateFieldsAnyPlugin(t *testing.T) {
+	e := validEntry(0)
+	e.Name = "other.field"
+	if err := ValidateFields("", []FieldEntry{e}); err != nil {
+		t.Fatalf("got %s, want no error without plugin name", err)
+	}
+}
+
+func TestFieldsBuilder(t *testing.T) {
+	b := NewFieldsBuilder("test")
+	if id := b.Add(FieldTypeString, "test.user", "User name"); id != 0 {
+		t.Fatalf("first ID: got %d", id)
+	}
+	if id := b.Add(FieldTypeUint64, "test.pid", "Process ID", FieldPropertyInfo); id != 1 {
+		t.Fatalf("second ID: got %d", id)
+	}
+	id := b.AddEntry(FieldEntry{
+		ID:      42, // overridden
+		Type:    FieldTypeString,
+		Name:    "test.label",
+		Display: "Label",
+		Desc:    "Label with the given key",
+		Arg:     &FieldArg{Required: true, Key: true},
+	})
+	if id != 2 {
+		t.Fatalf("third ID: got %d", id)
+	}
+
+	entries, err := b.Entries()
+	if err != nil {
+		t.Fatalf("Entries: %s", err)
+	}
+	entries[0].Name = "changed"
+	if again, _ := b.Entries(); again[0].Name != "test.user" {
+		t.Fatal("Entries returned the entries of the builder rather than a copy")
+	}
+
+	j, err := b.JSON()
+	if err != nil {
+		t.Fatalf("JSON: %s", err)
+	}
+	// Properties is an array, omitted when empty
+	want := `[` +
+		`{"type":"string","ID":0,"name":"test.user","display":"","desc":"User name"},` +
+		`{"type":"uint64","ID":1,"name":"test.pid","display":"","desc":"Process ID","properties":["info"]},` +
+		`{"type":"string","ID":2,"name":"test.label","arg":{"isRequired":true,"isIndex":false,"isKey":true},"display":"Label","desc":"Label with the given key"}` +
+		`]`
+	if string(j) != want {
+		t.Fatalf("got\n%s\nwant\n%s", j, want)
+	}
+}
+
+func TestFieldsBuilderInvalid(t *testing.T) {
+	b := NewFieldsBuilder("test")
+	b.Add(FieldTypeString, "test.user", "User name")
+	b.Add(FieldTypeString, "test.user", "Again")
+	if _, err := b.Entries(); err == nil {
+		t.Error("Entries: no error for a duplicate name")
+	}
+	if _, err := b.JSON(); err == nil {
+		t.Error("JSON: no error for a duplicate name")
+	}
+}
+
+func TestMarshalFieldsEmpty(t *testing.T) {
+	j, err := MarshalFields("test", nil)
+	if err != nil {
+		t.Fatalf("MarshalFields: %s", err)
+	}
+	if string(j) != "[]" {
+		t.Fatalf("got %s, want []", j)
+	}
+}
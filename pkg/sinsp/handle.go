@@ -0,0 +1,109 @@
+package sinsp
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+	"sync/atomic"
+)
+
+// Handle is an integer value referring to a Go value, which can be stored in
+// C memory in place of a Go pointer, following the cgo pointer passing rules.
+//
+// A Handle keeps its value alive until Delete() is called, so every handle must
+// be explicitly deleted. The zero Handle is never returned by NewHandle() and
+// can be used to represent no value.
+type Handle uintptr
+
+type handleEntry struct {
+	value interface{}
+	// creation stack, only recorded in debug mode
+	stack []byte
+}
+
+var (
+	handles   = &sync.Map{}
+	handleIdx uintptr
+)
+
+// NewHandle returns a handle for the given value, which must not be nil.
+func NewHandle(v interface{}) Handle {
+	if v == nil {
+		panic("sinsp: NewHandle called with a nil value")
+	}
+	h := Handle(atomic.AddUintptr(&handleIdx, 1))
+	handles.Store(h, &handleEntry{value: v, stack: debugStack()})
+	return h
+}
+
+// Value returns the value referred to by h. It panics if h is not valid.
+func (h Handle) Value() interface{} {
+	e, ok := handles.Load(h)
+	if !ok {
+		panic(fmt.Sprintf("sinsp: invalid handle %d", h))
+	}
+	return e.(*handleEntry).value
+}
+
+// Delete invalidates h, releasing the value it refers to. It panics if h is not valid,
+// so that when the same handle is deleted concurrently only one call succeeds.
+func (h Handle) Delete() {
+	if _, ok := handles.LoadAndDelete(h); !ok {
+		panic(fmt.Sprintf("sinsp: invalid handle %d", h))
+	}
+}
+
+// LiveHandles returns the number of handles not yet deleted.
+func LiveHandles() int {
+	n := 0
+	handles.Range(func(k, v interface{}) bool {
+		n++
+		return true
+	})
+	return n
+}
+
+// CheckHandles returns an error describing the handles not yet deleted, if any,
+// including the stacks that created them when built with the sinsp_debug tag.
+//
+// Once every state container has been freed no handle should be left, so
+// that any handle reported at that point has leaked.
+func CheckHandles() error {
+	var ids []Handle
+	handles.Range(func(k, v interface{}) bool {
+		ids = append(ids, k.(Handle))
+		return true
+	})
+	if len(ids) == 0 {
+		return nil
+	}
+
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	msg := fmt.Sprintf("%d handles leaked", len(ids))
+	for _, h := range ids {
+		if e, ok := handles.Load(h); ok {
+			msg += fmt.Sprintf("\nhandle %d (%T)", h, e.(*handleEntry).value)
+			if s := e.(*handleEntry).stack; s != nil {
+				msg += fmt.Sprintf(" created at:\n%s", s)
+			}
+		}
+	}
+	return fmt.Errorf("%s", msg)
+}
+
+// ReportLeakedHandles logs the handles not yet deleted and the state containers
+// not yet freed, as described by DebugReport(), when built with the sinsp_debug tag.
+// Otherwise, it does nothing.
+//
+// Since it reports the state containers and handles of the whole process, it is meant to
+// be called at the end of the plugin_destroy of the last plugin instance, after freeing
+// its plugin state: while other instances are alive, their memory would be reported too.
+func ReportLeakedHandles() {
+	if !debugMode {
+		return
+	}
+	if r := DebugReport(); r != "" {
+		log.Printf("sinsp: %s\n", r)
+	}
+}
@@ -0,0 +1,65 @@
+package sinsp
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+func TestHandle(t *testing.T) {
+	live := LiveHandles()
+	h := NewHandle("value")
+	if h == 0 {
+		t.Fatal("NewHandle returned the zero handle")
+	}
+	if v := h.Value(); v != "value" {
+		t.Fatalf("got value %v", v)
+	}
+	if n := LiveHandles(); n != live+1 {
+		t.Fatalf("got %d live handles, want %d", n, live+1)
+	}
+	h.Delete()
+	if n := LiveHandles(); n != live {
+		t.Fatalf("got %d live handles after Delete, want %d", n, live)
+	}
+
+	for name, f := range map[string]func(){
+		"Value":  func() { h.Value() },
+		"Delete": func() { h.Delete() },
+	} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("%s did not panic on a deleted handle", name)
+				}
+			}()
+			f()
+		}()
+	}
+}
+
+func TestHandleConcurrentDelete(t *testing.T) {
+	const n = 16
+	for i := 0; i < 100; i++ {
+		h := NewHandle(i)
+		var deleted, panicked int32
+		var wg sync.WaitGroup
+		wg.Add(n)
+		for j := 0; j < n; j++ {
+			go func() {
+				defer wg.Done()
+				defer func() {
+					if recover() != nil {
+						atomic.AddInt32(&panicked, 1)
+					}
+				}()
+				h.Delete()
+				atomic.AddInt32(&deleted, 1)
+			}()
+		}
+		wg.Wait()
+		if deleted != 1 || panicked != n-1 {
+			t.Fatalf("got %d deletes and %d panics, want 1 and %d", deleted, panicked, n-1)
+		}
+	}
+}
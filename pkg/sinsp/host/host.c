@@ -0,0 +1,165 @@
+#include <dlfcn.h>
+#include <sched.h>
+#include <stdlib.h>
+
+#include "host.h"
+
+void* host_open(const char* path, char** err)
+{
+	// Go shared objects cannot be unloaded, so the handle is never closed
+	void* h = dlopen(path, RTLD_NOW | RTLD_LOCAL);
+	if (h == NULL)
+	{
+		*err = dlerror();
+	}
+	return h;
+}
+
+void* host_sym(void* h, const char* name)
+{
+	return dlsym(h, name);
+}
+
+uint32_t host_call_u32(void* f)
+{
+	return ((uint32_t (*)())f)();
+}
+
+char* host_call_str(void* f)
+{
+	return ((char* (*)())f)();
+}
+
+char* host_call_last_error(void* f, void* s)
+{
+	// plugin_get_last_error takes the plugin state, unless the plugin is built
+	// with the sinsp_lasterror_global tag. Passing it is harmless in that case.
+	return ((char* (*)(void*))f)(s);
+}
+
+void* host_call_init(void* f, const char* config, int32_t* rc)
+{
+	return ((void* (*)(const char*, int32_t*))f)(config, rc);
+}
+
+void host_call_destroy(void* f, void* s)
+{
+	((void (*)(void*))f)(s);
+}
+
+void* host_call_open(void* f, void* s, const char* params, int32_t* rc)
+{
+	return ((void* (*)(void*, const char*, int32_t*))f)(s, params, rc);
+}
+
+void host_call_close(void* f, void* s, void* o)
+{
+	((void (*)(void*, void*))f)(s, o);
+}
+
+int32_t host_call_next(void* f, void* s, void* o, uint8_t** data, uint32_t* datalen, uint64_t* ts)
+{
+	return ((int32_t (*)(void*, void*, uint8_t**, uint32_t*, uint64_t*))f)(s, o, data, datalen, ts);
+}
+
+int32_t host_call_next_batch(void* f, void* s, void* o, uint8_t** data, uint32_t* datalen)
+{
+	return ((int32_t (*)(void*, void*, uint8_t**, uint32_t*))f)(s, o, data, datalen);
+}
+
+char* host_call_event_to_string(void* f, const uint8_t* data, uint32_t datalen)
+{
+	return ((char* (*)(const uint8_t*, uint32_t))f)(data, datalen);
+}
+
+char* host_call_extract_str(void* f, void* s, uint64_t evtnum, uint32_t id, const char* arg, const uint8_t* data, uint32_t datalen)
+{
+	return ((char* (*)(void*, uint64_t, uint32_t, const char*, const uint8_t*, uint32_t))f)(s, evtnum, id, arg, data, datalen);
+}
+
+uint64_t host_call_extract_u64(void* f, void* s, uint64_t evtnum, uint32_t id, const char* arg, const uint8_t* data, uint32_t datalen, uint32_t* present)
+{
+	return ((uint64_t (*)(void*, uint64_t, uint32_t, const char*, const uint8_t*, uint32_t, uint32_t*))f)(s, evtnum, id, arg, data, datalen, present);
+}
+
+uint8_t* host_call_extract_buf(void* f, void* s, uint64_t evtnum, uint32_t id, const char* arg, const uint8_t* data, uint32_t datalen, uint32_t* reslen)
+{
+	return ((uint8_t* (*)(void*, uint64_t, uint32_t, const char*, const uint8_t*, uint32_t, uint32_t*))f)(s, evtnum, id, arg, data, datalen, reslen);
+}
+
+// States of the async extraction protocol, following libsinsp
+enum
+{
+	LS_INIT = 0,
+	LS_INPUT_READY,
+	LS_PROCESSING,
+	LS_DONE,
+	LS_SHUTDOWN_REQ,
+	LS_SHUTDOWN_DONE,
+};
+
+// host_cb_wait is called by the plugin worker to signal that the previous
+// request, if any, is done and to wait for the next one. It returns false
+// when the host requests the worker to shut down.
+static bool host_cb_wait(void* wait_ctx)
+{
+	host_async* a = (host_async*)wait_ctx;
+
+	if (a->started)
+	{
+		__atomic_store_n(&a->lock, LS_DONE, __ATOMIC_SEQ_CST);
+	}
+	a->started = true;
+
+	for (;;)
+	{
+		int32_t expected = LS_INPUT_READY;
+		if (__atomic_compare_exchange_n(&a->lock, &expected, LS_PROCESSING, false, __ATOMIC_SEQ_CST, __ATOMIC_SEQ_CST))
+		{
+			return true;
+		}
+		if (expected == LS_SHUTDOWN_REQ)
+		{
+			__atomic_store_n(&a->lock, LS_SHUTDOWN_DONE, __ATOMIC_SEQ_CST);
+			return false;
+		}
+		sched_yield();
+	}
+}
+
+host_async* host_async_new()
+{
+	host_async* a = (host_async*)calloc(1, sizeof(host_async));
+	if (a == NULL)
+	{
+		return NULL;
+	}
+	a->info.cb_wait = host_cb_wait;
+	a->info.wait_ctx = a;
+	return a;
+}
+
+int32_t host_async_register(void* f, void* s, host_async* a)
+{
+	return ((int32_t (*)(void*, void*))f)(s, &a->info);
+}
+
+// host_async_extract submits the request set in a->info and waits for its completion.
+void host_async_extract(host_async* a)
+{
+	__atomic_store_n(&a->lock, LS_INPUT_READY, __ATOMIC_SEQ_CST);
+	while (__atomic_load_n(&a->lock, __ATOMIC_SEQ_CST) != LS_DONE)
+	{
+		sched_yield();
+	}
+}
+
+// host_async_shutdown ends the protocol and waits for the worker to acknowledge it.
+void host_async_shutdown(host_async* a)
+{
+	__atomic_store_n(&a->lock, LS_SHUTDOWN_REQ, __ATOMIC_SEQ_CST);
+	while (__atomic_load_n(&a->lock, __ATOMIC_SEQ_CST) != LS_SHUTDOWN_DONE)
+	{
+		sched_yield();
+	}
+}
@@ -0,0 +1,557 @@
+// Package host loads a plugin shared object and calls its exported symbols
+// the way libsinsp does, so that the C ABI of a built plugin can be verified
+// without running a full libsinsp host.
+//
+// Intended usage as in the following example:
+//
+//     p, err := host.Load("./libmyplugin.so")
+//     if err != nil {
+//     	...
+//     }
+//     if missing := p.Missing(); len(missing) > 0 {
+//     	...
+//     }
+//     if err := p.Init(config); err != nil {
+//     	...
+//     }
+//     defer p.Destroy()
+//
+// Shared objects are never unloaded, since Go plugins do not support it.
+package host
+
+/*
+#cgo LDFLAGS: -ldl
+#include <stdlib.h>
+#include "host.h"
+*/
+import "C"
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"unsafe"
+
+	"github.com/ldegio/libsinsp-plugin-sdk-go/pkg/sinsp"
+	"github.com/ldegio/libsinsp-plugin-sdk-go/pkg/sinsp/batch"
+)
+
+// Symbols required by every plugin.
+var commonSymbols = []string{
+	"plugin_get_required_api_version",
+	"plugin_get_type",
+	"plugin_init",
+	"plugin_destroy",
+	"plugin_get_last_error",
+	"plugin_get_name",
+	"plugin_get_description",
+}
+
+// Symbols required by source plugins.
+var sourceSymbols = []string{
+	"plugin_get_id",
+	"plugin_open",
+	"plugin_close",
+	"plugin_next",
+	"plugin_event_to_string",
+}
+
+// Symbols required by extractor plugins.
+var extractorSymbols = []string{
+	"plugin_get_fields",
+}
+
+// Symbols that may be exported by any plugin.
+var optionalSymbols = []string{
+	"plugin_get_fields",
+	"plugin_next_batch",
+	"plugin_extract_str",
+	"plugin_extract_u64",
+	"plugin_extract_buf",
+	"plugin_register_async_extractor",
+}
+
+// Plugin is a plugin shared object loaded with Load().
+//
+// A Plugin is not safe for concurrent use.
+type Plugin struct {
+	path  string
+	syms  map[string]unsafe.Pointer
+	state unsafe.Pointer
+	async *C.host_async
+}
+
+// Event is an event read from a source plugin.
+type Event struct {
+	Ts   uint64
+	Data []byte
+}
+
+// Value is the value of a field extracted from an event.
+type Value struct {
+	Present bool
+	// Str is set for string fields.
+	Str string
+	// U64 is set for the fields extracted with plugin_extract_u64.
+	U64 uint64
+	// Buf is set for the fields extracted with plugin_extract_buf.
+	Buf []byte
+}
+
+// Load loads the plugin shared object at path and resolves its symbols.
+// Missing symbols are not an error, see Missing().
+func Load(path string) (*Plugin, error) {
+	cPath := C.CString(path)
+	defer C.free(unsafe.Pointer(cPath))
+
+	var cErr *C.char
+	h := C.host_open(cPath, &cErr)
+	if h == nil {
+		return nil, fmt.Errorf("host: cannot load %s: %s", path, C.GoString(cErr))
+	}
+
+	p := &Plugin{path: path, syms: make(map[string]unsafe.Pointer)}
+	for _, list := range [][]string{commonSymbols, sourceSymbols, extractorSymbols, optionalSymbols} {
+		for _, name := range list {
+			cName := C.CString(name)
+			if f := C.host_sym(h, cName); f != nil {
+				p.syms[name] = f
+			}
+			C.free(unsafe.Pointer(cName))
+		}
+	}
+	return p, nil
+}
+
+// Path returns the path the plugin was loaded from.
+func (p *Plugin) Path() string {
+	return p.path
+}
+
+// Has reports whether the plugin exports the symbol name.
+func (p *Plugin) Has(name string) bool {
+	_, ok := p.syms[name]
+	return ok
+}
+
+// Missing returns the symbols required by the plugin that it does not export,
+// according to its type and the types of its fields. Since plugin_extract_buf
+// is not part of every libsinsp ABI, it is never required: the buffer fields
+// of a plugin not exporting it are reported by UnavailableFields() instead.
+func (p *Plugin) Missing() []string {
+	required := append([]string{}, commonSymbols...)
+	switch p.Type() {
+	case sinsp.TypeSourcePlugin:
+		required = append(required, sourceSymbols...)
+	case sinsp.TypeExtractorPlugin:
+		required = append(required, extractorSymbols...)
+	}
+	if fields, err := p.Fields(); err == nil {
+		for _, f := range fields {
+			if name := extractSymbol(f.Type); name != "plugin_extract_buf" {
+				required = append(required, name)
+			}
+		}
+	}
+
+	var missing []string
+	seen := make(map[string]bool)
+	for _, name := range required {
+		if !seen[name] && !p.Has(name) {
+			missing = append(missing, name)
+		}
+		seen[name] = true
+	}
+	return missing
+}
+
+// UnavailableFields returns the names of the fields declared by the plugin that
+// cannot be extracted, since it does not export plugin_extract_buf for them.
+func (p *Plugin) UnavailableFields() []string {
+	if p.Has("plugin_extract_buf") {
+		return nil
+	}
+	fields, err := p.Fields()
+	if err != nil {
+		return nil
+	}
+	var names []string
+	for _, f := range fields {
+		if extractSymbol(f.Type) == "plugin_extract_buf" {
+			names = append(names, f.Name)
+		}
+	}
+	return names
+}
+
+// MissingOptional returns the optional symbols that the plugin does not export.
+func (p *Plugin) MissingOptional() []string {
+	var missing []string
+	for _, name := range optionalSymbols {
+		if name == "plugin_next_batch" && p.Type() != sinsp.TypeSourcePlugin {
+			continue
+		}
+		if !p.Has(name) {
+			missing = append(missing, name)
+		}
+	}
+	return missing
+}
+
+// extractSymbol returns the symbol serving the fields of type t.
+func extractSymbol(t sinsp.FieldType) string {
+	switch pt, _ := sinsp.FieldParamType(t); pt {
+	case sinsp.ParamTypeCharBuf:
+		return "plugin_extract_str"
+	case sinsp.ParamTypeByteBuf,
+		sinsp.ParamTypeIpv4Addr, sinsp.ParamTypeIpv6Addr, sinsp.ParamTypeIpAddr,
+		sinsp.ParamTypeIpv4Net, sinsp.ParamTypeIpv6Net, sinsp.ParamTypeIpNet:
+		return "plugin_extract_buf"
+	default:
+		return "plugin_extract_u64"
+	}
+}
+
+func (p *Plugin) sym(name string) (unsafe.Pointer, error) {
+	if f, ok := p.syms[name]; ok {
+		return f, nil
+	}
+	return nil, sinsp.NewError(sinsp.ScapNotSupported, "host: %s is not exported by %s", name, p.path)
+}
+
+func (p *Plugin) u32(name string) uint32 {
+	if f, ok := p.syms[name]; ok {
+		return uint32(C.host_call_u32(f))
+	}
+	return 0
+}
+
+func (p *Plugin) str(name string) string {
+	if f, ok := p.syms[name]; ok {
+		return C.GoString(C.host_call_str(f))
+	}
+	return ""
+}
+
+// Type returns the type of the plugin, or zero if plugin_get_type is not exported.
+func (p *Plugin) Type() uint32 {
+	return p.u32("plugin_get_type")
+}
+
+// ID returns the ID of the plugin.
+func (p *Plugin) ID() uint32 {
+	return p.u32("plugin_get_id")
+}
+
+// Name returns the name of the plugin.
+func (p *Plugin) Name() string {
+	return p.str("plugin_get_name")
+}
+
+// Description returns the description of the plugin.
+func (p *Plugin) Description() string {
+	return p.str("plugin_get_description")
+}
+
+// RequiredAPIVersion returns the version of the plugin API required by the plugin.
+func (p *Plugin) RequiredAPIVersion() string {
+	return p.str("plugin_get_required_api_version")
+}
+
+// Fields returns the fields of the plugin, or an error if plugin_get_fields
+// fails or returns invalid JSON. A plugin not exporting plugin_get_fields has no fields.
+func (p *Plugin) Fields() ([]sinsp.FieldEntry, error) {
+	f, ok := p.syms["plugin_get_fields"]
+	if !ok {
+		return nil, nil
+	}
+
+	s := C.host_call_str(f)
+	if s == nil {
+		return nil, fmt.Errorf("host: plugin_get_fields failed: %s", p.lastError(nil))
+	}
+	var fields []sinsp.FieldEntry
+	if err := json.Unmarshal([]byte(C.GoString(s)), &fields); err != nil {
+		return nil, fmt.Errorf("host: plugin_get_fields returned invalid JSON: %s", err.Error())
+	}
+	return fields, nil
+}
+
+// LastError returns the last error reported by the plugin for its state.
+func (p *Plugin) LastError() string {
+	return p.lastError(p.state)
+}
+
+// lastError returns the last error reported by the plugin for state. Errors
+// raised by the symbols that take no state are reported for a nil state.
+func (p *Plugin) lastError(state unsafe.Pointer) string {
+	if f, ok := p.syms["plugin_get_last_error"]; ok {
+		return C.GoString(C.host_call_last_error(f, state))
+	}
+	return ""
+}
+
+// callError returns the error for a call to name that returned rc.
+func (p *Plugin) callError(name string, rc int32) error {
+	msg := p.LastError()
+	if msg == "" {
+		msg = sinsp.CodeString(rc)
+	}
+	return sinsp.NewError(rc, "host: %s failed: %s", name, msg)
+}
+
+// Init initializes the plugin with the given configuration.
+// If it fails, Destroy() must still be called if the plugin returned a state.
+func (p *Plugin) Init(config string) error {
+	f, err := p.sym("plugin_init")
+	if err != nil {
+		return err
+	}
+
+	cConfig := C.CString(config)
+	defer C.free(unsafe.Pointer(cConfig))
+
+	var rc C.int32_t
+	p.state = C.host_call_init(f, cConfig, &rc)
+	if int32(rc) != sinsp.ScapSuccess {
+		return p.callError("plugin_init", int32(rc))
+	}
+	if p.state == nil {
+		return fmt.Errorf("host: plugin_init returned a nil state")
+	}
+	return nil
+}
+
+// Destroy ends the async extraction protocol, if enabled, and destroys the plugin state.
+func (p *Plugin) Destroy() {
+	if p.async != nil {
+		C.host_async_shutdown(p.async)
+	}
+	if f, ok := p.syms["plugin_destroy"]; ok && p.state != nil {
+		C.host_call_destroy(f, p.state)
+	}
+	if p.async != nil {
+		C.free(unsafe.Pointer(p.async))
+		p.async = nil
+	}
+	p.state = nil
+}
+
+// EnableAsync registers the async extractor of the plugin, so that Extract()
+// follows the async extraction protocol, waiting for the results through cb_wait.
+//
+// The buffer fields are extracted asynchronously as well if the plugin serves them,
+// reading their length from res_u64 (see sinsp.StartAsyncBufExtractors()), and
+// synchronously with plugin_extract_buf if it answers ScapNotSupported.
+func (p *Plugin) EnableAsync() error {
+	name := "plugin_register_async_extractor"
+	f, err := p.sym(name)
+	if err != nil {
+		return err
+	}
+
+	a := C.host_async_new()
+	if a == nil {
+		return fmt.Errorf("host: cannot allocate the async extractor info")
+	}
+	if rc := int32(C.host_async_register(f, p.state, a)); rc != sinsp.ScapSuccess {
+		C.free(unsafe.Pointer(a))
+		return p.callError(name, rc)
+	}
+	p.async = a
+	return nil
+}
+
+// Capture is a source plugin instance opened with Open().
+type Capture struct {
+	p      *Plugin
+	oState unsafe.Pointer
+}
+
+// Open opens the source plugin with the given parameters.
+func (p *Plugin) Open(params string) (*Capture, error) {
+	f, err := p.sym("plugin_open")
+	if err != nil {
+		return nil, err
+	}
+
+	cParams := C.CString(params)
+	defer C.free(unsafe.Pointer(cParams))
+
+	var rc C.int32_t
+	oState := C.host_call_open(f, p.state, cParams, &rc)
+	if int32(rc) != sinsp.ScapSuccess {
+		return nil, p.callError("plugin_open", int32(rc))
+	}
+	if oState == nil {
+		return nil, fmt.Errorf("host: plugin_open returned a nil state")
+	}
+	return &Capture{p: p, oState: oState}, nil
+}
+
+// Close closes the capture.
+func (c *Capture) Close() {
+	if f, ok := c.p.syms["plugin_close"]; ok {
+		C.host_call_close(f, c.p.state, c.oState)
+	}
+}
+
+// Next reads the next event with plugin_next. It returns an error matching
+// sinsp.ErrTimeout or sinsp.ErrEOF with errors.Is() when the plugin returns
+// ScapTimeout or ScapEOF.
+func (c *Capture) Next() (*Event, error) {
+	f, err := c.p.sym("plugin_next")
+	if err != nil {
+		return nil, err
+	}
+
+	var data *C.uint8_t
+	var datalen C.uint32_t
+	var ts C.uint64_t
+	if rc := int32(C.host_call_next(f, c.p.state, c.oState, &data, &datalen, &ts)); rc != sinsp.ScapSuccess {
+		return nil, c.p.callError("plugin_next", rc)
+	}
+	return &Event{Ts: uint64(ts), Data: C.GoBytes(unsafe.Pointer(data), C.int(datalen))}, nil
+}
+
+// NextBatch reads the next batch of events with plugin_next_batch. Errors are
+// returned as in Next(), or if the batch is not correctly framed.
+func (c *Capture) NextBatch() ([]Event, error) {
+	f, err := c.p.sym("plugin_next_batch")
+	if err != nil {
+		return nil, err
+	}
+
+	var data *C.uint8_t
+	var datalen C.uint32_t
+	if rc := int32(C.host_call_next_batch(f, c.p.state, c.oState, &data, &datalen)); rc != sinsp.ScapSuccess {
+		return nil, c.p.callError("plugin_next_batch", rc)
+	}
+
+	var evts []Event
+	it := batch.NewIterator(C.GoBytes(unsafe.Pointer(data), C.int(datalen)))
+	for it.Next() {
+		evts = append(evts, Event{Ts: it.Ts(), Data: it.Data()})
+	}
+	if it.Err() != nil {
+		return evts, fmt.Errorf("host: plugin_next_batch returned an invalid batch: %s", it.Err().Error())
+	}
+	return evts, nil
+}
+
+// EventToString returns the string representation of the event data.
+func (p *Plugin) EventToString(data []byte) (string, error) {
+	f, err := p.sym("plugin_event_to_string")
+	if err != nil {
+		return "", err
+	}
+
+	cData := cBytes(data)
+	defer C.free(unsafe.Pointer(cData))
+
+	s := C.host_call_event_to_string(f, cData, C.uint32_t(len(data)))
+	if s == nil {
+		return "", fmt.Errorf("host: plugin_event_to_string failed: %s", p.lastError(nil))
+	}
+	return C.GoString(s), nil
+}
+
+// Extract extracts the field with the given ID from the event data, following the
+// async extraction protocol if enabled with EnableAsync(). The field type selects
+// the extraction function used, see plugin_get_fields. A nil arg means no argument.
+func (p *Plugin) Extract(evtnum uint64, id uint32, fieldType sinsp.FieldType, arg *string, data []byte) (v Value, err error) {
+	pt, ok := sinsp.FieldParamType(fieldType)
+	if !ok {
+		return v, fmt.Errorf("host: invalid field type %q", fieldType)
+	}
+	name := extractSymbol(fieldType)
+	f, err := p.sym(name)
+	if err != nil {
+		return v, err
+	}
+
+	cData := cBytes(data)
+	defer C.free(unsafe.Pointer(cData))
+	var cArg *C.char
+	if arg != nil {
+		cArg = C.CString(*arg)
+		defer C.free(unsafe.Pointer(cArg))
+	}
+
+	if p.async != nil {
+		v, err = p.extractAsync(evtnum, id, pt, name, cArg, cData, uint32(len(data)))
+		if err != errAsyncBufNotSupported {
+			return v, err
+		}
+	}
+
+	evt, cID, datalen := C.uint64_t(evtnum), C.uint32_t(id), C.uint32_t(len(data))
+	switch name {
+	case "plugin_extract_str":
+		if s := C.host_call_extract_str(f, p.state, evt, cID, cArg, cData, datalen); s != nil {
+			v.Present = true
+			v.Str = C.GoString(s)
+		}
+	case "plugin_extract_buf":
+		var reslen C.uint32_t
+		if b := C.host_call_extract_buf(f, p.state, evt, cID, cArg, cData, datalen, &reslen); b != nil {
+			v.Present = true
+			v.Buf = C.GoBytes(unsafe.Pointer(b), C.int(reslen))
+		}
+	default:
+		var present C.uint32_t
+		v.U64 = uint64(C.host_call_extract_u64(f, p.state, evt, cID, cArg, cData, datalen, &present))
+		v.Present = present != 0
+	}
+	return v, nil
+}
+
+// errAsyncBufNotSupported is returned by extractAsync() when the async extractor
+// leaves the buffer fields to plugin_extract_buf.
+var errAsyncBufNotSupported = errors.New("host: buffer fields not served by the async extractor")
+
+// extractAsync submits an extraction request to the async extractor and waits for its result.
+func (p *Plugin) extractAsync(evtnum uint64, id uint32, pt uint32, name string, arg *C.char, data *C.uint8_t, datalen uint32) (v Value, err error) {
+	info := &p.async.info
+	info.evtnum = C.uint64_t(evtnum)
+	info.id = C.uint32_t(id)
+	info.ftype = C.uint32_t(pt)
+	info.arg = arg
+	info.data = (*C.char)(unsafe.Pointer(data))
+	info.datalen = C.uint32_t(datalen)
+	info.field_present = 0
+	info.res_str = nil
+	info.res_u64 = 0
+	C.host_async_extract(p.async)
+
+	if rc := int32(info.rc); rc != sinsp.ScapSuccess {
+		if rc == sinsp.ScapNotSupported && name == "plugin_extract_buf" {
+			return v, errAsyncBufNotSupported
+		}
+		return v, p.callError(name+" (async)", rc)
+	}
+	switch name {
+	case "plugin_extract_str":
+		if info.res_str != nil {
+			v.Present = true
+			v.Str = C.GoString(info.res_str)
+		}
+	case "plugin_extract_buf":
+		if info.res_str != nil {
+			v.Present = true
+			v.Buf = C.GoBytes(unsafe.Pointer(info.res_str), C.int(info.res_u64))
+		}
+	default:
+		v.Present = info.field_present != 0
+		v.U64 = uint64(info.res_u64)
+	}
+	return v, nil
+}
+
+// cBytes copies b into C memory, always allocating at least one byte
+// so that empty events are passed as valid pointers.
+func cBytes(b []byte) *C.uint8_t {
+	if len(b) == 0 {
+		return (*C.uint8_t)(C.calloc(1, 1))
+	}
+	return (*C.uint8_t)(C.CBytes(b))
+}
@@ -0,0 +1,57 @@
+#ifndef SINSP_HOST_H
+#define SINSP_HOST_H
+
+#include <stdint.h>
+#include <stdbool.h>
+
+typedef bool (*cb_wait_t)(void* wait_ctx);
+
+// Layout of the structure shared with plugin_register_async_extractor.
+typedef struct async_extractor_info
+{
+	uint64_t evtnum;
+	uint32_t id;
+	uint32_t ftype;
+	char* arg;
+	char* data;
+	uint32_t datalen;
+	uint32_t field_present;
+	char* res_str;
+	uint64_t res_u64;
+	int32_t rc;
+	cb_wait_t cb_wait;
+	void* wait_ctx;
+} async_extractor_info;
+
+// State of the async extraction protocol, shared between the host
+// and the plugin worker through cb_wait.
+typedef struct host_async
+{
+	async_extractor_info info;
+	int32_t lock;
+	bool started;
+} host_async;
+
+void* host_open(const char* path, char** err);
+void* host_sym(void* h, const char* name);
+
+uint32_t host_call_u32(void* f);
+char* host_call_str(void* f);
+char* host_call_last_error(void* f, void* s);
+void* host_call_init(void* f, const char* config, int32_t* rc);
+void host_call_destroy(void* f, void* s);
+void* host_call_open(void* f, void* s, const char* params, int32_t* rc);
+void host_call_close(void* f, void* s, void* o);
+int32_t host_call_next(void* f, void* s, void* o, uint8_t** data, uint32_t* datalen, uint64_t* ts);
+int32_t host_call_next_batch(void* f, void* s, void* o, uint8_t** data, uint32_t* datalen);
+char* host_call_event_to_string(void* f, const uint8_t* data, uint32_t datalen);
+char* host_call_extract_str(void* f, void* s, uint64_t evtnum, uint32_t id, const char* arg, const uint8_t* data, uint32_t datalen);
+uint64_t host_call_extract_u64(void* f, void* s, uint64_t evtnum, uint32_t id, const char* arg, const uint8_t* data, uint32_t datalen, uint32_t* present);
+uint8_t* host_call_extract_buf(void* f, void* s, uint64_t evtnum, uint32_t id, const char* arg, const uint8_t* data, uint32_t datalen, uint32_t* reslen);
+
+host_async* host_async_new();
+int32_t host_async_register(void* f, void* s, host_async* a);
+void host_async_extract(host_async* a);
+void host_async_shutdown(host_async* a);
+
+#endif
@@ -0,0 +1,103 @@
+// Package exports exports the C symbols that are common to all the plugin types,
+// serving them with the sinsp.Registration set by the source or extractor package.
+// A plugin imports exactly one of those, so the symbols are exported once.
+package exports
+
+/*
+#include <stdint.h>
+*/
+import "C"
+import (
+	"unsafe"
+
+	"github.com/ldegio/libsinsp-plugin-sdk-go/pkg/sinsp"
+)
+
+// Registration serves the exported symbols. It is set by the Register
+// function of the source or extractor package.
+var Registration *sinsp.Registration
+
+// Register sets the registration serving the exported symbols, panicking
+// if pkg has already set one.
+func Register(pkg string, r func() *sinsp.Registration) {
+	if Registration != nil {
+		panic(pkg + ": Register called twice")
+	}
+	Registration = r()
+}
+
+//export plugin_get_type
+func plugin_get_type() uint32 {
+	return Registration.Type()
+}
+
+//export plugin_get_id
+func plugin_get_id() uint32 {
+	return Registration.ID()
+}
+
+//export plugin_get_name
+func plugin_get_name() *C.char {
+	return (*C.char)(unsafe.Pointer(Registration.Name()))
+}
+
+//export plugin_get_description
+func plugin_get_description() *C.char {
+	return (*C.char)(unsafe.Pointer(Registration.Description()))
+}
+
+//export plugin_get_required_api_version
+func plugin_get_required_api_version() *C.char {
+	return (*C.char)(unsafe.Pointer(Registration.RequiredAPIVersion()))
+}
+
+//export plugin_get_fields
+func plugin_get_fields() *C.char {
+	return (*C.char)(unsafe.Pointer(Registration.Fields()))
+}
+
+//export plugin_init
+func plugin_init(config *C.char, rc *int32) unsafe.Pointer {
+	return Registration.Init((*byte)(unsafe.Pointer(config)), rc)
+}
+
+//export plugin_destroy
+func plugin_destroy(pState unsafe.Pointer) {
+	Registration.Destroy(pState)
+}
+
+//export plugin_extract_str
+func plugin_extract_str(pState unsafe.Pointer, evtnum uint64, id uint32, arg *byte, data *byte, datalen uint32) *byte {
+	return sinsp.ExtractStr(pState, evtnum, id, arg, data, datalen)
+}
+
+//export plugin_extract_u64
+func plugin_extract_u64(pState unsafe.Pointer, evtnum uint64, id uint32, arg *byte, data *byte, datalen uint32, fieldPresent *uint32) uint64 {
+	return sinsp.ExtractU64(pState, evtnum, id, arg, data, datalen, fieldPresent)
+}
+
+// plugin_extract_buf is an extension of this SDK, only called by the hosts that know about it.
+
+//export plugin_extract_buf
+func plugin_extract_buf(pState unsafe.Pointer, evtnum uint64, id uint32, arg *byte, data *byte, datalen uint32, reslen *uint32) *byte {
+	return sinsp.ExtractBuf(pState, evtnum, id, arg, data, datalen, reslen)
+}
+
+// Entrypoints sets the entry points exported by this package into e.
+func Entrypoints(e *sinsp.Entrypoints) {
+	e.GetType = plugin_get_type
+	e.GetID = plugin_get_id
+	e.GetName = func() *byte { return (*byte)(unsafe.Pointer(plugin_get_name())) }
+	e.GetDescription = func() *byte { return (*byte)(unsafe.Pointer(plugin_get_description())) }
+	e.GetRequiredAPIVersion = func() *byte { return (*byte)(unsafe.Pointer(plugin_get_required_api_version())) }
+	e.GetFields = func() *byte { return (*byte)(unsafe.Pointer(plugin_get_fields())) }
+	e.GetLastError = func(pState unsafe.Pointer) *byte { return (*byte)(unsafe.Pointer(lastError(pState))) }
+	e.ClearLastError = clearLastError
+	e.Init = func(config *byte, rc *int32) unsafe.Pointer {
+		return plugin_init((*C.char)(unsafe.Pointer(config)), rc)
+	}
+	e.Destroy = plugin_destroy
+	e.ExtractStr = plugin_extract_str
+	e.ExtractU64 = plugin_extract_u64
+	e.ExtractBuf = plugin_extract_buf
+}
@@ -0,0 +1,28 @@
+//go:build !sinsp_lasterror_global
+// +build !sinsp_lasterror_global
+
+package exports
+
+import "C"
+import (
+	"unsafe"
+
+	"github.com/ldegio/libsinsp-plugin-sdk-go/pkg/sinsp"
+)
+
+// plugin_get_last_error reports the last error of the given plugin state.
+// Errors raised where no state is available (e.g. in plugin_get_fields) are
+// reported when pState is nil.
+
+//export plugin_get_last_error
+func plugin_get_last_error(pState unsafe.Pointer) *C.char {
+	return (*C.char)(unsafe.Pointer(sinsp.LastErrorStr(pState)))
+}
+
+func lastError(pState unsafe.Pointer) *C.char {
+	return plugin_get_last_error(pState)
+}
+
+func clearLastError(pState unsafe.Pointer) {
+	sinsp.SetLastError(pState, nil)
+}
@@ -0,0 +1,29 @@
+//go:build sinsp_lasterror_global
+// +build sinsp_lasterror_global
+
+package exports
+
+import "C"
+import (
+	"unsafe"
+
+	"github.com/ldegio/libsinsp-plugin-sdk-go/pkg/sinsp"
+)
+
+// plugin_get_last_error takes no context argument, so it reports the error
+// most recently set on any state, whichever plugin instance it belongs to.
+// It is only exported when building with the sinsp_lasterror_global tag,
+// for hosts that do not pass the plugin state.
+
+//export plugin_get_last_error
+func plugin_get_last_error() *C.char {
+	return (*C.char)(unsafe.Pointer(sinsp.LastErrorStr(nil)))
+}
+
+func lastError(pState unsafe.Pointer) *C.char {
+	return plugin_get_last_error()
+}
+
+func clearLastError(pState unsafe.Pointer) {
+	sinsp.SetLastError(nil, nil)
+}
@@ -0,0 +1,11 @@
+//go:build !sinsp_debug
+// +build !sinsp_debug
+
+package sinsp
+
+// debugMode is true when the SDK is built with the sinsp_debug tag.
+const debugMode = false
+
+func debugStack() []byte {
+	return nil
+}
@@ -0,0 +1,70 @@
+package sinsp
+
+import (
+	"fmt"
+	"runtime/debug"
+	"sync/atomic"
+	"unsafe"
+)
+
+// PanicError is the error stored as the last error of a state when a panic
+// is recovered at the C boundary.
+type PanicError struct {
+	Value interface{}
+	Stack []byte
+}
+
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("panic: %v\n%s", e.Value, e.Stack)
+}
+
+var panicLimit uint32
+
+// SetPanicLimit sets the number of recovered panics after which a state container
+// is disabled, meaning that CheckEnabled() reports an error for it.
+// Zero, the default, never disables a state container.
+func SetPanicLimit(n uint32) {
+	atomic.StoreUint32(&panicLimit, n)
+}
+
+// CheckEnabled returns an error if p has been disabled after recovering too many
+// panics (see SetPanicLimit()), assuming p is a state container created with NewStateContainer().
+// A nil p is always enabled.
+func CheckEnabled(p unsafe.Pointer) error {
+	if p == nil {
+		return nil
+	}
+	limit := atomic.LoadUint32(&panicLimit)
+	if n := panics(p); limit > 0 && n >= limit {
+		return NewError(ScapFailure, "plugin disabled after %d panics", n)
+	}
+	return nil
+}
+
+// Recover recovers a panic, if any, stores it as the last error of p and sets
+// *rc to ScapFailure, if rc is not nil. p may be nil if no state is available.
+// It must be deferred directly by every function called from C, since a
+// panic crossing the C boundary takes down the whole host process.
+//
+// Intended usage as in the following example:
+//
+//     //export plugin_next
+//     func plugin_next(pState unsafe.Pointer, oState unsafe.Pointer, data **byte, datalen *uint32, ts *uint64) (res int32) {
+//     	defer sinsp.Recover(pState, &res)
+//     	...
+//     }
+//
+func Recover(p unsafe.Pointer, rc *int32) {
+	r := recover()
+	if r == nil {
+		return
+	}
+
+	SetLastError(p, &PanicError{Value: r, Stack: debug.Stack()})
+	if p != nil {
+		addPanic(p)
+	}
+	if rc != nil {
+		*rc = ScapFailure
+	}
+}
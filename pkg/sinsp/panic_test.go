@@ -0,0 +1,127 @@
+package sinsp
+
+import (
+	"strings"
+	"testing"
+	"unsafe"
+)
+
+// boundary simulates a function called from C, recovering the panics of f.
+func boundary(p unsafe.Pointer, f func()) (rc int32) {
+	defer Recover(p, &rc)
+	f()
+	return ScapSuccess
+}
+
+func TestRecover(t *testing.T) {
+	pState := NewStateContainer()
+	defer Free(pState)
+
+	if rc := boundary(pState, func() {}); rc != ScapSuccess || LastError(pState) != nil || panics(pState) != 0 {
+		t.Fatalf("no panic: got (%d, %v, %d)", rc, LastError(pState), panics(pState))
+	}
+
+	rc := boundary(pState, func() { panic("boom") })
+	if rc != ScapFailure {
+		t.Fatalf("got code %d, want %d", rc, ScapFailure)
+	}
+	err := LastError(pState)
+	if err == nil || !strings.HasPrefix(err.Error(), "panic: boom\n") || !strings.Contains(err.Error(), "TestRecover") {
+		t.Fatalf("got last error %v, want the panic and its stack", err)
+	}
+	if n := panics(pState); n != 1 {
+		t.Fatalf("got %d panics, want 1", n)
+	}
+
+	// Without state nor return code
+	func() {
+		defer Recover(nil, nil)
+		panic("no state")
+	}()
+}
+
+func TestRecoverExtract(t *testing.T) {
+	r, err := NewFieldRegistry([]Field{
+		StrField("test.panic", "Panics", func(req *ExtractRequest) (string, bool, error) {
+			panic("extract")
+		}),
+	})
+	if err != nil {
+		t.Fatalf("NewFieldRegistry: %s", err)
+	}
+	pState := NewStateContainer()
+	defer Free(pState)
+	SetFields(pState, r)
+	data := []byte("data")
+
+	if res := ExtractStr(pState, 1, 0, nil, &data[0], uint32(len(data))); res != nil {
+		t.Fatalf("ExtractStr: got a result from a panicking field")
+	}
+	if err := LastError(pState); err == nil || !strings.HasPrefix(err.Error(), "panic: extract") {
+		t.Fatalf("ExtractStr: got last error %v, want the panic", err)
+	}
+
+	nextf := func(plgState unsafe.Pointer, openState unsafe.Pointer, data *[]byte, ts *uint64) int32 {
+		panic("next")
+	}
+	if rc := callNext(nextf, pState, nil, nil, nil); rc != ScapFailure {
+		t.Fatalf("callNext: got code %d, want %d", rc, ScapFailure)
+	}
+	if err := LastError(pState); err == nil || !strings.HasPrefix(err.Error(), "panic: next") {
+		t.Fatalf("callNext: got last error %v, want the panic", err)
+	}
+	if n := panics(pState); n != 2 {
+		t.Fatalf("got %d panics, want 2", n)
+	}
+}
+
+func TestCheckEnabled(t *testing.T) {
+	SetPanicLimit(2)
+	defer SetPanicLimit(0)
+
+	pState := NewStateContainer()
+	defer Free(pState)
+	other := NewStateContainer()
+	defer Free(other)
+
+	if err := CheckEnabled(nil); err != nil {
+		t.Fatalf("nil state: got %s", err)
+	}
+	for i := 0; i < 2; i++ {
+		if err := CheckEnabled(pState); err != nil {
+			t.Fatalf("after %d panics: got %s", i, err)
+		}
+		boundary(pState, func() { panic("boom") })
+	}
+
+	err := CheckEnabled(pState)
+	if err == nil || err.Error() != "plugin disabled after 2 panics" || ErrorCode(err) != ScapFailure {
+		t.Fatalf("after 2 panics: got %v, want the state disabled", err)
+	}
+	if err := CheckEnabled(other); err != nil {
+		t.Fatalf("other state: got %s", err)
+	}
+
+	// The extractions on a disabled state fail without calling the extract function
+	called := false
+	r, _ := NewFieldRegistry([]Field{
+		StrField("test.field", "Field", func(req *ExtractRequest) (string, bool, error) {
+			called = true
+			return "value", true, nil
+		}),
+	})
+	SetFields(pState, r)
+	data := []byte("data")
+	if res := ExtractStr(pState, 1, 0, nil, &data[0], uint32(len(data))); res != nil || called {
+		t.Fatalf("ExtractStr: extracted the field on a disabled state")
+	}
+	if err := LastError(pState); err == nil || err.Error() != "plugin disabled after 2 panics" {
+		t.Fatalf("ExtractStr: got last error %v", err)
+	}
+
+	// Zero never disables a state
+	SetPanicLimit(0)
+	if err := CheckEnabled(pState); err != nil {
+		t.Fatalf("no limit: got %s", err)
+	}
+}
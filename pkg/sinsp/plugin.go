@@ -0,0 +1,70 @@
+package sinsp
+
+// DefaultRequiredAPIVersion is the plugin API version reported by plugins
+// that do not set PluginInfo.RequiredAPIVersion.
+const DefaultRequiredAPIVersion = "1.0.0"
+
+// PluginInfo holds the static metadata that a plugin exposes to sinsp.
+type PluginInfo struct {
+	ID                 uint32
+	Name               string
+	Description        string
+	RequiredAPIVersion string
+}
+
+// Lifecycle is the part of the plugin interfaces that is common to all the plugin
+// types, served by a Registration.
+type Lifecycle interface {
+	// Info returns the plugin metadata. It must not depend on Init.
+	Info() *PluginInfo
+	// Init initializes the plugin with the given configuration string.
+	Init(config string) error
+	// Destroy releases the plugin resources. No other method is called after it.
+	Destroy()
+}
+
+// SourcePlugin is the interface to be implemented by source plugins that want
+// the SDK to take care of the C ABI (see the source package).
+//
+// A new SourcePlugin value is obtained for every plugin_init call, so each
+// instance can safely keep its own state. Info and EventToString may also be
+// invoked on an instance that has never been initialized.
+type SourcePlugin interface {
+	Lifecycle
+	// Open starts a new capture with the given parameters. The returned value
+	// is passed back to Next and Close.
+	Open(params string) (interface{}, error)
+	// Close terminates the capture identified by openState.
+	Close(openState interface{})
+	// Next returns the next event of the capture identified by openState.
+	// It returns ErrTimeout if no event is available yet and ErrEOF when
+	// the capture is over. See ToNextFunc() for how events are validated.
+	Next(openState interface{}) (Event, error)
+	// EventToString returns a printable representation of the event data.
+	EventToString(data []byte) (string, error)
+}
+
+// Extractor is implemented by plugins that expose fields.
+// Source plugins may implement it as well as ExtractorPlugin.
+type Extractor interface {
+	// Fields returns the fields exposed by the plugin. It must return
+	// the same fields, in the same order, on every instance of the plugin.
+	Fields() []Field
+}
+
+// ExtractorPlugin is the interface to be implemented by extractor plugins that want
+// the SDK to take care of the C ABI (see the extractor package).
+//
+// A new ExtractorPlugin value is obtained for every plugin_init call, so each
+// instance can safely keep its own state. Info and Fields may also be
+// invoked on an instance that has never been initialized.
+type ExtractorPlugin interface {
+	Lifecycle
+	Extractor
+}
+
+// BatchConfigurer is optionally implemented by source plugins to configure how
+// the events of each capture are batched (see NextBatch()).
+type BatchConfigurer interface {
+	BatchOptions() BatchOptions
+}
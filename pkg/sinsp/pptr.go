@@ -1,7 +0,0 @@
-package sinsp
-
-import (
-	"sync"
-)
-
-var peristentPtrs = &sync.Map{}
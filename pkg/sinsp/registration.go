@@ -0,0 +1,182 @@
+package sinsp
+
+/*
+#include <stdlib.h>
+*/
+import "C"
+import (
+	"sync/atomic"
+	"unsafe"
+)
+
+// Registration serves the symbols that are common to all the plugin types, such as
+// plugin_get_name, plugin_get_fields, plugin_init and plugin_destroy, for the plugins
+// created by a factory. It is what the source and extractor packages are built on,
+// leaving them to export the symbols and to serve the ones specific to their type.
+//
+// The plugin fields, if the plugins implement Extractor, are validated with
+// MarshalFields() when the Registration is created. Invalid fields are reported by
+// plugin_get_fields and plugin_init rather than by panicking while the library is
+// being loaded.
+type Registration struct {
+	pluginType uint32
+	factory    func() Lifecycle
+	proto      Lifecycle
+
+	id          uint32
+	name        *C.char
+	description *C.char
+	apiVersion  *C.char
+	fieldsJSON  *C.char
+	fieldsErr   error
+
+	// number of plugin states returned by Init() and not yet destroyed
+	instances int32
+}
+
+// registeredPlugin is the context of the plugin states created by a Registration.
+type registeredPlugin struct {
+	plugin Lifecycle
+}
+
+// NewRegistration returns a Registration for the plugins of type pluginType created by factory.
+// The factory is called once to obtain a prototype instance, which is never initialized and
+// serves the metadata of the plugin, and then once for every plugin_init call.
+func NewRegistration(pluginType uint32, factory func() Lifecycle) *Registration {
+	r := &Registration{pluginType: pluginType, factory: factory, proto: factory()}
+
+	info := r.proto.Info()
+	apiVersion := info.RequiredAPIVersion
+	if apiVersion == "" {
+		apiVersion = DefaultRequiredAPIVersion
+	}
+	r.id = info.ID
+	r.name = C.CString(info.Name)
+	r.description = C.CString(info.Description)
+	r.apiVersion = C.CString(apiVersion)
+
+	var fields *FieldRegistry
+	if fields, r.fieldsErr = newPluginFields(r.proto); r.fieldsErr == nil {
+		var b []byte
+		if b, r.fieldsErr = MarshalFields(info.Name, fields.Entries()); r.fieldsErr == nil {
+			r.fieldsJSON = C.CString(string(b))
+		}
+	}
+	return r
+}
+
+func newPluginFields(p Lifecycle) (*FieldRegistry, error) {
+	var fields []Field
+	if e, ok := p.(Extractor); ok {
+		fields = e.Fields()
+	}
+	return NewFieldRegistry(fields)
+}
+
+// Type serves plugin_get_type.
+func (r *Registration) Type() uint32 {
+	return r.pluginType
+}
+
+// ID serves plugin_get_id.
+func (r *Registration) ID() uint32 {
+	return r.id
+}
+
+// Name serves plugin_get_name.
+func (r *Registration) Name() *byte {
+	return (*byte)(unsafe.Pointer(r.name))
+}
+
+// Description serves plugin_get_description.
+func (r *Registration) Description() *byte {
+	return (*byte)(unsafe.Pointer(r.description))
+}
+
+// RequiredAPIVersion serves plugin_get_required_api_version.
+func (r *Registration) RequiredAPIVersion() *byte {
+	return (*byte)(unsafe.Pointer(r.apiVersion))
+}
+
+// Fields serves plugin_get_fields.
+func (r *Registration) Fields() *byte {
+	if r.fieldsErr != nil {
+		HandleError(nil, r.fieldsErr)
+		return nil
+	}
+	return (*byte)(unsafe.Pointer(r.fieldsJSON))
+}
+
+// Proto returns the prototype instance of the plugin, which is never initialized.
+func (r *Registration) Proto() Lifecycle {
+	return r.proto
+}
+
+// Plugin returns the plugin instance of pState, a plugin state returned by Init().
+func (r *Registration) Plugin(pState unsafe.Pointer) Lifecycle {
+	return ContextValue(pState).(*registeredPlugin).plugin
+}
+
+// Init serves plugin_init, creating and initializing a new plugin instance.
+// Plugins implementing Decoder get a decode cache bound to their state.
+func (r *Registration) Init(config *byte, rc *int32) (pState unsafe.Pointer) {
+	// On failure the state is returned anyway, so that the error can be
+	// retrieved with plugin_get_last_error before plugin_destroy is called.
+	pState = NewStateContainer()
+	atomic.AddInt32(&r.instances, 1)
+	defer Recover(pState, rc)
+	if r.fieldsErr != nil {
+		*rc = HandleError(pState, r.fieldsErr)
+		return pState
+	}
+
+	p := r.factory()
+	fields, err := newPluginFields(p)
+	if err == nil {
+		err = p.Init(goString(config))
+	}
+	if err != nil {
+		*rc = HandleError(pState, err)
+		return pState
+	}
+
+	SetContextValue(pState, &registeredPlugin{plugin: p})
+	SetFields(pState, fields)
+	if d, ok := p.(Decoder); ok {
+		SetDecodeCache(pState, NewDecodeCache(DefaultDecodeCacheSize, d.Decode))
+	}
+	*rc = ScapSuccess
+	return pState
+}
+
+// Destroy serves plugin_destroy, destroying the plugin instance of pState, if
+// plugin_init succeeded, and freeing pState.
+//
+// The async extractor worker, if any, is stopped first. The host does not need to end
+// the async extraction protocol before, but if an extraction request does not complete
+// within AsyncStopTimeout the plugin instance is neither destroyed nor freed.
+//
+// Once the last plugin instance is destroyed, leaks are reported with ReportLeakedHandles().
+func (r *Registration) Destroy(pState unsafe.Pointer) {
+	if pState == nil {
+		return
+	}
+	defer Recover(nil, nil)
+
+	// Make sure no extraction is running while the plugin is destroyed. Otherwise
+	// the plugin instance is leaked on purpose, and still counted as alive.
+	if !stopAsyncExtractors(pState) {
+		return
+	}
+	defer func() {
+		if atomic.AddInt32(&r.instances, -1) == 0 {
+			ReportLeakedHandles()
+		}
+	}()
+	defer Free(pState)
+
+	// The context is not set if plugin_init failed
+	if ctx, ok := ContextValue(pState).(*registeredPlugin); ok {
+		ctx.plugin.Destroy()
+	}
+}
@@ -0,0 +1,304 @@
+package sinsp
+
+/*
+#include <stdlib.h>
+#include <stdint.h>
+
+typedef struct {
+   uint8_t* buf;
+   uint32_t len;
+   uint32_t closed;
+   uint64_t head;    // bytes published by the producer
+   uint64_t tail;    // bytes released by the consumer
+   uint64_t pending; // bytes handed out to sinsp, released at the next read
+   uint64_t resv;    // position of the record reserved by the producer
+   uint32_t resvLen; // payload size of the record reserved by the producer
+} ring;
+*/
+import "C"
+import (
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"unsafe"
+
+	"github.com/ldegio/libsinsp-plugin-sdk-go/pkg/sinsp/batch"
+)
+
+// ErrRingFull is returned when the ring buffer has not enough free space for an event.
+var ErrRingFull = errors.New("ring buffer full")
+
+// errNoRing is returned when the ring buffer functions are called on a state
+// container without ring buffer.
+var errNoRing = errors.New("no ring buffer, see MakeRing()")
+
+// maxRingSize is the size of the largest ring buffer, which is accessed as a Go slice
+// through a pointer to an array of this size.
+const maxRingSize = 1 << 30
+
+// ringWrap is the payload length marking that the next record starts at the
+// beginning of the ring buffer.
+const ringWrap = ^uint32(0)
+
+// MakeRing allocates a single-producer/single-consumer ring buffer of size bytes
+// in C memory into p, assuming p is a state container created with NewStateContainer().
+// If p contains a previously allocated ring buffer, it will be freed before creating the new one.
+// Passing a zero size just frees it.
+//
+// Events are stored in the ring buffer using the framing of the batch package,
+// so that RingNext() and RingNextBatch() can hand them to sinsp without copying.
+// The producer, that is a single goroutine, writes events with RingReserve() and
+// RingCommit(), or RingWrite(), while the consumer reads them with RingNext() or
+// RingNextBatch() within plugin_next or plugin_next_batch.
+// An event, including its 12 bytes header, can take at most half of size bytes, so that
+// it always fits once the ring buffer is empty, and no more than MaxNextBufSize bytes
+// in any case.
+//
+// It returns an error if the ring buffer cannot be allocated, or if size exceeds 1 GiB,
+// in which case p is left without ring buffer.
+//
+// Intended usage as in the following example:
+//
+//     //export plugin_open
+//     func plugin_open(pState unsafe.Pointer, params *C.char, rc *int32) unsafe.Pointer {
+//     	oState := sinsp.NewStateContainer()
+//     	if err := sinsp.MakeRing(oState, 64*1024*1024); err != nil {
+//     		...
+//     	}
+//     	go produce(oState)
+//     	...
+//     }
+//
+//     func produce(oState unsafe.Pointer) {
+//     	for ... {
+//     		buf, err := sinsp.RingReserve(oState, maxSize)
+//     		... // write up to maxSize bytes of event payload into buf
+//     		sinsp.RingCommit(oState, ts, n)
+//     	}
+//     	sinsp.RingClose(oState)
+//     }
+//
+//     //export plugin_next_batch
+//     func plugin_next_batch(pState unsafe.Pointer, oState unsafe.Pointer, data **byte, datalen *uint32) int32 {
+//     	return sinsp.RingNextBatch(pState, oState, data, datalen)
+//     }
+//
+// The producer must be stopped before the state container is freed.
+func MakeRing(p unsafe.Pointer, size uint32) error {
+	if r := (*C.ring)(ringOf(p)); r != nil {
+		C.free(unsafe.Pointer(r.buf))
+		C.free(unsafe.Pointer(r))
+		setRing(p, nil)
+	}
+
+	if size > maxRingSize {
+		return NewError(ScapFailure, "a ring buffer of %d bytes exceeds the maximum of %d bytes", size, maxRingSize)
+	}
+	if size > 0 {
+		r := (*C.ring)(C.calloc(1, C.sizeof_ring))
+		if r == nil {
+			return NewError(ScapFailure, "could not allocate a ring buffer")
+		}
+		r.buf = (*C.uint8_t)(C.malloc(C.size_t(size)))
+		if r.buf == nil {
+			C.free(unsafe.Pointer(r))
+			return NewError(ScapFailure, "could not allocate a ring buffer of %d bytes", size)
+		}
+		r.len = C.uint32_t(size)
+		setRing(p, unsafe.Pointer(r))
+	}
+	return nil
+}
+
+func getRing(p unsafe.Pointer) *C.ring {
+	// we assume the ring was made by MakeRing()
+	return (*C.ring)(ringOf(p))
+}
+
+func ringBytes(r *C.ring) []byte {
+	return (*[maxRingSize]byte)(unsafe.Pointer(r.buf))[:int(r.len):int(r.len)]
+}
+
+func ringHead(r *C.ring) uint64 {
+	return atomic.LoadUint64((*uint64)(unsafe.Pointer(&r.head)))
+}
+
+func ringTail(r *C.ring) uint64 {
+	return atomic.LoadUint64((*uint64)(unsafe.Pointer(&r.tail)))
+}
+
+// RingReserve reserves room for an event with a payload of up to n bytes in the ring buffer
+// of p, and returns the slice of C memory into which the payload must be written.
+// The event is not visible to the consumer until RingCommit() is called.
+//
+// It returns ErrRingFull if there is not enough free space at the moment, and an error if
+// the event can never fit, either in half of the ring buffer (see MakeRing()) or in the
+// MaxNextBufSize bytes that RingNextBatch() returns at most.
+func RingReserve(p unsafe.Pointer, n uint32) ([]byte, error) {
+	r := getRing(p)
+	if r == nil {
+		return nil, errNoRing
+	}
+	size := uint64(r.len)
+	// computed in 64 bits, since the record size of n close to 2^32 wraps around
+	recLen := uint64(n) + batch.HeaderSize
+	if recLen > size/2 {
+		// A larger record could not be placed when the ring buffer is empty but
+		// the free space is split around the position of the last record
+		return nil, fmt.Errorf("event of %d bytes does not fit into half of the %d bytes ring buffer", n, size)
+	}
+	if recLen > uint64(MaxNextBufSize) {
+		return nil, fmt.Errorf("event of %d bytes does not fit into a %d bytes batch", n, MaxNextBufSize)
+	}
+
+	head := ringHead(r)
+	pos := head % size
+	start := head
+	if size-pos < recLen {
+		// The record does not fit before the end of the buffer, skip to the start
+		start += size - pos
+	}
+	if start+recLen-ringTail(r) > size {
+		return nil, ErrRingFull
+	}
+
+	if start != head && size-pos >= batch.HeaderSize {
+		batch.PutHeader(ringBytes(r)[pos:], 0, ringWrap)
+	}
+	r.resv = C.uint64_t(start)
+	r.resvLen = C.uint32_t(n)
+
+	off := start%size + batch.HeaderSize
+	return ringBytes(r)[off : off+uint64(n) : off+uint64(n)], nil
+}
+
+// RingCommit publishes the event reserved with RingReserve() in the ring buffer of p,
+// with the given timestamp and the first n bytes of the reserved payload.
+func RingCommit(p unsafe.Pointer, ts uint64, n uint32) {
+	r := getRing(p)
+	if r == nil {
+		panic("sinsp: committing to a state container without ring buffer")
+	}
+	if n > uint32(r.resvLen) {
+		panic(fmt.Sprintf("sinsp: committing %d bytes out of %d reserved", n, r.resvLen))
+	}
+
+	start := uint64(r.resv)
+	batch.PutHeader(ringBytes(r)[start%uint64(r.len):], ts, n)
+	r.resvLen = 0
+	atomic.StoreUint64((*uint64)(unsafe.Pointer(&r.head)), start+uint64(batch.RecordSize(n)))
+}
+
+// RingWrite copies data into the ring buffer of p as an event with the given timestamp.
+// It returns ErrRingFull if there is not enough free space at the moment.
+func RingWrite(p unsafe.Pointer, ts uint64, data []byte) error {
+	buf, err := RingReserve(p, uint32(len(data)))
+	if err != nil {
+		return err
+	}
+	copy(buf, data)
+	RingCommit(p, ts, uint32(len(data)))
+	return nil
+}
+
+// RingClose signals that the producer will not write more events into the ring buffer of p.
+// Once all the events have been read, RingNext() and RingNextBatch() return ScapEOF.
+func RingClose(p unsafe.Pointer) {
+	if r := getRing(p); r != nil {
+		atomic.StoreUint32((*uint32)(unsafe.Pointer(&r.closed)), 1)
+	}
+}
+
+// ringRead releases the records handed out by the previous read and returns the
+// position of the next record, skipping wrap markers, or ScapTimeout/ScapEOF if there is none.
+func ringRead(r *C.ring) (uint64, int32) {
+	size := uint64(r.len)
+	tail := uint64(r.tail) + uint64(r.pending)
+	r.pending = 0
+
+	// read closed before head, so that no event published before closing is missed
+	closed := atomic.LoadUint32((*uint32)(unsafe.Pointer(&r.closed))) != 0
+	head := ringHead(r)
+	if tail != head {
+		pos := tail % size
+		if size-pos < batch.HeaderSize {
+			tail += size - pos
+		} else if _, l := batch.Header(ringBytes(r)[pos:]); l == ringWrap {
+			tail += size - pos
+		}
+	}
+	atomic.StoreUint64((*uint64)(unsafe.Pointer(&r.tail)), tail)
+
+	switch {
+	case tail != head:
+		return tail, ScapSuccess
+	case closed:
+		return tail, ScapEOF
+	default:
+		return tail, ScapTimeout
+	}
+}
+
+// RingNext is an helper function to be used within plugin_next. It returns the next event
+// from the ring buffer of openState without copying it. The event stays valid until the next
+// call to RingNext() or RingNextBatch(). data is set to nil for an event with an empty payload.
+// Errors are stored as the last error of plgState.
+func RingNext(plgState unsafe.Pointer, openState unsafe.Pointer, data **byte, datalen *uint32, ts *uint64) int32 {
+	r := getRing(openState)
+	if r == nil {
+		return HandleError(plgState, errNoRing)
+	}
+	tail, res := ringRead(r)
+	if res != ScapSuccess {
+		return res
+	}
+
+	pos := tail % uint64(r.len)
+	b := ringBytes(r)
+	evtTs, l := batch.Header(b[pos:])
+	*ts = evtTs
+	*datalen = l
+	*data = nil
+	if l > 0 {
+		// an empty payload may end exactly at the end of the ring buffer
+		*data = &b[pos+batch.HeaderSize]
+	}
+	r.pending = C.uint64_t(batch.RecordSize(l))
+	return ScapSuccess
+}
+
+// RingNextBatch is an helper function to be used within plugin_next_batch. It returns the
+// events available in the ring buffer of openState without copying them, as long as they
+// are contiguous in memory and fit in MaxNextBufSize bytes, which the first event always
+// does (see RingReserve()). The events stay valid until the next call to RingNext() or
+// RingNextBatch(). Errors are stored as the last error of plgState.
+func RingNextBatch(plgState unsafe.Pointer, openState unsafe.Pointer, data **byte, datalen *uint32) int32 {
+	*datalen = 0
+	r := getRing(openState)
+	if r == nil {
+		return HandleError(plgState, errNoRing)
+	}
+	tail, res := ringRead(r)
+	if res != ScapSuccess {
+		return res
+	}
+
+	size := uint64(r.len)
+	head := ringHead(r)
+	b := ringBytes(r)
+	start := tail % size
+	pos := start
+	for tail+(pos-start) != head && size-pos >= batch.HeaderSize {
+		_, l := batch.Header(b[pos:])
+		recLen := uint64(batch.RecordSize(l))
+		if l == ringWrap || pos-start+recLen > uint64(MaxNextBufSize) {
+			break
+		}
+		pos += recLen
+	}
+
+	*data = &b[start]
+	*datalen = uint32(pos - start)
+	r.pending = C.uint64_t(pos - start)
+	return ScapSuccess
+}
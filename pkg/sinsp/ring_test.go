@@ -0,0 +1,327 @@
+package sinsp
+
+import (
+	"fmt"
+	"testing"
+	"unsafe"
+
+	"github.com/ldegio/libsinsp-plugin-sdk-go/pkg/sinsp/batch"
+)
+
+// newRing returns an open state with a ring buffer of size bytes.
+func newRing(t *testing.T, size uint32) unsafe.Pointer {
+	oState := NewStateContainer()
+	t.Cleanup(func() { Free(oState) })
+	if err := MakeRing(oState, size); err != nil {
+		t.Fatalf("MakeRing: %s", err)
+	}
+	return oState
+}
+
+// ringWrite writes an event of n bytes named after ts into the ring buffer of oState.
+func ringWrite(t *testing.T, oState unsafe.Pointer, ts uint64, n int) {
+	t.Helper()
+	if err := RingWrite(oState, ts, ringEvent(ts, n)); err != nil {
+		t.Fatalf("RingWrite(%d, %d bytes): %s", ts, n, err)
+	}
+}
+
+// ringEvent returns the payload of n bytes of the event with timestamp ts.
+func ringEvent(ts uint64, n int) []byte {
+	return []byte(fmt.Sprintf("%0*d", n, ts))
+}
+
+// ringNext calls RingNext() on oState, which also holds the errors, and returns the
+// code and the event read, if any.
+func ringNext(oState unsafe.Pointer) (int32, record) {
+	var data *byte
+	var datalen uint32
+	var ts uint64
+	rc := RingNext(oState, oState, &data, &datalen, &ts)
+	if rc != ScapSuccess || datalen == 0 {
+		return rc, record{ts: ts}
+	}
+	return rc, record{ts: ts, data: string((*[1 << 30]byte)(unsafe.Pointer(data))[:datalen:datalen])}
+}
+
+// ringNextBatch calls RingNextBatch() on oState, which also holds the errors, and
+// decodes the returned batch.
+func ringNextBatch(t *testing.T, oState unsafe.Pointer) result {
+	t.Helper()
+	var data *byte
+	var datalen uint32
+	r := result{rc: RingNextBatch(oState, oState, &data, &datalen)}
+	if datalen == 0 {
+		return r
+	}
+	it := batch.NewIterator((*[1 << 30]byte)(unsafe.Pointer(data))[:datalen:datalen])
+	for it.Next() {
+		r.records = append(r.records, record{ts: it.Ts(), data: string(it.Data())})
+	}
+	if err := it.Err(); err != nil {
+		t.Fatalf("decoding the batch: %s", err)
+	}
+	return r
+}
+
+// checkRingBatches calls RingNextBatch() once per expected result and compares the outcomes.
+func checkRingBatches(t *testing.T, oState unsafe.Pointer, want []result) {
+	t.Helper()
+	for i, w := range want {
+		if got := ringNextBatch(t, oState); got.String() != w.String() {
+			t.Fatalf("call %d: got %s, want %s", i, got, w)
+		}
+	}
+}
+
+// rec returns the record of the event with timestamp ts and n bytes.
+func rec(ts uint64, n int) record {
+	return record{ts: ts, data: string(ringEvent(ts, n))}
+}
+
+func TestRingReserveMaxNextBufSize(t *testing.T) {
+	oState := newRing(t, 2*MaxNextBufSize)
+
+	// The largest event returned by RingNextBatch() by itself
+	maxData := MaxNextBufSize - batch.HeaderSize
+	if _, err := RingReserve(oState, maxData+1); err == nil {
+		t.Fatalf("RingReserve accepted an event of %d bytes", maxData+1)
+	}
+	if err := RingWrite(oState, 1, make([]byte, maxData)); err != nil {
+		t.Fatalf("RingWrite: %s", err)
+	}
+	if err := RingWrite(oState, 2, []byte("next")); err != nil {
+		t.Fatalf("RingWrite: %s", err)
+	}
+	RingClose(oState)
+
+	var data *byte
+	var datalen uint32
+	for i, want := range []uint32{MaxNextBufSize, batch.RecordSize(4)} {
+		if rc := RingNextBatch(oState, oState, &data, &datalen); rc != ScapSuccess {
+			t.Fatalf("call %d: got %s", i, CodeString(rc))
+		}
+		if datalen != want {
+			t.Fatalf("call %d: got %d bytes, want %d", i, datalen, want)
+		}
+	}
+	if rc := RingNextBatch(oState, oState, &data, &datalen); rc != ScapEOF {
+		t.Fatalf("got %s, want EOF", CodeString(rc))
+	}
+}
+
+func TestRingReserveHalfSize(t *testing.T) {
+	const size = 100
+	maxData := uint32(size/2 - batch.HeaderSize)
+	oState := newRing(t, size)
+
+	if _, err := RingReserve(oState, maxData+1); err == nil || err == ErrRingFull {
+		t.Fatalf("RingReserve of %d bytes: got %v, want an error other than ErrRingFull", maxData+1, err)
+	}
+
+	// Once emptied, the ring buffer takes the largest event whatever the position
+	// of the last one, e.g. 40 bytes into the buffer
+	for off := batch.HeaderSize; off <= size; off++ {
+		oState := newRing(t, size)
+		parts := []int{off}
+		if off > size/2 {
+			parts = []int{off / 2, off - off/2}
+		}
+		for i, p := range parts {
+			ringWrite(t, oState, uint64(i+1), p-batch.HeaderSize)
+		}
+		for range parts {
+			if rc, _ := ringNext(oState); rc != ScapSuccess {
+				t.Fatalf("offset %d: RingNext: got %s", off, CodeString(rc))
+			}
+		}
+		// Release the last event
+		if rc, _ := ringNext(oState); rc != ScapTimeout {
+			t.Fatalf("offset %d: RingNext: got %s, want timeout", off, CodeString(rc))
+		}
+
+		ringWrite(t, oState, 10, int(maxData))
+		if rc, r := ringNext(oState); rc != ScapSuccess || r != rec(10, int(maxData)) {
+			t.Fatalf("offset %d: RingNext: got %s %v", off, CodeString(rc), r)
+		}
+	}
+}
+
+func TestRingWraparound(t *testing.T) {
+	oState := newRing(t, 100)
+
+	// Empty the ring buffer up to offset 60
+	ringWrite(t, oState, 1, 18)
+	ringWrite(t, oState, 2, 18)
+	checkRingBatches(t, oState, []result{
+		{ScapSuccess, []record{rec(1, 18), rec(2, 18)}},
+		{ScapTimeout, nil},
+	})
+
+	// A 26 bytes record up to offset 86, then a 30 bytes one which does not fit
+	// in the last 14 bytes and goes at the start, after a wrap marker
+	ringWrite(t, oState, 3, 14)
+	ringWrite(t, oState, 4, 18)
+	// Only 30 bytes are left before the record at offset 60
+	if _, err := RingReserve(oState, 19); err != ErrRingFull {
+		t.Fatalf("RingReserve: got %v, want ErrRingFull", err)
+	}
+
+	// Batches stop at the wrap marker
+	checkRingBatches(t, oState, []result{
+		{ScapSuccess, []record{rec(3, 14)}},
+		{ScapSuccess, []record{rec(4, 18)}},
+		{ScapTimeout, nil},
+	})
+
+	// With the ring buffer emptied, the records are contiguous again
+	ringWrite(t, oState, 5, 10)
+	ringWrite(t, oState, 6, 10)
+	checkRingBatches(t, oState, []result{
+		{ScapSuccess, []record{rec(5, 10), rec(6, 10)}},
+		{ScapTimeout, nil},
+	})
+}
+
+func TestRingWrapWithoutMarker(t *testing.T) {
+	oState := newRing(t, 100)
+
+	// Two 46 bytes records up to offset 92, leaving no room for a wrap marker
+	ringWrite(t, oState, 1, 34)
+	ringWrite(t, oState, 2, 34)
+	for _, want := range []record{rec(1, 34), rec(2, 34)} {
+		if rc, r := ringNext(oState); rc != ScapSuccess || r != want {
+			t.Fatalf("RingNext: got %s %v, want %v", CodeString(rc), r, want)
+		}
+	}
+
+	// The record goes at the start of the buffer
+	ringWrite(t, oState, 3, 20)
+	if rc, r := ringNext(oState); rc != ScapSuccess || r != rec(3, 20) {
+		t.Fatalf("RingNext: got %s %v, want %v", CodeString(rc), r, rec(3, 20))
+	}
+	if rc, r := ringNext(oState); rc != ScapTimeout {
+		t.Fatalf("RingNext: got %s %v, want timeout", CodeString(rc), r)
+	}
+}
+
+func TestRingFullUntilReleased(t *testing.T) {
+	oState := newRing(t, 100)
+
+	ringWrite(t, oState, 1, 38)
+	ringWrite(t, oState, 2, 38)
+	if _, err := RingReserve(oState, 0); err != ErrRingFull {
+		t.Fatalf("RingReserve: got %v, want ErrRingFull", err)
+	}
+
+	// The event handed out is only released by the next read
+	if rc, r := ringNext(oState); rc != ScapSuccess || r != rec(1, 38) {
+		t.Fatalf("RingNext: got %s %v", CodeString(rc), r)
+	}
+	if _, err := RingReserve(oState, 0); err != ErrRingFull {
+		t.Fatalf("RingReserve: got %v, want ErrRingFull", err)
+	}
+	if rc, r := ringNext(oState); rc != ScapSuccess || r != rec(2, 38) {
+		t.Fatalf("RingNext: got %s %v", CodeString(rc), r)
+	}
+	ringWrite(t, oState, 3, 38)
+}
+
+func TestRingClose(t *testing.T) {
+	oState := newRing(t, 100)
+
+	ringWrite(t, oState, 1, 10)
+	ringWrite(t, oState, 2, 10)
+	RingClose(oState)
+
+	// The events written before closing are delivered first
+	checkRingBatches(t, oState, []result{
+		{ScapSuccess, []record{rec(1, 10), rec(2, 10)}},
+		{ScapEOF, nil},
+		{ScapEOF, nil},
+	})
+	if rc, _ := ringNext(oState); rc != ScapEOF {
+		t.Fatalf("RingNext: got %s, want EOF", CodeString(rc))
+	}
+}
+
+func TestRingMissing(t *testing.T) {
+	pState := NewStateContainer()
+	defer Free(pState)
+	oState := NewStateContainer()
+	defer Free(oState)
+
+	if _, err := RingReserve(oState, 1); err == nil {
+		t.Errorf("RingReserve: no error")
+	}
+
+	// The errors are reported on the plugin state, where libsinsp reads them
+	var data *byte
+	var datalen uint32
+	var ts uint64
+	if rc := RingNext(pState, oState, &data, &datalen, &ts); rc != ScapFailure {
+		t.Errorf("RingNext: got %s, want failure", CodeString(rc))
+	}
+	if err := LastError(pState); err == nil || err.Error() != errNoRing.Error() {
+		t.Errorf("RingNext: got last error %v, want %v", err, errNoRing)
+	}
+	SetLastError(pState, nil)
+	if rc := RingNextBatch(pState, oState, &data, &datalen); rc != ScapFailure {
+		t.Errorf("RingNextBatch: got %s, want failure", CodeString(rc))
+	}
+	if err := LastError(pState); err == nil || err.Error() != errNoRing.Error() {
+		t.Errorf("RingNextBatch: got last error %v, want %v", err, errNoRing)
+	}
+	if err := LastError(oState); err != nil {
+		t.Errorf("got last error %v on the open state", err)
+	}
+	RingClose(oState)
+}
+
+func TestMakeRingMaxSize(t *testing.T) {
+	oState := newRing(t, 100)
+
+	if err := MakeRing(oState, maxRingSize+1); err == nil {
+		t.Fatalf("MakeRing accepted a ring buffer of %d bytes", maxRingSize+1)
+	}
+	if _, err := RingReserve(oState, 1); err == nil || err == ErrRingFull {
+		t.Fatalf("RingReserve after a failed MakeRing: got %v, want no ring buffer", err)
+	}
+}
+
+func TestRingReserveHugeEvent(t *testing.T) {
+	oState := newRing(t, 100)
+
+	// The record size of these events wraps around in 32 bits
+	for _, n := range []uint32{^uint32(0), ^uint32(0) - batch.HeaderSize + 1} {
+		if _, err := RingReserve(oState, n); err == nil || err == ErrRingFull {
+			t.Errorf("RingReserve of %d bytes: got %v, want an error other than ErrRingFull", n, err)
+		}
+	}
+}
+
+func TestRingEmptyEventAtEnd(t *testing.T) {
+	oState := newRing(t, 48)
+
+	// A 24 bytes record, then two empty ones, the last ending exactly at the end of the buffer
+	ringWrite(t, oState, 1, 12)
+	if rc, r := ringNext(oState); rc != ScapSuccess || r != rec(1, 12) {
+		t.Fatalf("RingNext: got %s %v, want %v", CodeString(rc), r, rec(1, 12))
+	}
+	for _, ts := range []uint64{2, 3} {
+		if err := RingWrite(oState, ts, nil); err != nil {
+			t.Fatalf("RingWrite(%d): %s", ts, err)
+		}
+	}
+	for _, ts := range []uint64{2, 3} {
+		var data *byte
+		var datalen uint32
+		var evtTs uint64
+		if rc := RingNext(oState, oState, &data, &datalen, &evtTs); rc != ScapSuccess || evtTs != ts || datalen != 0 || data != nil {
+			t.Fatalf("RingNext: got %s ts=%d datalen=%d data=%p, want an empty event %d", CodeString(rc), evtTs, datalen, data, ts)
+		}
+	}
+	if rc, r := ringNext(oState); rc != ScapTimeout {
+		t.Fatalf("RingNext: got %s %v, want timeout", CodeString(rc), r)
+	}
+}
@@ -0,0 +1,458 @@
+// Package sinsptest drives a plugin in-process through the same lifecycle
+// libsinsp follows, so that plugins can be tested with go test without
+// building a shared object and loading it into a host.
+//
+// Plugins built with the source or extractor packages get their entry
+// points from source.Entrypoints() or extractor.Entrypoints():
+//
+//     func TestPlugin(t *testing.T) {
+//     	res, err := sinsptest.Run(source.Entrypoints(), &sinsptest.Options{MaxEvents: 10})
+//     	if err != nil {
+//     		t.Fatal(err)
+//     	}
+//     	for _, evt := range res.Events {
+//     		...
+//     	}
+//     }
+//
+// Run checks the rules of the plugin API contract along the way and reports
+// the first violation as an error.
+package sinsptest
+
+import (
+	"encoding/json"
+	"fmt"
+	"unsafe"
+
+	"github.com/ldegio/libsinsp-plugin-sdk-go/pkg/sinsp"
+	"github.com/ldegio/libsinsp-plugin-sdk-go/pkg/sinsp/batch"
+)
+
+// DefaultMaxEvents is the number of events read when Options.MaxEvents is zero.
+const DefaultMaxEvents = 100
+
+// DefaultMaxTimeouts is the number of consecutive timeouts after which
+// reading stops when Options.MaxTimeouts is zero.
+const DefaultMaxTimeouts = 1000
+
+// Options configures Run().
+type Options struct {
+	// Config is the configuration passed to plugin_init.
+	Config string
+	// Params are the parameters passed to plugin_open.
+	Params string
+	// MaxEvents is the number of events after which reading stops,
+	// unless the plugin returns ScapEOF earlier.
+	MaxEvents int
+	// MaxTimeouts is the number of consecutive ScapTimeout after which reading stops.
+	MaxTimeouts int
+	// NextBatch makes Run() read the events with plugin_next_batch instead of plugin_next.
+	NextBatch bool
+	// Inputs are the events used by extractor plugins, which cannot read events by themselves.
+	Inputs [][]byte
+	// Fields are the names of the fields extracted from every event. If nil, all
+	// the fields are extracted, except the ones requiring an argument missing from Args.
+	// If empty, none is.
+	Fields []string
+	// Args maps the names of the fields to the argument passed when extracting them.
+	Args map[string]string
+}
+
+// Value is the value of a field extracted from an event.
+type Value struct {
+	Present bool
+	// Str is set for string fields.
+	Str string
+	// U64 is set for the fields extracted with plugin_extract_u64.
+	U64 uint64
+	// Buf is set for the fields extracted with plugin_extract_buf.
+	Buf []byte
+}
+
+// Event is an event read from the plugin, or given in Options.Inputs.
+type Event struct {
+	Num  uint64
+	Ts   uint64
+	Data []byte
+	// String is the event as returned by plugin_event_to_string, if exported.
+	String string
+	// Metadata is the metadata of the event, for source plugins producing
+	// events with sinsp.ToNextFunc() (see sinsp.EventMetadata()).
+	Metadata map[string]string
+	// Fields maps field names to the values extracted from the event.
+	Fields map[string]Value
+}
+
+// Result is what Run() collected from the plugin.
+type Result struct {
+	Type               uint32
+	ID                 uint32
+	Name               string
+	Description        string
+	RequiredAPIVersion string
+	Fields             []sinsp.FieldEntry
+	Events             []Event
+}
+
+// bufFieldTypes are the field types extracted with plugin_extract_buf.
+var bufFieldTypes = map[sinsp.FieldType]bool{
+	sinsp.FieldTypeIPv4Addr: true,
+	sinsp.FieldTypeIPv6Addr: true,
+	sinsp.FieldTypeIPAddr:   true,
+	sinsp.FieldTypeIPv4Net:  true,
+	sinsp.FieldTypeIPv6Net:  true,
+	sinsp.FieldTypeIPNet:    true,
+	sinsp.FieldTypeByteBuf:  true,
+}
+
+// runner holds the state of a Run() call.
+type runner struct {
+	e      *sinsp.Entrypoints
+	opts   *Options
+	res    *Result
+	pState unsafe.Pointer
+	evtnum uint64
+	lastTs uint64
+}
+
+// Run drives the plugin implemented by e through its lifecycle: plugin_get_type,
+// the metadata getters, plugin_get_fields, plugin_init, then, for source plugins,
+// plugin_open, plugin_next or plugin_next_batch, plugin_event_to_string and
+// plugin_close, and finally plugin_destroy. Fields are extracted from every event read,
+// or from every input for extractor plugins.
+//
+// It returns an error as soon as a call fails or violates the plugin API contract,
+// for example by returning a nil state, invalid fields JSON or events with
+// decreasing timestamps. The plugin is destroyed anyway, and the result
+// collected so far is returned along with the error.
+func Run(e *sinsp.Entrypoints, opts *Options) (res *Result, err error) {
+	if opts == nil {
+		opts = &Options{}
+	}
+	r := &runner{e: e, opts: opts, res: &Result{}}
+	if err = r.checkEntrypoints(); err != nil {
+		return r.res, err
+	}
+
+	r.readInfo()
+	if r.res.Fields, err = r.readFields(); err != nil {
+		return r.res, err
+	}
+
+	var rc int32
+	r.pState = e.Init(cString(opts.Config), &rc)
+	defer func() {
+		if r.pState != nil {
+			e.Destroy(r.pState)
+		}
+	}()
+	if rc != sinsp.ScapSuccess {
+		return r.res, r.callError("plugin_init", rc)
+	}
+	if r.pState == nil {
+		return r.res, fmt.Errorf("sinsptest: plugin_init returned a nil state")
+	}
+
+	if r.res.Type == sinsp.TypeExtractorPlugin {
+		for _, data := range opts.Inputs {
+			r.evtnum++
+			if err = r.addEvent(data, 0, nil); err != nil {
+				return r.res, err
+			}
+		}
+		return r.res, nil
+	}
+
+	return r.res, r.readEvents()
+}
+
+func (r *runner) checkEntrypoints() error {
+	e := r.e
+	if e.GetType == nil || e.GetID == nil || e.GetName == nil || e.GetDescription == nil ||
+		e.GetRequiredAPIVersion == nil || e.GetFields == nil || e.GetLastError == nil || e.Init == nil || e.Destroy == nil {
+		return fmt.Errorf("sinsptest: missing required entry points")
+	}
+
+	switch t := e.GetType(); t {
+	case sinsp.TypeSourcePlugin:
+		if e.Open == nil || e.Close == nil || e.Next == nil {
+			return fmt.Errorf("sinsptest: missing required source plugin entry points")
+		}
+		if r.opts.NextBatch && e.NextBatch == nil {
+			return fmt.Errorf("sinsptest: NextBatch requested but plugin_next_batch is not exported")
+		}
+	case sinsp.TypeExtractorPlugin:
+		if e.ExtractStr == nil && e.ExtractU64 == nil {
+			return fmt.Errorf("sinsptest: missing required extractor plugin entry points")
+		}
+	default:
+		return fmt.Errorf("sinsptest: plugin_get_type returned an invalid type %d", t)
+	}
+	return nil
+}
+
+func (r *runner) readInfo() {
+	r.res.Type = r.e.GetType()
+	r.res.ID = r.e.GetID()
+	r.res.Name = goString(r.e.GetName())
+	r.res.Description = goString(r.e.GetDescription())
+	r.res.RequiredAPIVersion = goString(r.e.GetRequiredAPIVersion())
+}
+
+// readFields returns the fields of the plugin, checking that they are valid.
+func (r *runner) readFields() ([]sinsp.FieldEntry, error) {
+	s := r.e.GetFields()
+	if s == nil {
+		return nil, fmt.Errorf("sinsptest: plugin_get_fields failed: %s", goString(r.e.GetLastError(nil)))
+	}
+
+	var fields []sinsp.FieldEntry
+	if err := json.Unmarshal([]byte(goString(s)), &fields); err != nil {
+		return nil, fmt.Errorf("sinsptest: plugin_get_fields returned invalid JSON: %s", err.Error())
+	}
+	if r.res.Type == sinsp.TypeExtractorPlugin && len(fields) == 0 {
+		return nil, fmt.Errorf("sinsptest: extractor plugin with no fields")
+	}
+
+	if err := sinsp.ValidateFields(r.res.Name, fields); err != nil {
+		return fields, fmt.Errorf("sinsptest: invalid fields: %s", err.Error())
+	}
+	return fields, nil
+}
+
+func (r *runner) readEvents() (err error) {
+	var rc int32
+	oState := r.e.Open(r.pState, cString(r.opts.Params), &rc)
+	if rc != sinsp.ScapSuccess {
+		return r.callError("plugin_open", rc)
+	}
+	if oState == nil {
+		return fmt.Errorf("sinsptest: plugin_open returned a nil state")
+	}
+	defer r.e.Close(r.pState, oState)
+
+	maxEvents := r.opts.MaxEvents
+	if maxEvents == 0 {
+		maxEvents = DefaultMaxEvents
+	}
+	maxTimeouts := r.opts.MaxTimeouts
+	if maxTimeouts == 0 {
+		maxTimeouts = DefaultMaxTimeouts
+	}
+
+	timeouts := 0
+	for len(r.res.Events) < maxEvents && timeouts < maxTimeouts {
+		var data *byte
+		var datalen uint32
+		var ts uint64
+		name := "plugin_next"
+		if r.opts.NextBatch {
+			name = "plugin_next_batch"
+			rc = r.e.NextBatch(r.pState, oState, &data, &datalen)
+		} else {
+			rc = r.e.Next(r.pState, oState, &data, &datalen, &ts)
+		}
+
+		switch rc {
+		case sinsp.ScapSuccess:
+			timeouts = 0
+		case sinsp.ScapTimeout:
+			timeouts++
+			continue
+		case sinsp.ScapEOF:
+			return nil
+		default:
+			return r.callError(name, rc)
+		}
+
+		if data == nil && datalen > 0 {
+			return fmt.Errorf("sinsptest: %s returned a nil buffer of %d bytes", name, datalen)
+		}
+		b := goBytes(data, datalen)
+		md := sinsp.EventMetadata(oState)
+		if !r.opts.NextBatch {
+			if err = r.nextEvent(b, ts, metadata(md, 0)); err != nil {
+				return err
+			}
+			continue
+		}
+
+		it := batch.NewIterator(b)
+		for i := 0; it.Next() && len(r.res.Events) < maxEvents; i++ {
+			if err = r.nextEvent(it.Data(), it.Ts(), metadata(md, i)); err != nil {
+				return err
+			}
+		}
+		if it.Err() != nil {
+			return fmt.Errorf("sinsptest: plugin_next_batch returned an invalid batch: %s", it.Err().Error())
+		}
+	}
+	return nil
+}
+
+// metadata returns the i-th element of md, or nil if there is none.
+func metadata(md []map[string]string, i int) map[string]string {
+	if i < len(md) {
+		return md[i]
+	}
+	return nil
+}
+
+// nextEvent checks an event read from the plugin and adds it to the result.
+func (r *runner) nextEvent(data []byte, ts uint64, md map[string]string) error {
+	r.evtnum++
+	if ts < r.lastTs {
+		return fmt.Errorf("sinsptest: event %d has timestamp %d, lower than the previous one %d", r.evtnum, ts, r.lastTs)
+	}
+	r.lastTs = ts
+	return r.addEvent(data, ts, md)
+}
+
+// addEvent converts the event to string and extracts the fields from it, adding it to the result.
+func (r *runner) addEvent(data []byte, ts uint64, md map[string]string) error {
+	// copy the event, since the plugin may reuse its memory
+	evt := Event{
+		Num:      r.evtnum,
+		Ts:       ts,
+		Data:     append([]byte{}, data...),
+		Metadata: md,
+		Fields:   make(map[string]Value),
+	}
+
+	if r.e.EventToString != nil {
+		s := r.e.EventToString(dataPtr(evt.Data), uint32(len(evt.Data)))
+		if s == nil {
+			return fmt.Errorf("sinsptest: plugin_event_to_string failed on event %d: %s", evt.Num, goString(r.e.GetLastError(nil)))
+		}
+		evt.String = goString(s)
+	}
+
+	for i, f := range r.res.Fields {
+		if !r.extracted(&f) {
+			continue
+		}
+		v, err := r.extract(uint32(i), &f, evt.Data)
+		if err != nil {
+			return err
+		}
+		evt.Fields[f.Name] = v
+	}
+
+	r.res.Events = append(r.res.Events, evt)
+	return nil
+}
+
+func (r *runner) extracted(f *sinsp.FieldEntry) bool {
+	if r.opts.Fields == nil {
+		// Skip the fields that cannot be extracted without an argument
+		_, ok := r.opts.Args[f.Name]
+		return ok || f.Arg == nil || !f.Arg.Required
+	}
+	for _, n := range r.opts.Fields {
+		if n == f.Name {
+			return true
+		}
+	}
+	return false
+}
+
+// extract extracts the field with the given id from data, using the extraction
+// function matching its type.
+//
+// Since the extraction functions have no return code, a field reported as not present
+// is an extraction failure, as for ScapFailure, if the call set the last error of the
+// plugin, which libsinsp would find through plugin_get_last_error. The last error is
+// cleared before the call with the ClearLastError entry point; without it, the call
+// must change the last error instead, so a failure repeating the message of the
+// previous one goes unnoticed.
+func (r *runner) extract(id uint32, f *sinsp.FieldEntry, data []byte) (v Value, err error) {
+	var arg *byte
+	if a, ok := r.opts.Args[f.Name]; ok {
+		arg = cString(a)
+	}
+	p, l := dataPtr(data), uint32(len(data))
+	lastErr := ""
+	if r.e.ClearLastError != nil {
+		r.e.ClearLastError(r.pState)
+	} else {
+		lastErr = goString(r.e.GetLastError(r.pState))
+	}
+
+	switch {
+	case f.Type == sinsp.FieldTypeString:
+		if r.e.ExtractStr == nil {
+			return v, fmt.Errorf("sinsptest: field %s requires plugin_extract_str", f.Name)
+		}
+		if s := r.e.ExtractStr(r.pState, r.evtnum, id, arg, p, l); s != nil {
+			v.Present = true
+			v.Str = goString(s)
+		}
+	case bufFieldTypes[f.Type]:
+		if r.e.ExtractBuf == nil {
+			return v, fmt.Errorf("sinsptest: field %s requires plugin_extract_buf", f.Name)
+		}
+		var reslen uint32
+		if b := r.e.ExtractBuf(r.pState, r.evtnum, id, arg, p, l, &reslen); b != nil {
+			v.Present = true
+			v.Buf = append([]byte{}, goBytes(b, reslen)...)
+		}
+	default:
+		if r.e.ExtractU64 == nil {
+			return v, fmt.Errorf("sinsptest: field %s requires plugin_extract_u64", f.Name)
+		}
+		var present uint32
+		v.U64 = r.e.ExtractU64(r.pState, r.evtnum, id, arg, p, l, &present)
+		v.Present = present != 0
+	}
+
+	if !v.Present {
+		if msg := goString(r.e.GetLastError(r.pState)); msg != "" && msg != lastErr {
+			return v, fmt.Errorf("sinsptest: extracting field %s from event %d failed with code %d: %s", f.Name, r.evtnum, sinsp.ScapFailure, msg)
+		}
+	}
+	return v, nil
+}
+
+// callError returns the error for a call that returned rc, including the last error of the plugin.
+func (r *runner) callError(name string, rc int32) error {
+	msg := goString(r.e.GetLastError(r.pState))
+	if msg == "" {
+		msg = sinsp.CodeString(rc)
+	}
+	return fmt.Errorf("sinsptest: %s failed with code %d: %s", name, rc, msg)
+}
+
+// cString returns s as a NULL terminated string.
+func cString(s string) *byte {
+	b := append([]byte(s), 0)
+	return &b[0]
+}
+
+// goString returns the NULL terminated string starting at p, or an empty string if p is nil.
+func goString(p *byte) string {
+	if p == nil {
+		return ""
+	}
+	n := 0
+	for *(*byte)(unsafe.Pointer(uintptr(unsafe.Pointer(p)) + uintptr(n))) != 0 {
+		n++
+	}
+	return string(goBytes(p, uint32(n)))
+}
+
+// goBytes returns the n bytes starting at p, without copying them.
+func goBytes(p *byte, n uint32) []byte {
+	if p == nil || n == 0 {
+		return nil
+	}
+	return (*[1 << 30]byte)(unsafe.Pointer(p))[:n:n]
+}
+
+// dataPtr returns a pointer to the first byte of data, which is
+// never nil, even for empty events.
+func dataPtr(data []byte) *byte {
+	if len(data) == 0 {
+		return &make([]byte, 1)[0]
+	}
+	return &data[0]
+}
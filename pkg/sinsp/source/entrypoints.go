@@ -0,0 +1,27 @@
+package source
+
+import "C"
+import (
+	"unsafe"
+
+	"github.com/ldegio/libsinsp-plugin-sdk-go/pkg/sinsp"
+	"github.com/ldegio/libsinsp-plugin-sdk-go/pkg/sinsp/internal/exports"
+)
+
+// Entrypoints returns the entry points exported by this package, so that the
+// registered plugin can be tested in-process with the sinsptest package.
+func Entrypoints() *sinsp.Entrypoints {
+	e := &sinsp.Entrypoints{
+		Open: func(pState unsafe.Pointer, params *byte, rc *int32) unsafe.Pointer {
+			return plugin_open(pState, (*C.char)(unsafe.Pointer(params)), rc)
+		},
+		Close:     plugin_close,
+		Next:      plugin_next,
+		NextBatch: plugin_next_batch,
+		EventToString: func(data *byte, datalen uint32) *byte {
+			return (*byte)(unsafe.Pointer(plugin_event_to_string((*C.char)(unsafe.Pointer(data)), datalen)))
+		},
+	}
+	exports.Entrypoints(e)
+	return e
+}
@@ -0,0 +1,15 @@
+#include <stdlib.h>
+
+// evt_str is the string last returned by plugin_event_to_string on the calling thread.
+// Since every thread gets its own string, the one returned to a thread is only
+// freed by the next call from the same thread.
+static __thread char* evt_str = NULL;
+
+// set_evt_str frees the string previously returned on the calling thread and
+// keeps s in its place.
+char* set_evt_str(char* s)
+{
+	free(evt_str);
+	evt_str = s;
+	return s;
+}
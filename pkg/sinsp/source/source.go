@@ -0,0 +1,142 @@
+// Package source exports the C symbols required by a libsinsp source plugin
+// and dispatches them to a sinsp.SourcePlugin implementation.
+//
+// A plugin only needs to register its factory and provide an empty main.
+// Plugins also implementing sinsp.Extractor get their fields exported too:
+//
+//     func init() {
+//     	source.Register(func() sinsp.SourcePlugin { return &myPlugin{} })
+//     }
+//
+//     func main() {}
+//
+// Importing the async package as well enables asynchronous extraction.
+// Plugins implementing sinsp.Decoder get a decode cache bound to each plugin
+// state, so that the payload of an event is decoded once for all its fields.
+package source
+
+/*
+#include <stdlib.h>
+#include <stdint.h>
+
+// defined in evtstr.c, since the preamble of a file with exports cannot have definitions
+char* set_evt_str(char* s);
+*/
+import "C"
+import (
+	"unsafe"
+
+	"github.com/ldegio/libsinsp-plugin-sdk-go/pkg/sinsp"
+	"github.com/ldegio/libsinsp-plugin-sdk-go/pkg/sinsp/internal/exports"
+)
+
+type openCtx struct {
+	state interface{}
+}
+
+// Register sets the factory used to create a new sinsp.SourcePlugin for every
+// plugin_init call. It must be called exactly once, typically from an init function.
+// The fields of the plugin are validated with sinsp.MarshalFields(), so their names
+// must start with the plugin name followed by a dot.
+//
+// The symbols common to all the plugin types are served by a sinsp.Registration,
+// while this package serves the ones specific to source plugins.
+func Register(f func() sinsp.SourcePlugin) {
+	exports.Register("source", func() *sinsp.Registration {
+		return sinsp.NewRegistration(sinsp.TypeSourcePlugin, func() sinsp.Lifecycle { return f() })
+	})
+}
+
+func plugin(pState unsafe.Pointer) sinsp.SourcePlugin {
+	return exports.Registration.Plugin(pState).(sinsp.SourcePlugin)
+}
+
+func open(oState unsafe.Pointer) interface{} {
+	return sinsp.ContextValue(oState).(*openCtx).state
+}
+
+//export plugin_open
+func plugin_open(pState unsafe.Pointer, params *C.char, rc *int32) (oState unsafe.Pointer) {
+	defer sinsp.Recover(pState, rc)
+	if err := sinsp.CheckEnabled(pState); err != nil {
+		*rc = sinsp.HandleError(pState, err)
+		return nil
+	}
+
+	s, err := plugin(pState).Open(C.GoString(params))
+	if err != nil {
+		*rc = sinsp.HandleError(pState, err)
+		return nil
+	}
+
+	oState = sinsp.NewStateContainer()
+	if err := sinsp.MakeBuffer(oState, sinsp.MaxNextBufSize); err != nil {
+		plugin(pState).Close(s)
+		sinsp.Free(oState)
+		*rc = sinsp.HandleError(pState, err)
+		return nil
+	}
+	sinsp.SetContextValue(oState, &openCtx{state: s})
+	if c, ok := plugin(pState).(sinsp.BatchConfigurer); ok {
+		sinsp.SetBatchOptions(oState, c.BatchOptions())
+	}
+	// Event numbers start over with every capture, drop the results of the previous one
+	if c := sinsp.DecodeCacheOf(pState); c != nil {
+		c.Reset()
+	}
+	*rc = sinsp.ScapSuccess
+	return oState
+}
+
+//export plugin_close
+func plugin_close(pState unsafe.Pointer, oState unsafe.Pointer) {
+	if oState == nil {
+		return
+	}
+	defer sinsp.Recover(pState, nil)
+	defer sinsp.Free(oState)
+
+	plugin(pState).Close(open(oState))
+}
+
+var next = sinsp.ToNextFunc(func(pState unsafe.Pointer, oState unsafe.Pointer) (sinsp.Event, error) {
+	return plugin(pState).Next(open(oState))
+})
+
+//export plugin_next
+func plugin_next(pState unsafe.Pointer, oState unsafe.Pointer, data **byte, datalen *uint32, ts *uint64) (res int32) {
+	defer sinsp.Recover(pState, &res)
+	if err := sinsp.CheckEnabled(pState); err != nil {
+		return sinsp.HandleError(pState, err)
+	}
+
+	return sinsp.Next(pState, oState, data, datalen, ts, next)
+}
+
+//export plugin_next_batch
+func plugin_next_batch(pState unsafe.Pointer, oState unsafe.Pointer, data **byte, datalen *uint32) (res int32) {
+	defer sinsp.Recover(pState, &res)
+	if err := sinsp.CheckEnabled(pState); err != nil {
+		return sinsp.HandleError(pState, err)
+	}
+
+	return sinsp.NextBatch(pState, oState, data, datalen, next)
+}
+
+//export plugin_event_to_string
+func plugin_event_to_string(data *C.char, datalen uint32) (res *C.char) {
+	defer sinsp.Recover(nil, nil)
+
+	// todo: plugin_event_to_string() needs context as argument to avoid using the prototype instance
+	s, err := exports.Registration.Proto().(sinsp.SourcePlugin).EventToString(C.GoBytes(unsafe.Pointer(data), C.int(datalen)))
+
+	// plugin_event_to_string has no state argument, so the returned string is kept
+	// until the next call from the same thread and then freed. Exported functions run
+	// on the thread of their C caller, so concurrent callers never share a string.
+	if err != nil {
+		C.set_evt_str(nil)
+		sinsp.HandleError(nil, err)
+		return nil
+	}
+	return C.set_evt_str(C.CString(s))
+}
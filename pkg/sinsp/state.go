@@ -5,50 +5,240 @@ package sinsp
 #include <stdint.h>
 
 typedef struct {
+   uint32_t magic;
    uint8_t* buf;
    uint32_t bufLen;
-   void* goMem;
-   void* batchCtx;
+   uint32_t bufMax;
+   uintptr_t goMem;
+   uintptr_t batchCtx;
+   uintptr_t fields;
+   char* lastErr;
+   char* lastErrReported;
+   uint32_t panics;
+   uintptr_t async;
+   uint8_t* resBuf;
+   uint32_t resBufLen;
+   void* ring;
+   uintptr_t decodeCache;
 } state;
 */
 import "C"
 import (
+	"errors"
+	"sync"
+	"sync/atomic"
 	"unsafe"
 )
 
+// lastErrMu guards the last error of all the state containers, since SetLastError()
+// may be called by the async extractor worker while the host reads the error.
+//
+// recentLastErr holds a copy of the message most recently stored with SetLastError(),
+// so that it can be reported when no state is available. recentLastErrReported is
+// the copy of it last returned by LastErrorStr(nil).
+var (
+	lastErrMu             sync.Mutex
+	recentLastErr         *C.char
+	recentLastErrReported *C.char
+)
+
 // NewStateContainer returns an opaque pointer to a memory container that
 // may be safely passed back and forth to sinsp.
 //
 // A state container can allocate a byte buffer (sharable to C memory)
-// and reference a Go pointer (suitable for a Go context).
+// and reference a Go value (suitable for a Go context) through a Handle.
 // Both are persisted in memory until manually freed.
 // A state container must be manually freed by using Free().
 // It can be either used as the state of a source plugin or an open state of the source plugin.
 func NewStateContainer() unsafe.Pointer {
 	pCtx := (*C.state)(C.malloc(C.sizeof_state))
+	pCtx.magic = C.uint32_t(stateMagic)
+	pCtx.buf = nil
 	pCtx.bufLen = 0
-	pCtx.goMem = nil
-	pCtx.batchCtx = nil
+	pCtx.bufMax = 0
+	pCtx.goMem = 0
+	pCtx.batchCtx = 0
+	pCtx.fields = 0
+	pCtx.lastErr = nil
+	pCtx.lastErrReported = nil
+	pCtx.panics = 0
+	pCtx.async = 0
+	pCtx.resBuf = nil
+	pCtx.resBufLen = 0
+	pCtx.ring = nil
+	pCtx.decodeCache = 0
+	if debugMode {
+		debugNewState(unsafe.Pointer(pCtx))
+	}
 	return unsafe.Pointer(pCtx)
 }
 
+// getState returns the state container p, validating it when built with the sinsp_debug tag.
+func getState(p unsafe.Pointer) *C.state {
+	if debugMode {
+		debugCheckState(p, func() uint32 { return uint32((*C.state)(p).magic) })
+	}
+	return (*C.state)(p)
+}
+
+// stateInfo returns the size of the buffers of p and its context, for DebugReport().
+func stateInfo(p unsafe.Pointer) (bufLen, resBufLen, ringLen uint32, ctx interface{}) {
+	state := (*C.state)(p)
+	if r := getRing(p); r != nil {
+		ringLen = uint32(r.len)
+	}
+	return uint32(state.bufLen), uint32(state.resBufLen), ringLen, handleValue(state.goMem)
+}
+
+// ErrBufferOverflow is wrapped by the errors returned when data does not fit
+// into the buffer of a state container.
+var ErrBufferOverflow = errors.New("buffer overflow")
+
+// maxBufferSize is the size of the largest buffer and result buffer of a state container,
+// which are accessed as Go slices through a pointer to an array of this size.
+const maxBufferSize = 1 << 30
+
+// mallocBuffer and reallocBuffer allocate the buffers of the state containers,
+// returning nil on failure. They are replaced by tests to simulate allocation failures.
+var (
+	mallocBuffer  = func(n uint32) unsafe.Pointer { return C.malloc(C.size_t(n)) }
+	reallocBuffer = func(p unsafe.Pointer, n uint32) unsafe.Pointer { return C.realloc(p, C.size_t(n)) }
+)
+
 // MakeBuffer allocates a C buffer of size s into p,
 // assuming p is a state container created with NewStateContainer().
 //
 // If p contains a previously allocated buffer, it will be freed before creating the new one.
 // Also, passing a zero size allows freeing a previously allocated buffer, if any.
-func MakeBuffer(p unsafe.Pointer, s uint32) {
-	state := (*C.state)(p)
+// It returns an error if the buffer cannot be allocated, or if s exceeds 1 GiB, in which
+// case p is left without buffer.
+func MakeBuffer(p unsafe.Pointer, s uint32) error {
+	state := getState(p)
 
 	if state.bufLen > 0 {
 		C.free(unsafe.Pointer(state.buf))
 	}
+	state.buf = nil
+	state.bufLen = 0
 
+	if s > maxBufferSize {
+		return NewError(ScapFailure, "a buffer of %d bytes exceeds the maximum of %d bytes", s, maxBufferSize)
+	}
 	if s > 0 {
-		state.buf = (*C.uint8_t)(C.malloc(C.size_t(s)))
+		state.buf = (*C.uint8_t)(mallocBuffer(s))
+		if state.buf == nil {
+			return NewError(ScapFailure, "could not allocate a buffer of %d bytes", s)
+		}
 	}
 
 	state.bufLen = C.uint32_t(s)
+	return nil
+}
+
+// SetBufferGrowth enables the automatic growth of the buffer belonging to p up to max bytes,
+// assuming p is a state container created with NewStateContainer().
+//
+// When enabled, WriteBuffer(), WriteBufferAt() and ReserveBuffer() grow the buffer as
+// needed instead of failing, as long as its size does not exceed max. Growing the buffer
+// invalidates any pointer previously returned by Buffer() and any slice previously returned
+// by BufferBytes() or ReserveBuffer(). A max not greater than the current size disables the growth,
+// which is the default. A max greater than 1 GiB is lowered to 1 GiB.
+func SetBufferGrowth(p unsafe.Pointer, max uint32) {
+	if max > maxBufferSize {
+		max = maxBufferSize
+	}
+	getState(p).bufMax = C.uint32_t(max)
+}
+
+// BufferLen returns the current size of the buffer belonging to p,
+// assuming p is a state container created with NewStateContainer().
+func BufferLen(p unsafe.Pointer) uint32 {
+	return uint32(getState(p).bufLen)
+}
+
+// BufferCap returns the maximum size the buffer belonging to p can reach, that is its current
+// size or the limit set with SetBufferGrowth(), whichever is greater,
+// assuming p is a state container created with NewStateContainer().
+func BufferCap(p unsafe.Pointer) uint32 {
+	state := getState(p)
+	if state.bufMax > state.bufLen {
+		return uint32(state.bufMax)
+	}
+	return uint32(state.bufLen)
+}
+
+// BufferBytes returns the whole C buffer belonging to p as a slice, for the caller to write into,
+// assuming p is a state container created with NewStateContainer().
+func BufferBytes(p unsafe.Pointer) []byte {
+	state := getState(p)
+	if state.buf == nil {
+		return nil
+	}
+	return (*[maxBufferSize]byte)(unsafe.Pointer(state.buf))[:int(state.bufLen):int(state.bufLen)]
+}
+
+// ReserveBuffer returns the n bytes starting at pos of the C buffer belonging to p as a slice,
+// for the caller to write into, assuming p is a state container created with NewStateContainer().
+//
+// If the buffer is too small, it is grown according to SetBufferGrowth(). If it still cannot
+// hold the n bytes, an error wrapping ErrBufferOverflow is returned.
+func ReserveBuffer(p unsafe.Pointer, pos uint32, n uint32) ([]byte, error) {
+	state := getState(p)
+	end := uint64(pos) + uint64(n)
+
+	if end > uint64(state.bufLen) {
+		if end > uint64(BufferCap(p)) {
+			return nil, NewError(ScapFailure, "%d bytes at offset %d do not fit into the %d bytes buffer: %w", n, pos, BufferCap(p), ErrBufferOverflow)
+		}
+		if err := growBuffer(p, uint32(end)); err != nil {
+			return nil, err
+		}
+	}
+
+	return BufferBytes(p)[pos:end:end], nil
+}
+
+// growBuffer grows the buffer of p, preserving its content, so that it can hold at least n bytes.
+func growBuffer(p unsafe.Pointer, n uint32) error {
+	state := getState(p)
+
+	l := uint64(state.bufLen) * 2
+	if l < uint64(n) {
+		l = uint64(n)
+	}
+	if l > uint64(state.bufMax) {
+		l = uint64(state.bufMax)
+	}
+
+	buf := (*C.uint8_t)(reallocBuffer(unsafe.Pointer(state.buf), uint32(l)))
+	if buf == nil {
+		return NewError(ScapFailure, "could not grow the buffer to %d bytes", l)
+	}
+	state.buf = buf
+	state.bufLen = C.uint32_t(l)
+	return nil
+}
+
+// WriteBuffer copies b at the start of the C buffer belonging to p,
+// assuming p is a state container created with NewStateContainer().
+//
+// Unlike CopyToBuffer(), it never truncates b: if b does not fit, even after growing
+// the buffer according to SetBufferGrowth(), nothing is copied and an error wrapping
+// ErrBufferOverflow is returned. It returns the number of bytes copied.
+func WriteBuffer(p unsafe.Pointer, b []byte) (uint32, error) {
+	return WriteBufferAt(p, b, 0)
+}
+
+// WriteBufferAt is like WriteBuffer(), but copies b at the offset pos of the buffer.
+func WriteBufferAt(p unsafe.Pointer, b []byte, pos uint32) (uint32, error) {
+	if uint64(len(b)) > uint64(^uint32(0)) {
+		return 0, NewError(ScapFailure, "%d bytes do not fit into the buffer: %w", len(b), ErrBufferOverflow)
+	}
+	buf, err := ReserveBuffer(p, pos, uint32(len(b)))
+	if err != nil {
+		return 0, err
+	}
+	return uint32(copy(buf, b)), nil
 }
 
 // CopyToBuffer copies bytes from a b into the C buffer belonging to p,
@@ -56,65 +246,408 @@ func MakeBuffer(p unsafe.Pointer, s uint32) {
 //
 // The buffer must be previously created with MakeBuffer().
 // It returns the number of bytes copied, which will be the minimum of the buffer's size and len(b).
+// Use WriteBuffer() to get an error instead of silently truncating b.
 func CopyToBuffer(p unsafe.Pointer, b []byte) uint32 {
-	state := (*C.state)(p)
-	return uint32(copy((*[1 << 30]byte)(unsafe.Pointer(state.buf))[:int(state.bufLen):int(state.bufLen)][:], b))
+	return CopyToBufferAt(p, b, 0)
 }
 
-//
+// CopyToBufferAt is like CopyToBuffer(), but copies b at the offset pos of the buffer.
+// It returns the number of bytes copied, which is zero if pos is beyond the end of the buffer.
 func CopyToBufferAt(p unsafe.Pointer, b []byte, pos uint32) uint32 {
-	state := (*C.state)(p)
-	return uint32(copy((*[1 << 30]byte)(unsafe.Pointer(state.buf))[:int(state.bufLen):int(state.bufLen)][pos:], b))
+	buf := BufferBytes(p)
+	if uint64(pos) > uint64(len(buf)) {
+		return 0
+	}
+	return uint32(copy(buf[pos:], b))
 }
 
 // Buffer returns a pointer to the first element of the C buffer belonging to p, if any,
 // assuming p is a state container created with NewStateContainer().
 func Buffer(p unsafe.Pointer) *byte {
-	return (*byte)((*C.state)(p).buf)
+	return (*byte)(getState(p).buf)
 }
 
-// SetContext sets the given reference ctx (a pointer to a Go allocated memory) into p,
+// minResultBufLen is the initial size of the result buffer of a state container.
+const minResultBufLen = 256
+
+// resultBuffer returns the result buffer of p, grown if needed to hold at least n bytes.
+// It returns an error if the buffer cannot be grown, in which case p is left without result
+// buffer, or if n exceeds 1 GiB.
+func resultBuffer(p unsafe.Pointer, n uint32) ([]byte, error) {
+	state := getState(p)
+
+	if n > maxBufferSize {
+		return nil, NewError(ScapFailure, "a result of %d bytes exceeds the maximum of %d bytes: %w", n, maxBufferSize, ErrBufferOverflow)
+	}
+	if uint32(state.resBufLen) < n {
+		l := uint32(state.resBufLen) * 2
+		if l < minResultBufLen {
+			l = minResultBufLen
+		}
+		if l < n {
+			l = n
+		}
+		if l > maxBufferSize {
+			l = maxBufferSize
+		}
+		C.free(unsafe.Pointer(state.resBuf))
+		state.resBuf = (*C.uint8_t)(mallocBuffer(l))
+		if state.resBuf == nil {
+			state.resBufLen = 0
+			return nil, NewError(ScapFailure, "could not allocate a result buffer of %d bytes", l)
+		}
+		state.resBufLen = C.uint32_t(l)
+	}
+
+	return (*[maxBufferSize]byte)(unsafe.Pointer(state.resBuf))[:n:n], nil
+}
+
+// ResultStr copies s, NULL terminated, into the result buffer belonging to p and returns it,
 // assuming p is a state container created with NewStateContainer().
 //
-// A previously set reference, if any, is removed from p, making it suitable for garbage collecting.
-func SetContext(p unsafe.Pointer, ctx unsafe.Pointer) {
-	state := (*C.state)(p)
+// The result buffer is allocated in C memory and reused (growing as needed) by every call
+// to ResultStr() and ResultBuf() on p, so the returned string is valid until the next
+// of those calls, or until Free(). It is suitable to return strings from
+// plugin_extract_str() without leaking memory.
+//
+// It returns nil if the result buffer cannot be allocated, in which case the error
+// is stored as the last error of p.
+func ResultStr(p unsafe.Pointer, s string) *byte {
+	res, err := resultStr(p, s)
+	if err != nil {
+		SetLastError(p, err)
+	}
+	return res
+}
+
+func resultStr(p unsafe.Pointer, s string) (*byte, error) {
+	b, err := resultBuffer(p, uint32(len(s))+1)
+	if err != nil {
+		return nil, err
+	}
+	b[copy(b, s)] = 0
+	return &b[0], nil
+}
 
-	if state.goMem != nil {
-		peristentPtrs.Delete(state.goMem)
+// ResultBuf copies b into the result buffer belonging to p and returns it,
+// assuming p is a state container created with NewStateContainer().
+//
+// The returned buffer is valid until the next call to ResultStr() or ResultBuf() on p,
+// or until Free(). Like ResultStr(), it returns nil if the result buffer cannot be allocated.
+func ResultBuf(p unsafe.Pointer, b []byte) *byte {
+	res, err := resultBuf(p, b)
+	if err != nil {
+		SetLastError(p, err)
 	}
+	return res
+}
 
-	// implicitly destroy batchCtx when a context is set
-	if state.batchCtx != nil {
-		peristentPtrs.Delete(state.batchCtx)
+func resultBuf(p unsafe.Pointer, b []byte) (*byte, error) {
+	// Always make room for at least one byte, so that a valid pointer is returned
+	// even for empty buffers.
+	l := uint32(len(b))
+	if l == 0 {
+		l = 1
 	}
+	res, err := resultBuffer(p, l)
+	if err != nil {
+		return nil, err
+	}
+	copy(res, b)
+	return &res[0], nil
+}
 
-	state.goMem = ctx
+// setHandle replaces the handle stored at h with a new one for v,
+// deleting the previous one, if any. A nil v just deletes it.
+func setHandle(h *C.uintptr_t, v interface{}) {
+	if *h != 0 {
+		Handle(*h).Delete()
+		*h = 0
+	}
+	if v != nil {
+		*h = C.uintptr_t(NewHandle(v))
+	}
+}
 
+// handleValue returns the value referred to by the handle h, or nil if there is none.
+func handleValue(h C.uintptr_t) interface{} {
+	if h == 0 {
+		return nil
+	}
+	return Handle(h).Value()
+}
+
+// SetContextValue sets the given Go value ctx as the context of p,
+// assuming p is a state container created with NewStateContainer().
+//
+// The state container only stores a Handle referring to ctx, which is kept alive
+// until replaced by another context or Free() is called.
+// A previously set context, if any, is removed from p, making it suitable for garbage collecting.
+// Passing nil just removes it.
+func SetContextValue(p unsafe.Pointer, ctx interface{}) {
+	state := getState(p)
+
+	setHandle(&state.goMem, ctx)
+
+	// implicitly destroy batchCtx when a context is set, and
+	// create a new one when a non-empty context is set
 	if ctx != nil {
-		peristentPtrs.Store(ctx, ctx)
-		// implicitly create a new batchCtx when a non-empty context is set
-		state.batchCtx = unsafe.Pointer(&batchContext{})
-		peristentPtrs.Store(state.batchCtx, state.batchCtx)
+		setHandle(&state.batchCtx, &batchContext{})
+	} else {
+		setHandle(&state.batchCtx, nil)
 	}
 }
 
+// ContextValue returns the Go value, if any, previously set as the context of p with
+// SetContextValue(), assuming p is a state container created with NewStateContainer().
+//
+// Intended usage as in the following example:
+//
+//     m := sinsp.ContextValue(oState).(*pluginCtx)
+//
+func ContextValue(p unsafe.Pointer) interface{} {
+	return handleValue(getState(p).goMem)
+}
+
+// SetContext sets the given reference ctx (a pointer to a Go allocated memory) into p,
+// assuming p is a state container created with NewStateContainer().
+//
+// A previously set reference, if any, is removed from p, making it suitable for garbage collecting.
+// It is equivalent to SetContextValue() with ctx as the value.
+func SetContext(p unsafe.Pointer, ctx unsafe.Pointer) {
+	if ctx == nil {
+		SetContextValue(p, nil)
+		return
+	}
+	SetContextValue(p, ctx)
+}
+
 func getBatchCtx(p unsafe.Pointer) *batchContext {
-	state := (*C.state)(p)
-	// we assume batchCtx was made by SetContext()
-	return (*batchContext)(state.batchCtx)
+	// we assume batchCtx was made by SetContextValue()
+	return handleValue(getState(p).batchCtx).(*batchContext)
+}
+
+// batchCtxOf is like getBatchCtx, but returns nil if p has no context.
+func batchCtxOf(p unsafe.Pointer) *batchContext {
+	bCtx, _ := handleValue(getState(p).batchCtx).(*batchContext)
+	return bCtx
 }
 
 // Context returns a pointer to Go allocated memory, if any, previously assigned into p with SetContext(),
 // assuming p is a state container created with NewStateContainer().
 func Context(p unsafe.Pointer) unsafe.Pointer {
-	return (*C.state)(p).goMem
+	ctx, _ := ContextValue(p).(unsafe.Pointer)
+	return ctx
+}
+
+// SetFields binds the field registry r to p, assuming p is a state container
+// created with NewStateContainer(). The registry is used by ExtractStr() and
+// ExtractU64() to serve extraction requests for p.
+//
+// A previously set registry, if any, is removed from p. Passing nil just removes it.
+func SetFields(p unsafe.Pointer, r *FieldRegistry) {
+	if r == nil {
+		setHandle(&getState(p).fields, nil)
+		return
+	}
+	setHandle(&getState(p).fields, r)
+}
+
+// Fields returns the field registry previously bound to p with SetFields(), if any,
+// assuming p is a state container created with NewStateContainer().
+func Fields(p unsafe.Pointer) *FieldRegistry {
+	r, _ := handleValue(getState(p).fields).(*FieldRegistry)
+	return r
+}
+
+// SetLastError stores the message of err as the last error of p,
+// assuming p is a state container created with NewStateContainer().
+//
+// The message is also recorded as the most recent error of the plugin, as
+// returned by LastErrorStr(nil). A nil p only records the most recent error,
+// which is useful when no state is available (e.g. in plugin_get_fields()).
+// A nil err clears the last error of p, or the most recent error if p is nil.
+//
+// SetLastError is safe for concurrent use.
+func SetLastError(p unsafe.Pointer, err error) {
+	lastErrMu.Lock()
+	defer lastErrMu.Unlock()
+
+	if p != nil {
+		state := getState(p)
+		if state.lastErr != nil {
+			C.free(unsafe.Pointer(state.lastErr))
+			state.lastErr = nil
+		}
+		if err != nil {
+			state.lastErr = C.CString(err.Error())
+		}
+	}
+
+	if err != nil || p == nil {
+		if recentLastErr != nil {
+			C.free(unsafe.Pointer(recentLastErr))
+			recentLastErr = nil
+		}
+		if err != nil {
+			recentLastErr = C.CString(err.Error())
+		}
+	}
+}
+
+// LastError returns an error carrying the message previously stored into p with SetLastError(),
+// or nil if there is none, assuming p is a state container created with NewStateContainer().
+// If p is nil, it returns the error most recently stored with SetLastError() on any state.
+func LastError(p unsafe.Pointer) error {
+	lastErrMu.Lock()
+	defer lastErrMu.Unlock()
+
+	s := recentLastErr
+	if p != nil {
+		s = getState(p).lastErr
+	}
+	if s != nil {
+		return errors.New(C.GoString(s))
+	}
+	return nil
+}
+
+// LastErrorStr returns a copy of the last error message of p as a NULL terminated C string,
+// or nil if there is none, assuming p is a state container created with NewStateContainer().
+// The copy is owned by p and stays valid until the next LastErrorStr() or Free() on p, so
+// that errors set in the meantime, e.g. by the async extractor worker, cannot invalidate it.
+//
+// If p is nil, it returns a copy of the message most recently stored with SetLastError()
+// on any state, which stays valid until the next LastErrorStr(nil).
+//
+// Intended usage as in the following example:
+//
+//     //export plugin_get_last_error
+//     func plugin_get_last_error(pState unsafe.Pointer) *C.char {
+//     	return (*C.char)(unsafe.Pointer(sinsp.LastErrorStr(pState)))
+//     }
+//
+func LastErrorStr(p unsafe.Pointer) *byte {
+	lastErrMu.Lock()
+	defer lastErrMu.Unlock()
+
+	src, dst := recentLastErr, &recentLastErrReported
+	if p != nil {
+		state := getState(p)
+		src, dst = state.lastErr, &state.lastErrReported
+	}
+	if *dst != nil {
+		C.free(unsafe.Pointer(*dst))
+		*dst = nil
+	}
+	if src != nil {
+		*dst = C.CString(C.GoString(src))
+	}
+	return (*byte)(unsafe.Pointer(*dst))
+}
+
+// freeLastError releases the last error of p and the copy returned by LastErrorStr().
+func freeLastError(p unsafe.Pointer) {
+	lastErrMu.Lock()
+	defer lastErrMu.Unlock()
+
+	state := getState(p)
+	C.free(unsafe.Pointer(state.lastErr))
+	C.free(unsafe.Pointer(state.lastErrReported))
+	state.lastErr = nil
+	state.lastErrReported = nil
+}
+
+// SetDecodeCache binds the decode cache c to p, assuming p is a state container
+// created with NewStateContainer(). The cache is used by ExtractStr(), ExtractU64()
+// and ExtractBuf() to serve ExtractRequest.Decoded() for p.
+//
+// A previously set cache, if any, is removed from p. Passing nil just removes it.
+func SetDecodeCache(p unsafe.Pointer, c *DecodeCache) {
+	if c == nil {
+		setHandle(&getState(p).decodeCache, nil)
+		return
+	}
+	setHandle(&getState(p).decodeCache, c)
+}
+
+// DecodeCacheOf returns the decode cache previously bound to p with SetDecodeCache(), if any,
+// assuming p is a state container created with NewStateContainer().
+func DecodeCacheOf(p unsafe.Pointer) *DecodeCache {
+	c, _ := handleValue(getState(p).decodeCache).(*DecodeCache)
+	return c
+}
+
+func setAsyncExtractors(p unsafe.Pointer, w *AsyncWorker) {
+	if w == nil {
+		setHandle(&getState(p).async, nil)
+		return
+	}
+	setHandle(&getState(p).async, w)
+}
+
+// AsyncExtractors returns the async extractor worker bound to p by RegisterAsyncExtractors(),
+// RegisterAsyncBufExtractors(), RegisterAsyncFieldExtractors() or
+// RegisterAsyncFieldBufExtractors(), if any, assuming p is
+// a state container created with NewStateContainer().
+func AsyncExtractors(p unsafe.Pointer) *AsyncWorker {
+	w, _ := handleValue(getState(p).async).(*AsyncWorker)
+	return w
+}
+
+func setRing(p unsafe.Pointer, r unsafe.Pointer) {
+	getState(p).ring = r
+}
+
+func ringOf(p unsafe.Pointer) unsafe.Pointer {
+	return getState(p).ring
+}
+
+// panics may be counted from the async extractor goroutine as well as from
+// the host threads, hence the atomic access.
+func addPanic(p unsafe.Pointer) uint32 {
+	return atomic.AddUint32((*uint32)(unsafe.Pointer(&getState(p).panics)), 1)
+}
+
+func panics(p unsafe.Pointer) uint32 {
+	return atomic.LoadUint32((*uint32)(unsafe.Pointer(&getState(p).panics)))
 }
 
 // Free disposes of any C and Go memory assigned to p and finally free P,
 // assuming p is a state container created with NewStateContainer().
+//
+// If an async extractor worker is bound to p, Free stops it and waits for the request
+// in progress, if any, before releasing anything. If the request does not complete
+// within AsyncStopTimeout, p is not released at all, since it is still in use. The leak
+// is then stored as the last error of p and, when built with the sinsp_debug tag,
+// reported by DebugReport().
+//
+// When built with the sinsp_debug tag, Free panics if p has already been freed, and the memory
+// of p is not released so that any later use of p can be detected.
 func Free(p unsafe.Pointer) {
+	if debugMode {
+		debugCheckFree(p, func() uint32 { return uint32((*C.state)(p).magic) })
+	}
+	if !stopAsyncExtractors(p) {
+		err := NewError(ScapFailure, "state container %p not released, since its async extraction was still in progress after %s", p, asyncStopTimeout)
+		SetLastError(p, err)
+		if debugMode {
+			debugLeakState(p, err.Error())
+		}
+		return
+	}
+	setAsyncExtractors(p, nil)
 	MakeBuffer(p, 0)
+	MakeRing(p, 0)
 	SetContext(p, nil)
+	SetFields(p, nil)
+	SetDecodeCache(p, nil)
+	freeLastError(p)
+	C.free(unsafe.Pointer(getState(p).resBuf))
+	if debugMode {
+		(*C.state)(p).magic = C.uint32_t(stateFreedMagic)
+		debugFreeState(p)
+		return
+	}
 	C.free(p)
 }
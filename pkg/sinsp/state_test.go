@@ -0,0 +1,270 @@
+package sinsp
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+	"unsafe"
+)
+
+func TestResultStr(t *testing.T) {
+	pState := NewStateContainer()
+	defer Free(pState)
+
+	tests := []struct {
+		s      string
+		bufLen uint32
+	}{
+		{"abc", minResultBufLen},
+		// Reused
+		{"", minResultBufLen},
+		{strings.Repeat("x", minResultBufLen-1), minResultBufLen},
+		// Doubled, or grown to the required size if larger
+		{strings.Repeat("y", minResultBufLen), 2 * minResultBufLen},
+		{strings.Repeat("z", 5*minResultBufLen), 5*minResultBufLen + 1},
+		// Never shrunk
+		{"abc", 5*minResultBufLen + 1},
+	}
+	var prev *byte
+	for i, tt := range tests {
+		res := ResultStr(pState, tt.s)
+		if res == nil {
+			t.Fatalf("%d: got nil: %v", i, LastError(pState))
+		}
+		if got := goString(res); got != tt.s {
+			t.Fatalf("%d: got %q, want %q", i, got, tt.s)
+		}
+		_, bufLen, _, _ := stateInfo(pState)
+		if bufLen != tt.bufLen {
+			t.Fatalf("%d: got a result buffer of %d bytes, want %d", i, bufLen, tt.bufLen)
+		}
+		if i > 0 && tt.bufLen == tests[i-1].bufLen && res != prev {
+			t.Fatalf("%d: the result buffer was not reused", i)
+		}
+		prev = res
+	}
+}
+
+func TestResultBuf(t *testing.T) {
+	pState := NewStateContainer()
+	defer Free(pState)
+
+	// An empty buffer still returns a valid pointer
+	res := ResultBuf(pState, nil)
+	if res == nil {
+		t.Fatalf("empty: got nil: %v", LastError(pState))
+	}
+
+	b := bytes.Repeat([]byte{0, 1, 2}, minResultBufLen)
+	if res = ResultBuf(pState, b); res == nil {
+		t.Fatalf("got nil: %v", LastError(pState))
+	}
+	if got := goBytes(res, uint32(len(b))); !bytes.Equal(got, b) {
+		t.Fatalf("got %v, want %v", got, b)
+	}
+	if _, bufLen, _, _ := stateInfo(pState); bufLen != uint32(len(b)) {
+		t.Fatalf("got a result buffer of %d bytes, want %d", bufLen, len(b))
+	}
+
+	// ResultStr and ResultBuf share the same buffer
+	if s := ResultStr(pState, "abc"); s != res || goString(s) != "abc" {
+		t.Fatalf("ResultStr: got %p (%q), want the result buffer %p", s, goString(s), res)
+	}
+	if r := ResultBuf(pState, []byte("de")); r != res || !bytes.Equal(goBytes(r, 2), []byte("de")) {
+		t.Fatalf("ResultBuf: got %p, want the result buffer %p", r, res)
+	}
+}
+
+// checkBuffer checks that the buffer of p has size bytes, starting with prefix.
+func checkBuffer(t *testing.T, p unsafe.Pointer, size uint32, prefix string) {
+	t.Helper()
+	b := BufferBytes(p)
+	if BufferLen(p) != size || uint32(len(b)) != size {
+		t.Fatalf("got a buffer of %d bytes (%d as slice), want %d", BufferLen(p), len(b), size)
+	}
+	if !bytes.HasPrefix(b, []byte(prefix)) {
+		t.Fatalf("got buffer %q, want it to start with %q", b, prefix)
+	}
+}
+
+// checkOverflow checks that err reports a buffer overflow.
+func checkOverflow(t *testing.T, err error) {
+	t.Helper()
+	if !errors.Is(err, ErrBufferOverflow) || ErrorCode(err) != ScapFailure {
+		t.Fatalf("got %v, want a buffer overflow", err)
+	}
+}
+
+func TestWriteBuffer(t *testing.T) {
+	pState := NewStateContainer()
+	defer Free(pState)
+	if err := MakeBuffer(pState, 8); err != nil {
+		t.Fatalf("MakeBuffer: %s", err)
+	}
+
+	if n, err := WriteBuffer(pState, []byte("abcd")); n != 4 || err != nil {
+		t.Fatalf("WriteBuffer: got (%d, %v)", n, err)
+	}
+	if n, err := WriteBufferAt(pState, []byte("efgh"), 4); n != 4 || err != nil {
+		t.Fatalf("WriteBufferAt: got (%d, %v)", n, err)
+	}
+	checkBuffer(t, pState, 8, "abcdefgh")
+
+	// Without growth, nothing is copied when b does not fit
+	_, err := WriteBuffer(pState, []byte("123456789"))
+	checkOverflow(t, err)
+	_, err = WriteBufferAt(pState, []byte("12"), 7)
+	checkOverflow(t, err)
+	_, err = WriteBufferAt(pState, nil, 9)
+	checkOverflow(t, err)
+	_, err = WriteBufferAt(pState, []byte("1"), ^uint32(0))
+	checkOverflow(t, err)
+	checkBuffer(t, pState, 8, "abcdefgh")
+
+	b, err := ReserveBuffer(pState, 2, 3)
+	if err != nil || len(b) != 3 || cap(b) != 3 {
+		t.Fatalf("ReserveBuffer: got (%d bytes, cap %d, %v)", len(b), cap(b), err)
+	}
+	copy(b, "xyz")
+	checkBuffer(t, pState, 8, "abxyzfgh")
+	if b, err := ReserveBuffer(pState, 8, 0); err != nil || len(b) != 0 {
+		t.Fatalf("ReserveBuffer at the end: got (%d bytes, %v)", len(b), err)
+	}
+	_, err = ReserveBuffer(pState, 6, 3)
+	checkOverflow(t, err)
+
+	// CopyToBuffer truncates instead
+	if n := CopyToBufferAt(pState, []byte("123"), 6); n != 2 {
+		t.Fatalf("CopyToBufferAt: got %d bytes, want 2", n)
+	}
+	checkBuffer(t, pState, 8, "abxyzf12")
+}
+
+func TestBufferGrowth(t *testing.T) {
+	pState := NewStateContainer()
+	defer Free(pState)
+	if err := MakeBuffer(pState, 8); err != nil {
+		t.Fatalf("MakeBuffer: %s", err)
+	}
+	if n, err := WriteBuffer(pState, []byte("abcdefgh")); n != 8 || err != nil {
+		t.Fatalf("WriteBuffer: got (%d, %v)", n, err)
+	}
+
+	SetBufferGrowth(pState, 20)
+	if c := BufferCap(pState); c != 20 {
+		t.Fatalf("got a capacity of %d bytes, want 20", c)
+	}
+
+	// The size doubles, preserving the content...
+	if n, err := WriteBufferAt(pState, []byte("ij"), 8); n != 2 || err != nil {
+		t.Fatalf("WriteBufferAt: got (%d, %v)", n, err)
+	}
+	checkBuffer(t, pState, 16, "abcdefghij")
+
+	// ...up to the limit
+	if b, err := ReserveBuffer(pState, 15, 5); err != nil || len(b) != 5 {
+		t.Fatalf("ReserveBuffer: got (%d bytes, %v)", len(b), err)
+	}
+	checkBuffer(t, pState, 20, "abcdefghij")
+
+	_, err := WriteBufferAt(pState, []byte("k"), 20)
+	checkOverflow(t, err)
+	checkBuffer(t, pState, 20, "abcdefghij")
+
+	// A limit not greater than the size disables the growth
+	SetBufferGrowth(pState, 4)
+	if c := BufferCap(pState); c != 20 {
+		t.Fatalf("got a capacity of %d bytes, want 20", c)
+	}
+
+	// Growing a state without buffer allocates it
+	other := NewStateContainer()
+	defer Free(other)
+	SetBufferGrowth(other, 64)
+	if n, err := WriteBufferAt(other, []byte("abc"), 2); n != 3 || err != nil {
+		t.Fatalf("WriteBufferAt without buffer: got (%d, %v)", n, err)
+	}
+	if BufferLen(other) != 5 || !bytes.Equal(BufferBytes(other)[2:], []byte("abc")) {
+		t.Fatalf("got buffer %q", BufferBytes(other))
+	}
+}
+
+func TestBufferMaxSize(t *testing.T) {
+	pState := NewStateContainer()
+	defer Free(pState)
+
+	// No allocation is even attempted beyond the maximum size
+	malloc, realloc := mallocBuffer, reallocBuffer
+	defer func() { mallocBuffer, reallocBuffer = malloc, realloc }()
+	mallocBuffer = func(n uint32) unsafe.Pointer { t.Fatalf("allocating %d bytes", n); return nil }
+	reallocBuffer = func(p unsafe.Pointer, n uint32) unsafe.Pointer { t.Fatalf("growing to %d bytes", n); return nil }
+
+	if err := MakeBuffer(pState, maxBufferSize+1); err == nil {
+		t.Fatalf("MakeBuffer accepted a buffer of %d bytes", maxBufferSize+1)
+	}
+	if BufferLen(pState) != 0 || BufferBytes(pState) != nil {
+		t.Fatalf("MakeBuffer: left a buffer of %d bytes", BufferLen(pState))
+	}
+
+	SetBufferGrowth(pState, ^uint32(0))
+	if c := BufferCap(pState); c != maxBufferSize {
+		t.Fatalf("got a capacity of %d bytes, want %d", c, maxBufferSize)
+	}
+	_, err := ReserveBuffer(pState, maxBufferSize, 1)
+	checkOverflow(t, err)
+
+	if _, err := resultBuffer(pState, maxBufferSize+1); !errors.Is(err, ErrBufferOverflow) {
+		t.Fatalf("resultBuffer: got %v, want an overflow", err)
+	}
+}
+
+func TestBufferAllocFailure(t *testing.T) {
+	pState := NewStateContainer()
+	defer Free(pState)
+	if err := MakeBuffer(pState, 8); err != nil {
+		t.Fatalf("MakeBuffer: %s", err)
+	}
+	WriteBuffer(pState, []byte("abcdefgh"))
+	SetBufferGrowth(pState, 64)
+
+	malloc, realloc := mallocBuffer, reallocBuffer
+	defer func() { mallocBuffer, reallocBuffer = malloc, realloc }()
+	mallocBuffer = func(n uint32) unsafe.Pointer { return nil }
+	reallocBuffer = func(p unsafe.Pointer, n uint32) unsafe.Pointer { return nil }
+
+	// The buffer is left untouched when it cannot grow
+	_, err := WriteBufferAt(pState, []byte("ij"), 8)
+	if err == nil || errors.Is(err, ErrBufferOverflow) || err.Error() != "could not grow the buffer to 16 bytes" {
+		t.Fatalf("WriteBufferAt: got %v, want an allocation failure", err)
+	}
+	if _, err := ReserveBuffer(pState, 0, 9); err == nil {
+		t.Fatalf("ReserveBuffer: got no error")
+	}
+	checkBuffer(t, pState, 8, "abcdefgh")
+
+	// A failed MakeBuffer leaves p without buffer
+	if err := MakeBuffer(pState, 16); err == nil || err.Error() != "could not allocate a buffer of 16 bytes" {
+		t.Fatalf("MakeBuffer: got %v, want an allocation failure", err)
+	}
+	if Buffer(pState) != nil || BufferLen(pState) != 0 || BufferBytes(pState) != nil {
+		t.Fatalf("MakeBuffer: left a buffer of %d bytes", BufferLen(pState))
+	}
+
+	// The result buffer too
+	if res := ResultStr(pState, "abc"); res != nil {
+		t.Fatalf("ResultStr: got a result")
+	}
+	if err := LastError(pState); err == nil || err.Error() != "could not allocate a result buffer of 256 bytes" {
+		t.Fatalf("ResultStr: got last error %v", err)
+	}
+
+	// Allocations work again afterwards
+	mallocBuffer, reallocBuffer = malloc, realloc
+	if res := ResultBuf(pState, []byte("abc")); res == nil {
+		t.Fatalf("ResultBuf: got nil: %v", LastError(pState))
+	}
+	if err := MakeBuffer(pState, 16); err != nil {
+		t.Fatalf("MakeBuffer: %s", err)
+	}
+}
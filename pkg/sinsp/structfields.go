@@ -0,0 +1,389 @@
+package sinsp
+
+import (
+	"encoding/hex"
+	"fmt"
+	"net"
+	"reflect"
+	"strings"
+	"time"
+)
+
+var (
+	timeType  = reflect.TypeOf(time.Time{})
+	durType   = reflect.TypeOf(time.Duration(0))
+	ipType    = reflect.TypeOf(net.IP{})
+	ipNetType = reflect.TypeOf(net.IPNet{})
+)
+
+// Path steps selecting the slice element or the map value given by the field argument.
+const (
+	argIndex = -1
+	argKey   = -2
+)
+
+// structField is a field declared by a sinsp tag, located by its path in the struct.
+type structField struct {
+	// struct type of the decoded events
+	root reflect.Type
+	// indexes of the struct fields to follow, or argIndex/argKey
+	path []int
+}
+
+// value returns the value of the field in the event of req, or false if a nil
+// pointer, an out of range slice index or a missing map key is met along the path.
+func (f *structField) value(req *ExtractRequest) (reflect.Value, bool, error) {
+	decoded, err := req.Decoded()
+	if err != nil {
+		return reflect.Value{}, false, err
+	}
+	v := reflect.ValueOf(decoded)
+	for v.Kind() == reflect.Ptr && !v.IsNil() {
+		v = v.Elem()
+	}
+	if !v.IsValid() || v.Type() != f.root {
+		return reflect.Value{}, false, fmt.Errorf("field %s: decoded event of type %T, want %s", req.Field.Name, decoded, f.root)
+	}
+
+	for _, i := range f.path {
+		for v.Kind() == reflect.Ptr {
+			if v.IsNil() {
+				return v, false, nil
+			}
+			v = v.Elem()
+		}
+		switch i {
+		case argIndex:
+			if req.ArgIndex >= uint64(v.Len()) {
+				return v, false, nil
+			}
+			v = v.Index(int(req.ArgIndex))
+		case argKey:
+			v = v.MapIndex(reflect.ValueOf(req.ArgKey).Convert(v.Type().Key()))
+			if !v.IsValid() {
+				return v, false, nil
+			}
+		default:
+			v = v.Field(i)
+		}
+	}
+
+	for v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			return v, false, nil
+		}
+		v = v.Elem()
+	}
+	return v, true, nil
+}
+
+// parseFieldTag parses a sinsp tag of the form "name,desc=...,display=...".
+// Since descriptions may contain commas, a part that is not an option
+// belongs to the previous one.
+func parseFieldTag(tag string) (name, desc, display string) {
+	parts := strings.Split(tag, ",")
+	name = parts[0]
+	var cur *string
+	for _, p := range parts[1:] {
+		switch {
+		case strings.HasPrefix(p, "desc="):
+			cur = &desc
+			*cur = strings.TrimPrefix(p, "desc=")
+		case strings.HasPrefix(p, "display="):
+			cur = &display
+			*cur = strings.TrimPrefix(p, "display=")
+		case cur != nil:
+			*cur += "," + p
+		}
+	}
+	return
+}
+
+// StructFields returns the fields declared by the sinsp tags of the struct
+// type of proto, which must be a struct or a pointer to a struct.
+//
+// The fields are extracted from the value returned by ExtractRequest.Decoded(), so the
+// plugin must implement Decoder, decoding the payload of each event into a struct of
+// that type or a pointer to it. The struct is decoded once per evtnum thanks to the
+// decode cache of the plugin state, and every field is extracted from the struct member
+// it tags. The type of each field follows the type of its member: strings, signed and
+// unsigned integers, bools, floats, time.Time and time.Duration are supported, as well
+// as pointers to them, which make the field not present when nil. Members that are not
+// pointers are always present, holding their zero value if the payload lacks them, except
+// for a zero time.Time, which is not present.
+//
+// net.IP, net.IPNet and []byte members, including other byte slice types such as
+// json.RawMessage, are supported too, and declare string fields: the address and the
+// network are rendered in their usual notation, and the bytes in hexadecimal. An empty
+// net.IP, or a net.IPNet with an empty IP, is not present.
+//
+// Members that are structs, or pointers to structs, are walked recursively, whether
+// tagged or not, unless they are embedded structs of unexported types. A member whose
+// struct type is already being walked, as the Next member of a linked list node, is
+// skipped so that recursive types end the walk. Members that are slices of the supported
+// types, or of structs, declare fields requiring the index of the element as argument,
+// like myplugin.tags[1].
+// Likewise, maps with string keys declare fields requiring the key as argument,
+// like myplugin.labels[app]. Only one slice or map is allowed along the path of a field,
+// and the slices and maps of structs declaring no field are ignored.
+//
+// Intended usage as in the following example:
+//
+//     type event struct {
+//     	User struct {
+//     		Name string `json:"name" sinsp:"myplugin.user.name,desc=User name"`
+//     		UID  uint64 `json:"uid" sinsp:"myplugin.user.uid,desc=User ID"`
+//     	} `json:"user"`
+//     	Tags   []string          `json:"tags" sinsp:"myplugin.tags,desc=Event tags"`
+//     	Labels map[string]string `json:"labels" sinsp:"myplugin.labels,desc=Event labels"`
+//     }
+//
+//     func (p *pluginCtx) Fields() []sinsp.Field {
+//     	fields, err := sinsp.StructFields(event{})
+//     	if err != nil {
+//     		return sinsp.FieldsError(err)
+//     	}
+//     	return fields
+//     }
+//
+//     func (p *pluginCtx) Decode(data []byte) (interface{}, error) {
+//     	var evt event
+//     	err := json.Unmarshal(data, &evt)
+//     	return &evt, err
+//     }
+//
+func StructFields(proto interface{}) ([]Field, error) {
+	t := reflect.TypeOf(proto)
+	for t != nil && t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t == nil || t.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("StructFields requires a struct, got %T", proto)
+	}
+	return structFields(t, t, nil, nil, map[reflect.Type]bool{})
+}
+
+// structFields returns the fields declared in the struct type t, found at path
+// in the decoded struct of type root. arg is the argument taken by the fields, if any.
+// onPath holds the struct types being walked from root down to t.
+func structFields(root reflect.Type, t reflect.Type, path []int, arg *FieldArg, onPath map[reflect.Type]bool) ([]Field, error) {
+	onPath[t] = true
+	defer delete(onPath, t)
+
+	var fields []Field
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		if sf.PkgPath != "" {
+			// unexported, including the embedded structs of unexported types, whose
+			// members cannot be read through reflection even if they are exported
+			continue
+		}
+
+		p := append(append([]int{}, path...), i)
+		ft := sf.Type
+		for ft.Kind() == reflect.Ptr {
+			ft = ft.Elem()
+		}
+
+		elemArg, step := containerArg(ft)
+		leaf := memberLeaf(ft)
+		isStruct := isWalkedStruct(leaf)
+		tag, tagged := sf.Tag.Lookup("sinsp")
+		if !isStruct && (!tagged || tag == "-") {
+			continue
+		}
+		if isStruct && onPath[leaf] {
+			// recursive type, whose members are already being walked
+			continue
+		}
+
+		fieldArg := arg
+		if elemArg != nil {
+			if isStruct && !hasFieldTags(leaf, onPath) {
+				// no field is declared in the elements, which are never accessed
+				continue
+			}
+			switch {
+			case arg != nil:
+				return nil, fmt.Errorf("field %s: nested slices and maps are not supported", sf.Name)
+			case step == argKey && ft.Key().Kind() != reflect.String:
+				return nil, fmt.Errorf("field %s: map keys must be strings", sf.Name)
+			}
+			fieldArg = elemArg
+			p = append(p, step)
+		}
+
+		if isStruct {
+			nested, err := structFields(root, leaf, p, fieldArg, onPath)
+			if err != nil {
+				return nil, err
+			}
+			fields = append(fields, nested...)
+			continue
+		}
+
+		name, desc, display := parseFieldTag(tag)
+		if name == "" {
+			return nil, fmt.Errorf("field %s: the sinsp tag has no name", sf.Name)
+		}
+
+		f, err := newStructField(&structField{root: root, path: p}, leaf)
+		if err != nil {
+			return nil, fmt.Errorf("field %s: %s", sf.Name, err.Error())
+		}
+		f.Name, f.Desc, f.Display, f.Arg = name, desc, display, fieldArg
+		fields = append(fields, f)
+	}
+	return fields, nil
+}
+
+// containerArg returns the argument taken by the fields declared in the elements of the
+// member type t and the path step accessing them, or nil if t is not a slice or a map.
+// Slices of bytes, including net.IP, are decoded as a single value instead.
+func containerArg(t reflect.Type) (*FieldArg, int) {
+	switch {
+	case t.Kind() == reflect.Slice && t.Elem().Kind() != reflect.Uint8:
+		return &FieldArg{Required: true, Index: true}, argIndex
+	case t.Kind() == reflect.Map:
+		return &FieldArg{Required: true, Key: true}, argKey
+	default:
+		return nil, 0
+	}
+}
+
+// memberLeaf returns the type of the values of the member type t, that is t itself,
+// or the type of its elements if it is a slice or a map, without pointers.
+func memberLeaf(t reflect.Type) reflect.Type {
+	if arg, _ := containerArg(t); arg != nil {
+		t = t.Elem()
+	}
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	return t
+}
+
+// isWalkedStruct reports whether the members of t are walked, rather than t being a value.
+func isWalkedStruct(t reflect.Type) bool {
+	return t.Kind() == reflect.Struct && t != timeType && t != ipNetType
+}
+
+// hasFieldTags reports whether the struct type t declares any field, directly or in
+// the structs it contains, except for the ones in onPath.
+func hasFieldTags(t reflect.Type, onPath map[reflect.Type]bool) bool {
+	onPath[t] = true
+	defer delete(onPath, t)
+
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		if sf.PkgPath != "" {
+			continue
+		}
+		ft := sf.Type
+		for ft.Kind() == reflect.Ptr {
+			ft = ft.Elem()
+		}
+		leaf := memberLeaf(ft)
+		if isWalkedStruct(leaf) {
+			if !onPath[leaf] && hasFieldTags(leaf, onPath) {
+				return true
+			}
+		} else if tag, tagged := sf.Tag.Lookup("sinsp"); tagged && tag != "-" {
+			return true
+		}
+	}
+	return false
+}
+
+// newStructField returns the Field extracting sf, whose member has type t.
+func newStructField(sf *structField, t reflect.Type) (Field, error) {
+	switch {
+	case t == timeType:
+		return Field{ExtractAbsTime: func(req *ExtractRequest) (time.Time, bool, error) {
+			v, ok, err := sf.value(req)
+			if !ok {
+				return time.Time{}, false, err
+			}
+			t := v.Interface().(time.Time)
+			return t, !t.IsZero(), nil
+		}}, nil
+	case t == durType:
+		return Field{ExtractRelTime: func(req *ExtractRequest) (time.Duration, bool, error) {
+			v, ok, err := sf.value(req)
+			if !ok {
+				return 0, false, err
+			}
+			return time.Duration(v.Int()), true, nil
+		}}, nil
+	case t == ipType:
+		return Field{ExtractStr: func(req *ExtractRequest) (string, bool, error) {
+			v, ok, err := sf.value(req)
+			if !ok || v.Len() == 0 {
+				return "", false, err
+			}
+			return net.IP(v.Bytes()).String(), true, nil
+		}}, nil
+	case t == ipNetType:
+		return Field{ExtractStr: func(req *ExtractRequest) (string, bool, error) {
+			v, ok, err := sf.value(req)
+			if !ok || v.Field(0).Len() == 0 {
+				return "", false, err
+			}
+			n := net.IPNet{IP: v.Field(0).Bytes(), Mask: v.Field(1).Bytes()}
+			return n.String(), true, nil
+		}}, nil
+	case t.Kind() == reflect.Slice && t.Elem().Kind() == reflect.Uint8:
+		return Field{ExtractStr: func(req *ExtractRequest) (string, bool, error) {
+			v, ok, err := sf.value(req)
+			if !ok {
+				return "", false, err
+			}
+			return hex.EncodeToString(v.Bytes()), true, nil
+		}}, nil
+	}
+
+	switch t.Kind() {
+	case reflect.String:
+		return Field{ExtractStr: func(req *ExtractRequest) (string, bool, error) {
+			v, ok, err := sf.value(req)
+			if !ok {
+				return "", false, err
+			}
+			return v.String(), true, nil
+		}}, nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return Field{ExtractU64: func(req *ExtractRequest) (uint64, bool, error) {
+			v, ok, err := sf.value(req)
+			if !ok {
+				return 0, false, err
+			}
+			return v.Uint(), true, nil
+		}}, nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return Field{ExtractInt64: func(req *ExtractRequest) (int64, bool, error) {
+			v, ok, err := sf.value(req)
+			if !ok {
+				return 0, false, err
+			}
+			return v.Int(), true, nil
+		}}, nil
+	case reflect.Bool:
+		return Field{ExtractBool: func(req *ExtractRequest) (bool, bool, error) {
+			v, ok, err := sf.value(req)
+			if !ok {
+				return false, false, err
+			}
+			return v.Bool(), true, nil
+		}}, nil
+	case reflect.Float32, reflect.Float64:
+		return Field{ExtractDouble: func(req *ExtractRequest) (float64, bool, error) {
+			v, ok, err := sf.value(req)
+			if !ok {
+				return 0, false, err
+			}
+			return v.Float(), true, nil
+		}}, nil
+	default:
+		return Field{}, fmt.Errorf("unsupported type %s", t.String())
+	}
+}
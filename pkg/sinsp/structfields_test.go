@@ -0,0 +1,265 @@
+package sinsp
+
+import (
+	"encoding/json"
+	"net"
+	"strings"
+	"testing"
+)
+
+type structEvent struct {
+	User string            `json:"user" sinsp:"test.user,desc=User"`
+	UID  uint64            `json:"uid" sinsp:"test.uid,desc=UID"`
+	Tags []string          `json:"tags" sinsp:"test.tag,desc=Tag"`
+	Env  map[string]string `json:"env" sinsp:"test.env,desc=Env"`
+}
+
+func TestStructFieldsDecodeCache(t *testing.T) {
+	fields, err := StructFields(structEvent{})
+	if err != nil {
+		t.Fatalf("StructFields: %s", err)
+	}
+	r, err := NewFieldRegistry(fields)
+	if err != nil {
+		t.Fatalf("NewFieldRegistry: %s", err)
+	}
+
+	decodes := 0
+	cache := NewDecodeCache(DefaultDecodeCacheSize, func(data []byte) (interface{}, error) {
+		decodes++
+		var evt structEvent
+		err := json.Unmarshal(data, &evt)
+		return &evt, err
+	})
+	data := []byte(`{"user":"bob","uid":42,"tags":["a","b"],"env":{"HOME":"/root"}}`)
+
+	extract := func() {
+		t.Helper()
+		if v, ok, err := r.extractStr(1, 0, "", data, cache); v != "bob" || !ok || err != nil {
+			t.Fatalf("test.user: got (%q, %t, %v)", v, ok, err)
+		}
+		if v, ok, err := r.extractU64(1, 1, "", data, cache); v != 42 || !ok || err != nil {
+			t.Fatalf("test.uid: got (%d, %t, %v)", v, ok, err)
+		}
+		if v, ok, err := r.extractStr(1, 2, "1", data, cache); v != "b" || !ok || err != nil {
+			t.Fatalf("test.tag[1]: got (%q, %t, %v)", v, ok, err)
+		}
+		if _, ok, err := r.extractStr(1, 2, "2", data, cache); ok || err != nil {
+			t.Fatalf("test.tag[2]: got (%t, %v), want not present", ok, err)
+		}
+		if v, ok, err := r.extractStr(1, 3, "HOME", data, cache); v != "/root" || !ok || err != nil {
+			t.Fatalf("test.env[HOME]: got (%q, %t, %v)", v, ok, err)
+		}
+	}
+
+	// All the fields of the event share the cached payload
+	extract()
+	if decodes != 1 {
+		t.Fatalf("got %d decodes, want 1", decodes)
+	}
+
+	// Resetting the cache, as done when a capture is opened, decodes it again
+	cache.Reset()
+	extract()
+	if decodes != 2 {
+		t.Fatalf("got %d decodes after Reset, want 2", decodes)
+	}
+}
+
+func TestStructFieldsErrors(t *testing.T) {
+	fields, err := StructFields(&structEvent{})
+	if err != nil {
+		t.Fatalf("StructFields: %s", err)
+	}
+	r, err := NewFieldRegistry(fields)
+	if err != nil {
+		t.Fatalf("NewFieldRegistry: %s", err)
+	}
+	data := []byte(`{"user":"bob"}`)
+
+	// Without a decode cache
+	if _, _, err := r.extractStr(1, 0, "", data, nil); err == nil {
+		t.Errorf("no decode cache: no error")
+	}
+
+	// With a decoder returning another type
+	cache := NewDecodeCache(1, func(data []byte) (interface{}, error) {
+		return string(data), nil
+	})
+	if _, _, err := r.extractStr(1, 0, "", data, cache); err == nil {
+		t.Errorf("wrong decoded type: no error")
+	}
+
+	// With a payload that cannot be decoded
+	cache = NewDecodeCache(1, func(data []byte) (interface{}, error) {
+		var evt structEvent
+		err := json.Unmarshal(data, &evt)
+		return &evt, err
+	})
+	if _, _, err := r.extractStr(2, 0, "", []byte("notjson"), cache); err == nil {
+		t.Errorf("invalid payload: no error")
+	}
+}
+
+type Exported struct {
+	Host string `json:"host" sinsp:"test.host,desc=Host"`
+}
+
+type unexported struct {
+	Secret string `json:"secret" sinsp:"test.secret,desc=Secret"`
+}
+
+type addrEvent struct {
+	Exported
+	unexported
+	Remote net.IP     `json:"remote" sinsp:"test.remote,desc=Remote address"`
+	Net    *net.IPNet `json:"-" sinsp:"test.net,desc=Network"`
+	Raw    []byte     `json:"raw" sinsp:"test.raw,desc=Raw bytes"`
+}
+
+func TestStructFieldsStringRendering(t *testing.T) {
+	fields, err := StructFields(addrEvent{})
+	if err != nil {
+		t.Fatalf("StructFields: %s", err)
+	}
+	r, err := NewFieldRegistry(fields)
+	if err != nil {
+		t.Fatalf("NewFieldRegistry: %s", err)
+	}
+
+	// The members of the embedded struct of an unexported type are skipped
+	var names []string
+	for _, e := range r.Entries() {
+		if e.Type != FieldTypeString {
+			t.Errorf("field %s: got type %s, want %s", e.Name, e.Type, FieldTypeString)
+		}
+		names = append(names, e.Name)
+	}
+	if got, want := strings.Join(names, ","), "test.host,test.remote,test.net,test.raw"; got != want {
+		t.Fatalf("got fields %s, want %s", got, want)
+	}
+
+	_, ipNet, _ := net.ParseCIDR("10.0.0.0/8")
+	evt := &addrEvent{
+		Exported: Exported{Host: "h"},
+		Remote:   net.ParseIP("2001:db8::1"),
+		Net:      ipNet,
+		Raw:      []byte{0xca, 0xfe},
+	}
+	cache := NewDecodeCache(1, func(data []byte) (interface{}, error) {
+		return evt, nil
+	})
+	for id, want := range []string{"h", "2001:db8::1", "10.0.0.0/8", "cafe"} {
+		if v, ok, err := r.extractStr(1, uint32(id), "", nil, cache); v != want || !ok || err != nil {
+			t.Errorf("field %d: got (%q, %t, %v), want %q", id, v, ok, err, want)
+		}
+	}
+
+	// An empty address and a nil network are not present
+	evt.Remote, evt.Net = nil, nil
+	cache.Reset()
+	for _, id := range []uint32{1, 2} {
+		if v, ok, err := r.extractStr(1, id, "", nil, cache); ok || err != nil {
+			t.Errorf("field %d: got (%q, %t, %v), want not present", id, v, ok, err)
+		}
+	}
+}
+
+type listNode struct {
+	Name     string `json:"name" sinsp:"test.node.name,desc=Node name"`
+	Next     *listNode
+	Children []listNode
+}
+
+func TestStructFieldsRecursiveType(t *testing.T) {
+	fields, err := StructFields(listNode{})
+	if err != nil {
+		t.Fatalf("StructFields: %s", err)
+	}
+	if len(fields) != 1 || fields[0].Name != "test.node.name" {
+		t.Fatalf("got %d fields, want test.node.name only", len(fields))
+	}
+}
+
+type presenceEvent struct {
+	Count int       `json:"count" sinsp:"test.count,desc=Count"`
+	Ok    bool      `json:"ok" sinsp:"test.ok,desc=Ok"`
+	Ratio float64   `json:"ratio" sinsp:"test.ratio,desc=Ratio"`
+	Addr  net.IP    `json:"addr" sinsp:"test.addr,desc=Address"`
+	Net   net.IPNet `json:"-" sinsp:"test.net,desc=Network"`
+}
+
+func TestStructFieldsPresence(t *testing.T) {
+	fields, err := StructFields(presenceEvent{})
+	if err != nil {
+		t.Fatalf("StructFields: %s", err)
+	}
+	r, err := NewFieldRegistry(fields)
+	if err != nil {
+		t.Fatalf("NewFieldRegistry: %s", err)
+	}
+	cache := NewDecodeCache(1, func(data []byte) (interface{}, error) {
+		var evt presenceEvent
+		err := json.Unmarshal(data, &evt)
+		return &evt, err
+	})
+	data := []byte(`{"addr":""}`)
+
+	// Non-pointer scalars missing from the payload are present with their zero value
+	for id, name := range []string{"test.count", "test.ok", "test.ratio"} {
+		if v, ok, err := r.extractU64(1, uint32(id), "", data, cache); v != 0 || !ok || err != nil {
+			t.Errorf("%s: got (%d, %t, %v), want present 0", name, v, ok, err)
+		}
+	}
+
+	// An empty address and a zero network are not present
+	for _, id := range []uint32{3, 4} {
+		if v, ok, err := r.extractStr(1, id, "", data, cache); ok || err != nil {
+			t.Errorf("field %d: got (%q, %t, %v), want not present", id, v, ok, err)
+		}
+	}
+}
+
+type untaggedLeaf struct {
+	Values []string
+	Attrs  map[int]string
+}
+
+type untaggedItem struct {
+	Name  string `json:"name" sinsp:"test.item.name,desc=Item name"`
+	Sub   []untaggedLeaf
+	Extra map[string][]untaggedLeaf
+	Raw   json.RawMessage  `json:"raw" sinsp:"test.item.raw,desc=Raw item"`
+	MAC   net.HardwareAddr `json:"-" sinsp:"test.item.mac,desc=Item MAC"`
+}
+
+type taggedNested struct {
+	Items []struct {
+		Sub []string `sinsp:"test.sub,desc=Sub"`
+	}
+}
+
+func TestStructFieldsNestedContainers(t *testing.T) {
+	// Nested containers without fields are ignored, and byte slices are single values
+	fields, err := StructFields(struct {
+		Items []untaggedItem
+	}{})
+	if err != nil {
+		t.Fatalf("StructFields: %s", err)
+	}
+	var names []string
+	for _, f := range fields {
+		if f.Arg == nil || !f.Arg.Index || f.ExtractStr == nil {
+			t.Errorf("field %s: want a string field taking an index", f.Name)
+		}
+		names = append(names, f.Name)
+	}
+	if got, want := strings.Join(names, ","), "test.item.name,test.item.raw,test.item.mac"; got != want {
+		t.Fatalf("got fields %s, want %s", got, want)
+	}
+
+	// A field declared under two containers is still an error
+	if _, err := StructFields(taggedNested{}); err == nil || !strings.Contains(err.Error(), "nested slices and maps") {
+		t.Fatalf("StructFields: got %v, want a nested containers error", err)
+	}
+}
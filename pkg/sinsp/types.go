@@ -8,3 +8,9 @@ type PluginExtractStrFunc func(pluginState unsafe.Pointer, evtnum uint64, id uin
 
 // PluginExtractStrFunc represents one common signature for the implementation of the `plugin_event_to_string()`
 type PluginExtractU64Func func(pluginState unsafe.Pointer, evtnum uint64, id uint32, arg *byte, data *byte, datalen uint32, field_present *uint32) uint64
+
+// PluginExtractBufFunc represents one common signature for the implementation of the `plugin_extract_buf()`,
+// used for the fields whose value is a byte buffer. The length of the returned buffer is stored in reslen.
+// plugin_extract_buf is an extension of this SDK, only called by the hosts that know about it, such as
+// the host package: libsinsp does not extract buffer fields from plugins.
+type PluginExtractBufFunc func(pluginState unsafe.Pointer, evtnum uint64, id uint32, arg *byte, data *byte, datalen uint32, reslen *uint32) *byte